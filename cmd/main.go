@@ -11,9 +11,10 @@ import (
 	"github.com/mickael-kerjean/filestash/server/model"
 
 	. "github.com/mickael-kerjean/filestash/server/common"
-	_ "github.com/mickael-kerjean/filestash/server/plugin"
 	_ "github.com/mickael-kerjean/filestash/server/pkg"
+	"github.com/mickael-kerjean/filestash/server/pkg/transfer"
 	"github.com/mickael-kerjean/filestash/server/pkg/workflow"
+	_ "github.com/mickael-kerjean/filestash/server/plugin"
 )
 
 func main() {
@@ -24,6 +25,7 @@ func Run(router *mux.Router) {
 	check(InitLogger(), "Logger init failed. err=%s")
 	check(InitConfig(), "Config init failed. err=%s")
 	check(workflow.Init(), "Worklow Initialisation failure. err=%s")
+	check(transfer.Init(), "Transfer Initialisation failure. err=%s")
 	check(model.PluginDiscovery(), "Plugin Discovery failed. err=%s")
 	check(ctrl.InitPluginList(embed.EmbedPluginList, model.PLUGINS), "Plugin Initialisation failed. err=%s")
 	if len(Hooks.Get.Starter()) == 0 {
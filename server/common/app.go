@@ -9,6 +9,7 @@ type App struct {
 	Body          map[string]interface{}
 	Session       map[string]string
 	Share         Share
+	Token         AccessToken
 	Context       context.Context
 	Authorization string
 	Languages     []string
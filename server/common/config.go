@@ -67,6 +67,7 @@ func NewConfiguration() Configuration {
 					FormElement{Name: "name", Type: "text", Default: APPNAME, Description: "Name as shown in the UI", Placeholder: "Default: \"" + APPNAME + "\""},
 					FormElement{Name: "port", Type: "number", Default: 8334, Description: "Port on which the application is available.", Placeholder: "Default: 8334"},
 					FormElement{Name: "host", Type: "text", Description: "The host people need to use to access this server", Placeholder: WhiteLabelText("Eg: \"demo.filestash.app\"", "Eg: \"files.yourcompany.com\"")},
+					FormElement{Name: "trusted_proxies", Type: "text", Default: "", Description: "Addresses of the reverse proxies allowed to give the IP of the client through the X-Forwarded-For and X-Real-Ip headers, comma separated", Placeholder: "Eg: \"127.0.0.1, 10.0.0.0/8\""},
 					FormElement{Name: "secret_key", Type: "password", Required: true, Pattern: "[a-zA-Z0-9]{16}", Description: "The key that's used to encrypt and decrypt content. Update this settings will invalidate existing user sessions and shared links, use with caution!"},
 					FormElement{Name: "force_ssl", Type: "boolean", Description: "Enable the web security mechanism called 'Strict Transport Security'"},
 					FormElement{Name: "editor", Type: "select", Default: "emacs", Opts: []string{"base", "emacs", "vim"}, Description: "Keybinding to be use in the editor. Default: \"emacs\""},
@@ -81,6 +82,7 @@ func NewConfiguration() Configuration {
 					FormElement{Name: "filepage_default_sort", Type: "select", Default: "type", Opts: []string{"type", "date", "name"}, Description: "Default order for files and folder on the file page"},
 					FormElement{Name: "cookie_timeout", Type: "number", Default: 60 * 24 * 7, Description: "Authentication Cookie expiration in minutes. Default: 60 * 24 * 7 = 1 week"},
 					FormElement{Name: "extended_session", Type: "boolean", Default: false, Description: "Store extra auth data in session"},
+					FormElement{Name: "session_registry", Type: "boolean", Default: false, Description: "Keep track of sessions server side so they can be listed and revoked from the admin console. Sessions created while this was disabled are rejected once enabled"},
 					FormElement{Name: "custom_css", Type: "long_text", Default: "", Description: "Setcustom css code for your instance"},
 				},
 			},
@@ -396,6 +398,37 @@ func (this *Configuration) Get(key string) *ConfigElement {
 	return &ConfigElement{currentElement: currentElement, cfg: this}
 }
 
+// Lookup gives the element of a key without creating it when it doesn't exist
+func (this *Configuration) Lookup(key string) (FormElement, bool) {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+	path := strings.Split(key, ".")
+	forms := this.Form
+	for len(path) > 1 {
+		found := false
+		for i := range forms {
+			if forms[i].Title != path[0] {
+				continue
+			} else if len(path) == 2 {
+				for _, el := range forms[i].Elmnts {
+					if el.Name == path[1] {
+						return el, true
+					}
+				}
+				return FormElement{}, false
+			}
+			forms = forms[i].Form
+			found = true
+			break
+		}
+		if found == false {
+			return FormElement{}, false
+		}
+		path = path[1:]
+	}
+	return FormElement{}, false
+}
+
 func (this *ConfigElement) Schema(fn func(*FormElement) *FormElement) *ConfigElement {
 	fn(this.currentElement)
 	this.cfg.cache.Clear()
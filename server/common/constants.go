@@ -17,6 +17,12 @@ var (
 	URL_SETUP         = "/admin/setup"
 )
 
+const (
+	ACCESS_TOKEN_PREFIX      = "fspat_"
+	ACCESS_TOKEN_SCOPE_READ  = "read"
+	ACCESS_TOKEN_SCOPE_WRITE = "write"
+)
+
 var (
 	CONFIG_PATH = "state/config/"
 	CERT_PATH   = "state/certs/"
@@ -24,6 +30,7 @@ var (
 	DB_PATH     = "state/db/"
 	FTS_PATH    = "state/search/"
 	LOG_PATH    = "state/log/"
+	THUMB_PATH  = "state/thumbnails/"
 	TMP_PATH    = "cache/"
 )
 
@@ -37,6 +44,7 @@ func init() {
 	CONFIG_PATH = filepath.Join(rootPath, CONFIG_PATH)
 	DB_PATH = filepath.Join(rootPath, DB_PATH)
 	FTS_PATH = filepath.Join(rootPath, FTS_PATH)
+	THUMB_PATH = filepath.Join(rootPath, THUMB_PATH)
 	CERT_PATH = filepath.Join(rootPath, CERT_PATH)
 	TMP_PATH = filepath.Join(rootPath, TMP_PATH)
 	PLUGIN_PATH = filepath.Join(rootPath, PLUGIN_PATH)
@@ -50,6 +58,7 @@ func init() {
 	os.MkdirAll(GetAbsolutePath(DB_PATH), os.ModePerm)
 	os.MkdirAll(GetAbsolutePath(FTS_PATH), os.ModePerm)
 	os.MkdirAll(GetAbsolutePath(LOG_PATH), os.ModePerm)
+	os.MkdirAll(GetAbsolutePath(THUMB_PATH), os.ModePerm)
 	os.MkdirAll(GetAbsolutePath(PLUGIN_PATH), os.ModePerm)
 	os.RemoveAll(GetAbsolutePath(TMP_PATH))
 	os.MkdirAll(GetAbsolutePath(TMP_PATH), os.ModePerm)
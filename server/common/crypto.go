@@ -16,6 +16,7 @@ import (
 	"os"
 	"runtime"
 	"sort"
+	"strings"
 	"sync"
 )
 
@@ -203,6 +204,7 @@ func GenerateID(params map[string]string) string {
 		case "password":
 		case "path":
 		case "session":
+		case "sid":
 		case "timestamp":
 		default:
 			if val := params[key]; val != "" {
@@ -217,6 +219,25 @@ func GenerateID(params map[string]string) string {
 	return Hash(p, 20)
 }
 
+// Create an ID that identify the storage a session is connected to. Unlike GenerateID, the
+// credentials are left out so that users reaching the same storage with different accounts get
+// the same ID
+func GenerateStorageID(params map[string]string) string {
+	storage := map[string]string{}
+	for key, val := range params {
+		switch key {
+		case "user", "username", "label", "passphrase":
+		default:
+			if strings.Contains(key, "password") || strings.Contains(key, "secret") ||
+				strings.Contains(key, "token") || strings.Contains(key, "key") {
+				continue
+			}
+			storage[key] = val
+		}
+	}
+	return GenerateID(storage)
+}
+
 // Create an ID that identify a machine
 func GenerateMachineID() string {
 	if runtime.GOOS == "linux" {
@@ -18,6 +18,7 @@ var (
 	ErrPermissionDenied     = NewError("Permission Denied", 403)
 	ErrNotValid             = NewError("Not Valid", 405)
 	ErrConflict             = NewError("Already exist", 409)
+	ErrLocked               = NewError("Locked", 423)
 	ErrNotReachable         = NewError("Cannot establish a connection", 502)
 	ErrInvalidPassword      = NewError("Invalid Password", 403)
 	ErrNotImplemented       = NewError("Not Implemented", 501)
@@ -176,6 +176,20 @@ func (this Get) Thumbnailer() map[string]IThumbnailer {
 	return thumbnailer
 }
 
+/*
+ * TextExtractor converts a document onto plain text, it's what full text search plugins like
+ * plg_search_sqlitefts rely on to know what is inside a pdf, an office document, an email, ...
+ */
+var text_extractor map[string]func(io.ReadCloser) (io.ReadCloser, error) = make(map[string]func(io.ReadCloser) (io.ReadCloser, error))
+
+func (this Register) TextExtractor(mimeType string, fn func(io.ReadCloser) (io.ReadCloser, error)) {
+	text_extractor[mimeType] = fn
+}
+
+func (this Get) TextExtractor() map[string]func(io.ReadCloser) (io.ReadCloser, error) {
+	return text_extractor
+}
+
 /*
  * Pluggable Audit interface
  */
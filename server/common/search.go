@@ -0,0 +1,484 @@
+package common
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+/*
+ * SearchQuery is what a search engine receives. The query syntax supports:
+ * - free text: `invoice`, `report*` and quoted phrases: `"annual report"`
+ * - filters:
+ *   - type:pdf, type:image, type:directory, type:file
+ *   - ext:docx
+ *   - size:>10MB, size:<=500k
+ *   - modified:<2025-01-01, modified:2025-03 (anything from that month)
+ *   - path:/projects
+ *   - name:report*.pdf
+ *   - tag:paid, meta.client:acme => metadata set on the files, see IMetadata
+ * - boolean operators: AND (implicit), OR, NOT or `-`, and parenthesis for grouping
+ */
+type SearchQuery struct {
+	Raw  string
+	Root *SearchNode // nil when the query is empty
+}
+
+const (
+	SEARCH_AND    = "and"
+	SEARCH_OR     = "or"
+	SEARCH_NOT    = "not"
+	SEARCH_TERM   = "term"
+	SEARCH_FILTER = "filter"
+)
+
+type SearchNode struct {
+	Kind     string
+	Children []*SearchNode
+	Value    string // the text of a term or the value of a filter
+	Phrase   bool   // quoted term
+	Field    string // filter: type, ext, size, modified, path, name
+	Operator string // filter: =, >, >=, <, <=
+	Size     int64  // size filter in bytes
+	From     time.Time
+	To       time.Time       // modified filter: the period [From, To) the date refers to
+	Paths    map[string]bool // metadata filter: the matching paths, resolved before reaching the engine
+}
+
+type SearchResult struct {
+	File
+	Score   float64 `json:"score,omitempty"`
+	Snippet string  `json:"snippet,omitempty"`
+}
+
+var searchFields = map[string]bool{
+	"type": true, "ext": true, "size": true, "modified": true, "path": true, "name": true,
+	"tag": true,
+}
+
+func ParseSearchQuery(raw string) (SearchQuery, error) {
+	p := &searchParser{tokens: searchTokenize(raw)}
+	query := SearchQuery{Raw: raw}
+	if len(p.tokens) == 0 {
+		return query, nil
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return query, err
+	} else if p.pos < len(p.tokens) {
+		return query, NewError(fmt.Sprintf("Invalid search query near '%s'", p.tokens[p.pos].text), 400)
+	}
+	query.Root = root
+	return query, nil
+}
+
+func (this SearchQuery) IsEmpty() bool {
+	return this.Root == nil
+}
+
+// Terms returns the free text a result should contain, ignoring what sits behind a NOT. It's
+// what engines rely on to rank and highlight results
+func (this SearchQuery) Terms() []*SearchNode {
+	terms := []*SearchNode{}
+	var walk func(n *SearchNode)
+	walk = func(n *SearchNode) {
+		if n == nil || n.Kind == SEARCH_NOT {
+			return
+		} else if n.Kind == SEARCH_TERM {
+			terms = append(terms, n)
+			return
+		}
+		for _, c := range n.Children {
+			walk(c)
+		}
+	}
+	walk(this.Root)
+	return terms
+}
+
+// Filters gives the filters of a given kind, eg: to resolve the path filters against a chroot
+func (this SearchQuery) Filters(field string) []*SearchNode {
+	return this.find(func(n *SearchNode) bool {
+		return n.Kind == SEARCH_FILTER && n.Field == field
+	})
+}
+
+// MetadataFilters gives the filters that can only be resolved through the metadata plugin
+func (this SearchQuery) MetadataFilters() []*SearchNode {
+	return this.find(func(n *SearchNode) bool {
+		return n.IsMetadata()
+	})
+}
+
+func (this SearchQuery) find(fn func(n *SearchNode) bool) []*SearchNode {
+	nodes := []*SearchNode{}
+	var walk func(n *SearchNode)
+	walk = func(n *SearchNode) {
+		if n == nil {
+			return
+		} else if fn(n) {
+			nodes = append(nodes, n)
+		}
+		for _, c := range n.Children {
+			walk(c)
+		}
+	}
+	walk(this.Root)
+	return nodes
+}
+
+// Match tells if a file satisfies the query, engines tell how free text is matched
+func (this SearchQuery) Match(path string, f os.FileInfo, text func(term *SearchNode) bool) bool {
+	if this.Root == nil {
+		return true
+	}
+	return this.Root.Match(path, f, text)
+}
+
+func (this *SearchNode) Match(path string, f os.FileInfo, text func(term *SearchNode) bool) bool {
+	switch this.Kind {
+	case SEARCH_AND:
+		for _, c := range this.Children {
+			if c.Match(path, f, text) == false {
+				return false
+			}
+		}
+		return true
+	case SEARCH_OR:
+		for _, c := range this.Children {
+			if c.Match(path, f, text) {
+				return true
+			}
+		}
+		return false
+	case SEARCH_NOT:
+		return this.Children[0].Match(path, f, text) == false
+	case SEARCH_TERM:
+		return text(this)
+	}
+
+	switch this.Field {
+	case "type":
+		return SearchMatchType(this.Value, f.Name(), f.IsDir())
+	case "ext":
+		return f.IsDir() == false && strings.ToLower(strings.TrimPrefix(filepath.Ext(f.Name()), ".")) == this.Value
+	case "size":
+		return f.IsDir() == false && compareSearch(this.Operator, f.Size(), this.Size)
+	case "modified":
+		t := f.ModTime()
+		switch this.Operator {
+		case "<":
+			return t.Before(this.From)
+		case "<=":
+			return t.Before(this.To)
+		case ">":
+			return t.Before(this.To) == false
+		case ">=":
+			return t.Before(this.From) == false
+		}
+		return t.Before(this.From) == false && t.Before(this.To)
+	case "path":
+		return strings.HasPrefix(path, this.Value)
+	case "name":
+		return GlobMatch(strings.ToLower(this.Value), strings.ToLower(f.Name()))
+	}
+	if this.IsMetadata() {
+		return this.Paths[path]
+	}
+	return false
+}
+
+// IsMetadata tells if a filter relates to the metadata of a file rather than the file itself
+func (this *SearchNode) IsMetadata() bool {
+	return this.Kind == SEARCH_FILTER && (this.Field == "tag" || strings.HasPrefix(this.Field, "meta."))
+}
+
+// SearchMatchType is the logic behind the type filter: either a broad category like "image" or
+// "directory" or something that can be found in the mime type like "pdf"
+func SearchMatchType(value string, name string, isDir bool) bool {
+	switch value {
+	case "directory", "folder", "dir":
+		return isDir
+	case "file":
+		return isDir == false
+	}
+	if isDir {
+		return false
+	}
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(name), "."))
+	if ext == value {
+		return true
+	}
+	mType := MimeTypes[ext]
+	if mType == "" {
+		return false
+	}
+	return strings.HasPrefix(mType, value+"/") || strings.Contains(strings.SplitN(mType, "/", 2)[1], value)
+}
+
+// SearchTypeExtensions lists the extensions matching a type filter, it's what engines that
+// can't run SearchMatchType rely on
+func SearchTypeExtensions(value string) []string {
+	exts := []string{value}
+	for ext := range MimeTypes {
+		if ext != value && SearchMatchType(value, "file."+ext, false) {
+			exts = append(exts, ext)
+		}
+	}
+	sort.Strings(exts)
+	return exts
+}
+
+func compareSearch(op string, a int64, b int64) bool {
+	switch op {
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	}
+	return a == b
+}
+
+type searchToken struct {
+	text   string
+	quoted bool // starts with a quote, eg: a phrase
+	paren  rune
+}
+
+func searchTokenize(raw string) []searchToken {
+	tokens := []searchToken{}
+	runes := []rune(raw)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		if unicode.IsSpace(r) {
+			i += 1
+			continue
+		} else if r == '(' || r == ')' {
+			tokens = append(tokens, searchToken{paren: r})
+			i += 1
+			continue
+		}
+		token := searchToken{}
+		buf := strings.Builder{}
+		for i < len(runes) && unicode.IsSpace(runes[i]) == false && runes[i] != ')' && (runes[i] != '(' || (buf.Len() > 0 && buf.String() != "-")) {
+			if runes[i] == '"' {
+				end := i + 1
+				for end < len(runes) && runes[end] != '"' {
+					end += 1
+				}
+				if buf.Len() == 0 {
+					token.quoted = true
+				}
+				buf.WriteString(string(runes[i+1 : min(end, len(runes))]))
+				i = end + 1
+				continue
+			}
+			buf.WriteRune(runes[i])
+			i += 1
+		}
+		token.text = buf.String()
+		if token.text == "" {
+			continue
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens
+}
+
+type searchParser struct {
+	tokens []searchToken
+	pos    int
+}
+
+func (this *searchParser) peek() *searchToken {
+	if this.pos >= len(this.tokens) {
+		return nil
+	}
+	return &this.tokens[this.pos]
+}
+
+func (this *searchParser) isKeyword(t *searchToken, keyword string) bool {
+	return t != nil && t.quoted == false && t.paren == 0 && t.text == keyword
+}
+
+func (this *searchParser) parseOr() (*SearchNode, error) {
+	node, err := this.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	children := []*SearchNode{node}
+	for this.isKeyword(this.peek(), "OR") {
+		this.pos += 1
+		if node, err = this.parseAnd(); err != nil {
+			return nil, err
+		}
+		children = append(children, node)
+	}
+	if len(children) == 1 {
+		return children[0], nil
+	}
+	return &SearchNode{Kind: SEARCH_OR, Children: children}, nil
+}
+
+func (this *searchParser) parseAnd() (*SearchNode, error) {
+	children := []*SearchNode{}
+	for {
+		t := this.peek()
+		if t == nil || t.paren == ')' || this.isKeyword(t, "OR") {
+			break
+		} else if this.isKeyword(t, "AND") {
+			this.pos += 1
+			continue
+		}
+		node, err := this.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, node)
+	}
+	if len(children) == 0 {
+		return nil, NewError("Invalid search query: missing expression", 400)
+	} else if len(children) == 1 {
+		return children[0], nil
+	}
+	return &SearchNode{Kind: SEARCH_AND, Children: children}, nil
+}
+
+func (this *searchParser) parseUnary() (*SearchNode, error) {
+	t := this.peek()
+	if t == nil {
+		return nil, NewError("Invalid search query: missing expression", 400)
+	} else if this.isKeyword(t, "NOT") {
+		this.pos += 1
+		node, err := this.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &SearchNode{Kind: SEARCH_NOT, Children: []*SearchNode{node}}, nil
+	} else if t.paren == 0 && t.quoted == false && strings.HasPrefix(t.text, "-") {
+		if t.text = t.text[1:]; t.text == "" {
+			this.pos += 1
+		}
+		node, err := this.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &SearchNode{Kind: SEARCH_NOT, Children: []*SearchNode{node}}, nil
+	}
+	return this.parsePrimary()
+}
+
+func (this *searchParser) parsePrimary() (*SearchNode, error) {
+	t := this.peek()
+	this.pos += 1
+	if t.paren == '(' {
+		node, err := this.parseOr()
+		if err != nil {
+			return nil, err
+		} else if next := this.peek(); next == nil || next.paren != ')' {
+			return nil, NewError("Invalid search query: missing closing parenthesis", 400)
+		}
+		this.pos += 1
+		return node, nil
+	} else if t.paren == ')' {
+		return nil, NewError("Invalid search query: unexpected closing parenthesis", 400)
+	}
+	if idx := strings.Index(t.text, ":"); t.quoted == false && idx > 0 {
+		if field := strings.ToLower(t.text[:idx]); searchFields[field] {
+			return parseSearchFilter(field, t.text[idx+1:])
+		} else if strings.HasPrefix(field, "meta.") && len(field) > 5 {
+			return parseSearchFilter("meta."+t.text[5:idx], t.text[idx+1:])
+		}
+	}
+	return &SearchNode{Kind: SEARCH_TERM, Value: t.text, Phrase: t.quoted || strings.Contains(t.text, " ")}, nil
+}
+
+func parseSearchFilter(field string, value string) (*SearchNode, error) {
+	node := &SearchNode{Kind: SEARCH_FILTER, Field: field, Operator: "="}
+	if field == "size" || field == "modified" {
+		for _, op := range []string{">=", "<=", ">", "<", "="} {
+			if strings.HasPrefix(value, op) {
+				node.Operator = op
+				value = strings.TrimPrefix(value, op)
+				break
+			}
+		}
+	}
+	if value == "" {
+		return nil, NewError(fmt.Sprintf("Invalid search query: missing value for '%s'", field), 400)
+	}
+	node.Value = value
+
+	var err error
+	switch field {
+	case "type", "ext":
+		node.Value = strings.ToLower(strings.TrimPrefix(value, "."))
+	case "size":
+		if node.Size, err = parseSearchSize(value); err != nil {
+			return nil, err
+		}
+	case "modified":
+		if node.From, node.To, err = parseSearchDate(value); err != nil {
+			return nil, err
+		}
+	case "path":
+		node.Value = "/" + strings.Trim(value, "/") + "/"
+		if node.Value == "//" {
+			node.Value = "/"
+		}
+	}
+	return node, nil
+}
+
+func parseSearchSize(value string) (int64, error) {
+	v := strings.ToUpper(strings.TrimSpace(value))
+	unit := int64(1)
+	for _, u := range []struct {
+		suffix string
+		size   int64
+	}{
+		{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+		{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1},
+	} {
+		if strings.HasSuffix(v, u.suffix) {
+			unit = u.size
+			v = strings.TrimSuffix(v, u.suffix)
+			break
+		}
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil || f < 0 {
+		return 0, NewError(fmt.Sprintf("Invalid search query: '%s' isn't a size", value), 400)
+	}
+	return int64(f * float64(unit)), nil
+}
+
+func parseSearchDate(value string) (time.Time, time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, t.AddDate(0, 0, 1), nil
+	} else if t, err := time.ParseInLocation("2006-01", value, time.Local); err == nil {
+		return t, t.AddDate(0, 1, 0), nil
+	} else if t, err := time.ParseInLocation("2006", value, time.Local); err == nil {
+		return t, t.AddDate(1, 0, 0), nil
+	}
+	switch strings.ToLower(value) {
+	case "today":
+		y, m, d := time.Now().Date()
+		t := time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+		return t, t.AddDate(0, 0, 1), nil
+	case "yesterday":
+		y, m, d := time.Now().AddDate(0, 0, -1).Date()
+		t := time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+		return t, t.AddDate(0, 0, 1), nil
+	}
+	return time.Time{}, time.Time{}, NewError(fmt.Sprintf("Invalid search query: '%s' isn't a date, expected YYYY-MM-DD", value), 400)
+}
@@ -21,6 +21,14 @@ type IBackend interface {
 	LoginForm() Form
 }
 
+// IBackendCopy is an optional capability for backends that can copy data without
+// having it transit through our server, eg: S3 CopyObject, cp on a remote host, ...
+// A backend that can't handle a particular copy returns ErrNotSupported to fallback
+// on streaming the content with Cat and Save
+type IBackendCopy interface {
+	Cp(from string, to string) error
+}
+
 type IAuthentication interface {
 	Setup() Form
 	EntryPoint(idpParams map[string]string, req *http.Request, res http.ResponseWriter) error
@@ -34,6 +42,7 @@ type IAuthorisation interface {
 	Mkdir(ctx *App, path string) error
 	Rm(ctx *App, path string) error
 	Mv(ctx *App, from string, to string) error
+	Cp(ctx *App, from string, to string) error
 	Save(ctx *App, path string) error
 	Touch(ctx *App, path string) error
 }
@@ -44,7 +53,7 @@ type IFile interface {
 }
 
 type ISearch interface {
-	Query(ctx App, basePath string, term string) ([]IFile, error)
+	Query(ctx App, basePath string, query SearchQuery) ([]IFile, error)
 }
 
 type ILogger interface {
@@ -198,6 +207,31 @@ func (s Share) IsValid() error {
 	return nil
 }
 
+// AccessToken is a personal access token: a named, scoped and expiring credential which stands
+// for the session of the user who created it
+type AccessToken struct {
+	Id        string `json:"id"`
+	Name      string `json:"name"`
+	Backend   string `json:"-"`
+	Auth      string `json:"-"`
+	Path      string `json:"path"`
+	Scope     string `json:"scope"`
+	CreatedAt int64  `json:"created_at"`
+	Expire    int64  `json:"expire"`
+	LastUsed  *int64 `json:"last_used,omitempty"`
+}
+
+func (t AccessToken) IsValid() error {
+	if time.Now().UnixNano()/1000000 > t.Expire {
+		return NewError("Token has expired", 401)
+	}
+	return nil
+}
+
+func (t AccessToken) CanWrite() bool {
+	return t.Scope == ACCESS_TOKEN_SCOPE_WRITE
+}
+
 func (s *Share) MarshalJSON() ([]byte, error) {
 	p := Share{
 		s.Id,
@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
+	"net/http"
 	"strconv"
 	"strings"
 )
@@ -100,3 +102,42 @@ func CookieName(idx int) string {
 	}
 	return COOKIE_NAME_AUTH + strconv.Itoa(idx)
 }
+
+// ClientIP gives the address of whoever made the request. The X-Forwarded-For and X-Real-Ip
+// headers are only trusted when the request comes from one of the reverse proxy listed under
+// "general.trusted_proxies", anybody could set them otherwise
+func ClientIP(req *http.Request) string {
+	remote, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		remote = req.RemoteAddr
+	}
+	if isTrustedProxy(remote) == false {
+		return remote
+	}
+	if xff := req.Header.Get("X-Forwarded-For"); xff != "" {
+		return strings.TrimSpace(strings.Split(xff, ",")[0])
+	} else if xrip := req.Header.Get("X-Real-Ip"); xrip != "" {
+		return strings.TrimSpace(xrip)
+	}
+	return remote
+}
+
+func isTrustedProxy(remote string) bool {
+	ip := net.ParseIP(remote)
+	if ip == nil {
+		return false
+	}
+	for _, proxy := range strings.Split(Config.Get("general.trusted_proxies").String(), ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		} else if _, cidr, err := net.ParseCIDR(proxy); err == nil {
+			if cidr.Contains(ip) {
+				return true
+			}
+		} else if p := net.ParseIP(proxy); p != nil && p.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -3,12 +3,15 @@ package ctrl
 import (
 	"encoding/json"
 	. "github.com/mickael-kerjean/filestash/server/common"
+	"github.com/mickael-kerjean/filestash/server/model"
 	"golang.org/x/crypto/bcrypt"
 	"io"
 	"net/http"
 	"os"
 	"strconv"
 	"time"
+
+	"github.com/gorilla/mux"
 )
 
 var logpath = GetAbsolutePath(LOG_PATH, "access.log")
@@ -145,6 +148,20 @@ func FetchAuditHandler(ctx *App, res http.ResponseWriter, req *http.Request) {
 		}
 		searchParams[key] = element[0]
 	}
+	if searchParams["format"] != "" {
+		obj, ok := plg.(interface {
+			Export(ctx *App, searchParams map[string]string, res http.ResponseWriter) error
+		})
+		if ok == false {
+			SendErrorResult(res, ErrNotSupported)
+			return
+		}
+		if err := obj.Export(ctx, searchParams, res); err != nil {
+			Log.Debug("admin::audit export err=%s", err.Error())
+			SendErrorResult(res, err)
+		}
+		return
+	}
 	result, err := plg.Query(ctx, searchParams)
 	if err != nil {
 		SendErrorResult(res, err)
@@ -152,3 +169,60 @@ func FetchAuditHandler(ctx *App, res http.ResponseWriter, req *http.Request) {
 	}
 	SendSuccessResult(res, result)
 }
+
+func AdminSessionList(ctx *App, res http.ResponseWriter, req *http.Request) {
+	if model.SessionRegistryEnabled() == false {
+		SendErrorResult(res, NewError("Session registry isn't enabled", 405))
+		return
+	}
+	sessions, err := model.SessionRegistryList()
+	if err != nil {
+		SendErrorResult(res, err)
+		return
+	}
+	SendSuccessResults(res, sessions)
+}
+
+func AdminSessionRevoke(ctx *App, res http.ResponseWriter, req *http.Request) {
+	if model.SessionRegistryEnabled() == false {
+		SendErrorResult(res, NewError("Session registry isn't enabled", 405))
+		return
+	}
+	sessionID := mux.Vars(req)["sessionID"]
+	if err := model.SessionRegistryRevoke(sessionID); err != nil {
+		SendErrorResult(res, err)
+		return
+	}
+	Log.Info("[auth] status=revoked session=%s ip=%s", sessionID, ClientIP(req))
+	SendSuccessResult(res, nil)
+}
+
+func AdminLockList(ctx *App, res http.ResponseWriter, req *http.Request) {
+	locks, err := model.LockList()
+	if err != nil {
+		SendErrorResult(res, err)
+		return
+	}
+	SendSuccessResults(res, locks)
+}
+
+func AdminLockRelease(ctx *App, res http.ResponseWriter, req *http.Request) {
+	lockID := mux.Vars(req)["lockID"]
+	if err := model.LockForceRelease(lockID); err != nil {
+		SendErrorResult(res, err)
+		return
+	}
+	Log.Info("[lock] status=released id=%s ip=%s", lockID, ClientIP(req))
+	SendSuccessResult(res, nil)
+}
+
+func AdminThumbnailPurge(ctx *App, res http.ResponseWriter, req *http.Request) {
+	n, size, err := model.ThumbnailCachePurge()
+	if err != nil {
+		Log.Error("[thumbnail] status=error action=purge err=%s", err.Error())
+		SendErrorResult(res, err)
+		return
+	}
+	Log.Info("[thumbnail] status=purged count=%d size=%d ip=%s", n, size, ClientIP(req))
+	SendSuccessResult(res, map[string]int64{"count": int64(n), "size": size})
+}
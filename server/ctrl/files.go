@@ -1,7 +1,12 @@
 package ctrl
 
 import (
+	"archive/tar"
 	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
 	"context"
 	"crypto/sha1"
 	"hash/crc32"
@@ -14,6 +19,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -47,7 +53,7 @@ func init() {
 			f.Default = 60
 			f.Name = "zip_timeout"
 			f.Type = "number"
-			f.Description = "Timeout when user wants to download or extract a zip"
+			f.Description = "Timeout when user wants to download or extract a zip. Downloads are only stopped once they didn't make any progress for that long"
 			f.Placeholder = "Default: 60seconds"
 			return f
 		}).Int()
@@ -242,8 +248,35 @@ func FileCat(ctx *App, res http.ResponseWriter, req *http.Request) {
 		}
 	}
 
+	// thumbnails come from the cache when possible so the original doesn't have to be fetched
+	var (
+		mType        = GetMimeType(query.Get("path"))
+		thumb        = query.Get("thumbnail")
+		thumbnailer  IThumbnailer
+		thumbnailKey string
+	)
+	if thumb == "true" {
+		fileMutation = true
+		if finfo, err := ctx.Backend.Stat(path); err == nil && finfo.ModTime().Unix() > 0 {
+			lm := finfo.ModTime().UTC().Format(http.TimeFormat)
+			if lm == req.Header.Get("If-Modified-Since") {
+				res.WriteHeader(http.StatusNotModified)
+				return
+			}
+			header.Set("Last-Modified", lm)
+			thumbnailKey = model.ThumbnailCacheKey(ctx, path, finfo)
+		}
+		thumbnailer = Hooks.Get.Thumbnailer()[mType]
+		if thumbnailer != nil && file == nil {
+			if f, contentType, ok := model.ThumbnailCacheGet(thumbnailKey); ok {
+				file = f
+				thumbnailer = nil
+				header.Set("Content-Type", contentType)
+			}
+		}
+	}
+
 	// perform the actual `cat` if needed
-	mType := GetMimeType(query.Get("path"))
 	if file == nil {
 		if file, err = ctx.Backend.Cat(path); err != nil {
 			if req.Method == http.MethodHead {
@@ -270,31 +303,15 @@ func FileCat(ctx *App, res http.ResponseWriter, req *http.Request) {
 	}
 
 	// plugin hooks
-	thumb := query.Get("thumbnail")
-	if thumb == "true" {
-		fileMutation = true
-		if finfo, err := ctx.Backend.Stat(path); err == nil && finfo.ModTime().Unix() > 0 {
-			lm := finfo.ModTime().UTC().Format(http.TimeFormat)
-			if lm == req.Header.Get("If-Modified-Since") {
-				res.WriteHeader(http.StatusNotModified)
-				return
-			}
-			header.Set("Last-Modified", lm)
-		}
-		for plgMType, plgHandler := range Hooks.Get.Thumbnailer() {
-			if plgMType != mType {
-				continue
-			}
-			file, err = plgHandler.Generate(file, ctx, &res, req)
-			if err != nil {
-				if req.Context().Err() == nil {
-					Log.Debug("cat::thumbnailer '%s'", err.Error())
-				}
-				SendErrorResult(res, err)
-				return
+	if thumbnailer != nil {
+		if file, err = thumbnailer.Generate(file, ctx, &res, req); err != nil {
+			if req.Context().Err() == nil {
+				Log.Debug("cat::thumbnailer '%s'", err.Error())
 			}
-			break
+			SendErrorResult(res, err)
+			return
 		}
+		file = model.ThumbnailCachePut(thumbnailKey, header.Get("Content-Type"), file)
 	}
 	for _, obj := range Hooks.Get.ProcessFileContentBeforeSend() {
 		f, changed, err := obj(file, ctx, &res, req)
@@ -519,6 +536,11 @@ func FileSave(ctx *App, res http.ResponseWriter, req *http.Request) {
 			return
 		}
 	}
+	if err = model.LockCheck(GenerateStorageID(ctx.Session), path); err != nil {
+		Log.Debug("files::save action=lock err=%s", err.Error())
+		SendErrorResult(res, err)
+		return
+	}
 
 	// There is 2 ways to save something:
 	// - case1: regular upload, we just insert the file in the pipe
@@ -764,6 +786,13 @@ func FileMv(ctx *App, res http.ResponseWriter, req *http.Request) {
 			return
 		}
 	}
+	for _, path := range []string{from, to} {
+		if err = model.LockCheck(GenerateStorageID(ctx.Session), path); err != nil {
+			Log.Debug("mv::lock '%s'", err.Error())
+			SendErrorResult(res, err)
+			return
+		}
+	}
 
 	err = ctx.Backend.Mv(from, to)
 	if err != nil {
@@ -771,6 +800,56 @@ func FileMv(ctx *App, res http.ResponseWriter, req *http.Request) {
 		SendErrorResult(res, err)
 		return
 	}
+	if err = model.LockMove(GenerateStorageID(ctx.Session), from, to); err != nil {
+		Log.Warning("mv::lock 'cannot move locks - %s'", err.Error())
+	}
+	SendSuccessResult(res, nil)
+}
+
+func FileCp(ctx *App, res http.ResponseWriter, req *http.Request) {
+	if model.CanRead(ctx) == false || model.CanEdit(ctx) == false {
+		Log.Debug("cp::permission 'permission denied'")
+		SendErrorResult(res, NewError("Permission denied", 403))
+		return
+	}
+
+	from, err := PathBuilder(ctx, req.URL.Query().Get("from"))
+	if err != nil {
+		Log.Debug("cp::path::from '%s'", err.Error())
+		SendErrorResult(res, err)
+		return
+	}
+	to, err := PathBuilder(ctx, req.URL.Query().Get("to"))
+	if err != nil {
+		Log.Debug("cp::path::to '%s'", err.Error())
+		SendErrorResult(res, err)
+		return
+	}
+	if from == "" || to == "" {
+		Log.Debug("cp::params 'missing path parameter'")
+		SendErrorResult(res, NewError("missing path parameter", 400))
+		return
+	}
+
+	for _, auth := range Hooks.Get.AuthorisationMiddleware() {
+		if err = auth.Cp(ctx, from, to); err != nil {
+			Log.Info("cp::auth '%s'", err.Error())
+			SendErrorResult(res, ErrNotAuthorized)
+			return
+		}
+	}
+	if err = model.LockCheck(GenerateStorageID(ctx.Session), to); err != nil {
+		Log.Debug("cp::lock '%s'", err.Error())
+		SendErrorResult(res, err)
+		return
+	}
+
+	err = model.Cp(ctx.Backend, from, to)
+	if err != nil {
+		Log.Debug("cp::backend '%s'", err.Error())
+		SendErrorResult(res, err)
+		return
+	}
 	SendSuccessResult(res, nil)
 }
 
@@ -795,6 +874,11 @@ func FileRm(ctx *App, res http.ResponseWriter, req *http.Request) {
 			return
 		}
 	}
+	if err = model.LockCheck(GenerateStorageID(ctx.Session), path); err != nil {
+		Log.Debug("rm::lock '%s'", err.Error())
+		SendErrorResult(res, err)
+		return
+	}
 
 	err = ctx.Backend.Rm(path)
 	if err != nil {
@@ -826,6 +910,11 @@ func FileMkdir(ctx *App, res http.ResponseWriter, req *http.Request) {
 			return
 		}
 	}
+	if err = model.LockCheck(GenerateStorageID(ctx.Session), path); err != nil {
+		Log.Debug("mkdir::lock '%s'", err.Error())
+		SendErrorResult(res, err)
+		return
+	}
 
 	err = ctx.Backend.Mkdir(path)
 	if err != nil {
@@ -857,6 +946,11 @@ func FileTouch(ctx *App, res http.ResponseWriter, req *http.Request) {
 			return
 		}
 	}
+	if err = model.LockCheck(GenerateStorageID(ctx.Session), path); err != nil {
+		Log.Debug("touch::lock '%s'", err.Error())
+		SendErrorResult(res, err)
+		return
+	}
 
 	err = ctx.Backend.Touch(path)
 	if err != nil {
@@ -874,114 +968,234 @@ func FileDownloader(ctx *App, res http.ResponseWriter, req *http.Request) {
 		SendErrorResult(res, ErrPermissionDenied)
 		return
 	}
-	paths := req.URL.Query()["path"]
+	query := req.URL.Query()
+	paths := query["path"]
 	for i := 0; i < len(paths); i++ {
 		if paths[i], err = PathBuilder(ctx, paths[i]); err != nil {
 			Log.Debug("downloader::path '%s'", err.Error())
 			SendErrorResult(res, err)
 			return
 		}
+		for _, auth := range Hooks.Get.AuthorisationMiddleware() {
+			if err = auth.Ls(ctx, paths[i]); err != nil {
+				Log.Info("downloader::ls::auth path['%s'] => '%s'", paths[i], err.Error())
+				SendErrorResult(res, ErrNotAuthorized)
+				return
+			}
+			if err = auth.Cat(ctx, paths[i]); err != nil {
+				Log.Info("downloader::cat::auth path['%s'] => '%s'", paths[i], err.Error())
+				SendErrorResult(res, ErrNotAuthorized)
+				return
+			}
+		}
 	}
 
-	resHeader := res.Header()
-	resHeader.Set("Content-Type", "application/zip")
-	filename := "download"
-	if len(paths) == 1 {
-		filename = filepath.Base(paths[0])
+	// a stored zip is the only format whose size is known upfront, we need to list everything
+	// beforehand and stick to what we announced
+	format := query.Get("format")
+	if format == "" {
+		format = "zip"
+	}
+	store := false
+	switch query.Get("compression") {
+	case "":
+	case "store":
+		store = true
+	default:
+		SendErrorResult(res, NewError("Unsupported compression", 400))
+		return
 	}
-	resHeader.Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.zip\"", filename))
+	var contentType string
+	switch format {
+	case "zip":
+		contentType = "application/zip"
+	case "tar":
+		contentType = "application/x-tar"
+	case "tar.gz":
+		contentType = "application/gzip"
+	default:
+		SendErrorResult(res, NewError("Unsupported archive format", 400))
+		return
+	}
+	if store && format != "zip" {
+		SendErrorResult(res, NewError("Store only mode is only available for zip", 400))
+		return
+	}
+	exactSize := store || format != "zip"
 
-	start := time.Now()
-	var addToZipRecursive func(*App, *zip.Writer, string, string, *[]string) error
-	addToZipRecursive = func(c *App, zw *zip.Writer, backendPath string, zipRoot string, errList *[]string) (err error) {
-		if time.Now().Sub(start) > time.Duration(zip_timeout())*time.Second {
-			Log.Debug("downloader::timeout zip not completed due to timeout")
-			return ErrTimeout
+	errList := []string{}
+	var walk func(backendPath string, zipRoot string, info os.FileInfo, fn func(downloadEntry) error) error
+	walk = func(backendPath string, zipRoot string, info os.FileInfo, fn func(downloadEntry) error) error {
+		entry := downloadEntry{path: backendPath, name: strings.TrimPrefix(backendPath, zipRoot), size: -1}
+		if info != nil {
+			entry.modTime = info.ModTime()
+			entry.size = info.Size()
 		}
 		if strings.HasSuffix(backendPath, "/") == false {
 			// Process File
-			zipPath := strings.TrimPrefix(backendPath, zipRoot)
-			file, err := ctx.Backend.Cat(backendPath)
-			if err != nil {
-				*errList = append(*errList, fmt.Sprintf("downloader::cat %s %s\n", zipPath, err.Error()))
-				if err == ErrNotReachable {
-					return nil
-				}
-				Log.Debug("downloader::cat backendPath['%s'] zipPath['%s'] error['%s']", backendPath, zipPath, err.Error())
-				return err
-			}
-			zipFile, err := zw.Create(zipPath)
-			if err != nil {
-				*errList = append(*errList, fmt.Sprintf("downloader::create %s %s\n", zipPath, err.Error()))
-				Log.Debug("downloader::create backendPath['%s'] zipPath['%s'] error['%s']", backendPath, zipPath, err.Error())
-				return err
+			if exactSize && entry.size < 0 {
+				errList = append(errList, fmt.Sprintf("downloader::size %s unknown size\n", entry.name))
+				return nil
 			}
-			if _, err = io.Copy(zipFile, file); err != nil {
-				*errList = append(*errList, fmt.Sprintf("downloader::copy %s %s\n", zipPath, err.Error()))
-				Log.Debug("downloader::copy backendPath['%s'] zipPath['%s'] error['%s']", backendPath, zipPath, err.Error())
-				io.Copy(zipFile, strings.NewReader(""))
-				return err
-			}
-			file.Close()
-			return nil
+			return fn(entry)
 		}
 		// Process Folder
-		entries, err := c.Backend.Ls(backendPath)
+		entry.dir = true
+		entry.size = 0
+		if err := fn(entry); err != nil {
+			return err
+		}
+		entries, err := ctx.Backend.Ls(backendPath)
 		if err != nil {
-			*errList = append(*errList, fmt.Sprintf("downloader::ls %s\n", err.Error()))
+			errList = append(errList, fmt.Sprintf("downloader::ls %s %s\n", entry.name, err.Error()))
 			Log.Debug("downloader::ls path['%s'] error['%s']", backendPath, err.Error())
-			return err
+			return nil
 		}
 		for i := 0; i < len(entries); i++ {
 			newBackendPath := backendPath + entries[i].Name()
 			if entries[i].IsDir() {
 				newBackendPath += "/"
 			}
-			if err = addToZipRecursive(ctx, zw, newBackendPath, zipRoot, errList); err != nil {
-				*errList = append(*errList, fmt.Sprintf("downloader::recursive %s\n", err.Error()))
-				Log.Debug("downloader::recursive path['%s'] error['%s']", newBackendPath, err.Error())
+			if err = walk(newBackendPath, zipRoot, entries[i], fn); err != nil {
 				return err
 			}
 		}
 		return nil
 	}
-
-	zipWriter := zip.NewWriter(res)
-	defer zipWriter.Close()
-	errList := []string{}
-	for i := 0; i < len(paths); i++ {
-		zipRoot := ""
-		if strings.HasSuffix(paths[i], "/") {
-			zipRoot = strings.TrimSuffix(paths[i], filepath.Base(paths[i])+"/")
-		} else {
-			zipRoot = strings.TrimSuffix(paths[i], filepath.Base(paths[i]))
-		}
-
-		for _, auth := range Hooks.Get.AuthorisationMiddleware() {
-			if err = auth.Ls(ctx, paths[i]); err != nil {
-				Log.Info("downloader::ls::auth path['%s'] => '%s'", paths[i], err.Error())
-				SendErrorResult(res, ErrNotAuthorized)
-				return
+	walkAll := func(fn func(downloadEntry) error) error {
+		for i := 0; i < len(paths); i++ {
+			zipRoot := ""
+			if strings.HasSuffix(paths[i], "/") {
+				zipRoot = strings.TrimSuffix(paths[i], filepath.Base(paths[i])+"/")
+			} else {
+				zipRoot = strings.TrimSuffix(paths[i], filepath.Base(paths[i]))
 			}
-			if err = auth.Cat(ctx, paths[i]); err != nil {
-				Log.Info("downloader::cat::auth path['%s'] => '%s'", paths[i], err.Error())
-				SendErrorResult(res, ErrNotAuthorized)
-				return
+			info, err := ctx.Backend.Stat(paths[i])
+			if err != nil {
+				if exactSize && strings.HasSuffix(paths[i], "/") == false {
+					errList = append(errList, fmt.Sprintf("downloader::stat %s %s\n", filepath.Base(paths[i]), err.Error()))
+					Log.Debug("downloader::stat path['%s'] error['%s']", paths[i], err.Error())
+					continue
+				}
+				info = nil
+			}
+			if err := walk(paths[i], zipRoot, info, fn); err != nil {
+				return err
 			}
 		}
-		addToZipRecursive(ctx, zipWriter, paths[i], zipRoot, &errList)
+		return nil
 	}
-	if len(errList) > 0 {
-		if errorWriter, err := zipWriter.Create("error.log"); err == nil {
-			for _, e := range errList {
-				io.Copy(errorWriter, strings.NewReader(e))
+	var entries []downloadEntry
+	if store {
+		entries = []downloadEntry{}
+		walkAll(func(e downloadEntry) error {
+			entries = append(entries, e)
+			return nil
+		})
+		walkAll = func(fn func(downloadEntry) error) error {
+			for _, e := range entries {
+				if err := fn(e); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	resHeader := res.Header()
+	resHeader.Set("Content-Type", contentType)
+	filename := "download"
+	if len(paths) == 1 {
+		filename = filepath.Base(paths[0])
+	}
+	resHeader.Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.%s\"", filename, format))
+
+	// instead of a hard limit on how long a download can take, it is only stopped when nothing
+	// happens for longer than the zip timeout
+	live := newDownloadLiveness(res, time.Duration(zip_timeout())*time.Second)
+	defer live.Stop()
+	var archive downloadArchive
+	switch format {
+	case "zip":
+		method := zip.Deflate
+		if store {
+			method = zip.Store
+		}
+		archive = &zipDownloadArchive{zip.NewWriter(live), method, store}
+	case "tar":
+		archive = &tarDownloadArchive{tw: tar.NewWriter(live)}
+	case "tar.gz":
+		gz := gzip.NewWriter(live)
+		archive = &tarDownloadArchive{tw: tar.NewWriter(gz), gz: gz}
+	}
+
+	var errorLog []byte
+	if store {
+		if len(errList) > 0 {
+			errorLog = []byte(strings.Join(errList, ""))
+			entries = append(entries, downloadEntry{name: "error.log", size: int64(len(errorLog))})
+		}
+		resHeader.Set("Content-Length", strconv.FormatInt(zipStoreSize(entries), 10))
+		if len(errList) > 0 {
+			entries = entries[:len(entries)-1]
+		}
+	}
+	err = walkAll(func(e downloadEntry) error {
+		if err := live.Err(); err != nil {
+			return err
+		} else if e.dir {
+			return archive.Add(e, nil)
+		}
+		file, err := ctx.Backend.Cat(e.path)
+		if err != nil {
+			Log.Debug("downloader::cat path['%s'] error['%s']", e.path, err.Error())
+			if store {
+				return err
 			}
+			errList = append(errList, fmt.Sprintf("downloader::cat %s %s\n", e.name, err.Error()))
+			return nil
+		}
+		live.Watch(file)
+		err = archive.Add(e, file)
+		live.Watch(nil)
+		file.Close()
+		if err != nil {
+			errList = append(errList, fmt.Sprintf("downloader::copy %s %s\n", e.name, err.Error()))
+			Log.Debug("downloader::copy path['%s'] error['%s']", e.path, err.Error())
 		}
+		return err
+	})
+	if err == nil {
+		err = live.Err()
+	}
+	if err != nil && live.Written() == 0 {
+		resHeader.Del("Content-Length")
+		resHeader.Del("Content-Disposition")
+		SendErrorResult(res, err)
+		return
+	} else if err != nil {
+		// there is no way to tell the browser about it other than not completing the download,
+		// otherwise it would happily save a truncated archive
+		Log.Debug("downloader::abort error['%s']", err.Error())
+		panic(http.ErrAbortHandler)
+	}
+	if store == false && len(errList) > 0 {
+		errorLog = []byte(strings.Join(errList, ""))
+	}
+	if len(errorLog) > 0 {
+		archive.Add(downloadEntry{name: "error.log", size: int64(len(errorLog))}, bytes.NewReader(errorLog))
+	}
+	if err = archive.Close(); err != nil {
+		Log.Debug("downloader::close error['%s']", err.Error())
+		return
+	}
+	if store && resHeader.Get("Content-Length") != strconv.FormatInt(live.Written(), 10) {
+		Log.Warning("downloader::size expected[%s] written[%d]", resHeader.Get("Content-Length"), live.Written())
 	}
 }
 
 func FileExtract(ctx *App, res http.ResponseWriter, req *http.Request) {
-	if model.CanRead(ctx) == false {
+	if model.CanRead(ctx) == false || model.CanUpload(ctx) == false {
 		Log.Debug("extract::permission 'permission denied'")
 		SendErrorResult(res, ErrPermissionDenied)
 		return
@@ -1002,32 +1216,87 @@ func FileExtract(ctx *App, res http.ResponseWriter, req *http.Request) {
 	}
 
 	c, cancel := context.WithTimeout(ctx.Context, time.Duration(zip_timeout())*time.Second)
-	extractPath := func(base string, path string) (string, error) {
-		base = filepath.Dir(base)
-		path = filepath.Join(base, path)
-		if strings.HasPrefix(path, base) == false {
+	defer cancel()
+	var err error
+	for i := 0; i < len(paths); i++ {
+		if paths[i], err = PathBuilder(ctx, paths[i]); err != nil {
+			Log.Debug("extract::path '%s'", err.Error())
+			SendErrorResult(res, err)
+			return
+		}
+		if err = ExtractArchive(c, ctx, paths[i], filepath.Dir(paths[i])); err != nil {
+			SendErrorResult(res, err)
+			return
+		}
+	}
+	SendSuccessResult(res, nil)
+}
+
+// ExtractArchive extracts an archive in a folder: zip, tarballs and single compressed files.
+// An entry that can't be saved doesn't stop the extraction, it is reported in a log saved next
+// to the archive
+func ExtractArchive(c context.Context, ctx *App, path string, destination string) error {
+	destination = EnforceDirectory(destination)
+	extractPath := func(name string) (string, error) {
+		p := filepath.ToSlash(filepath.Join(destination, name))
+		if strings.HasPrefix(p, destination) == false {
 			return "", ErrFilesystemError
 		}
-		return path, nil
+		return p, nil
 	}
-	extractZip := func(path string) (err error) {
-		if err = c.Err(); err != nil {
-			cancel()
-			return ErrTimeout
+	errList := []string{}
+	isFolderAlreadyCreated := map[string]bool{destination: true}
+	mkdirAll := func(dir string) error {
+		spl := strings.Split(strings.Trim(dir, "/"), "/")
+		for i := range spl {
+			if spl[i] == "" || spl[i] == "." {
+				continue
+			}
+			p, err := extractPath(strings.Join(spl[0:i+1], "/"))
+			if err != nil {
+				return err
+			}
+			p += "/"
+			if isFolderAlreadyCreated[p] {
+				continue
+			}
+			isFolderAlreadyCreated[p] = true
+			if err := ctx.Backend.Mkdir(p); err != nil {
+				Log.Debug("extract::mkdir err %s", err.Error())
+			}
 		}
-
-		zipFile, err := ctx.Backend.Cat(path)
+		return nil
+	}
+	saveEntry := func(name string, r io.Reader) error {
+		if err := mkdirAll(filepath.Dir(name)); err != nil {
+			Log.Debug("extract::chroot %s", err.Error())
+			return err
+		}
+		p, err := extractPath(name)
 		if err != nil {
+			Log.Debug("extract::chroot %s", err.Error())
 			return err
 		}
-		defer zipFile.Close()
+		if err = model.LockCheck(GenerateStorageID(ctx.Session), p); err != nil {
+			errList = append(errList, fmt.Sprintf("extract::lock %s %s\n", name, err.Error()))
+			Log.Debug("extract::lock err %s", err.Error())
+			return nil
+		}
+		if err = ctx.Backend.Save(p, r); err != nil {
+			errList = append(errList, fmt.Sprintf("extract::save %s %s\n", name, err.Error()))
+			Log.Debug("extract::save err %s", err.Error())
+		}
+		return nil
+	}
+	extractZip := func(archive io.Reader) (err error) {
 		f, err := os.CreateTemp("", "tmpzip.*.zip")
 		if err != nil {
 			Log.Debug("extract::create_temp '%s'", err.Error())
 			return nil
 		}
 		defer os.Remove(f.Name())
-		io.Copy(f, zipFile)
+		defer f.Close()
+		io.Copy(f, archive)
 		s, err := f.Stat()
 		if err != nil {
 			return err
@@ -1036,68 +1305,171 @@ func FileExtract(ctx *App, res http.ResponseWriter, req *http.Request) {
 		if err != nil {
 			return err
 		}
-		isFolderAlreadyCreated := map[string]bool{
-			fmt.Sprintf("%s/", filepath.Dir(path)): true,
-		}
 		for _, f := range r.File {
 			time.Sleep(2 * time.Millisecond)
 			if err = c.Err(); err != nil {
-				cancel()
 				return ErrTimeout
 			}
-			// STEP1: ensure the underlying folders exists
-			spl := strings.Split(f.Name, "/")
-			for i, p := range spl {
-				if p == "" {
-					continue
-				}
-				p = strings.Join(spl[0:i], "/")
-				p, err = extractPath(path, p)
-				if strings.HasSuffix(p, "/") == false {
-					p += "/"
-				}
-				if isFolderAlreadyCreated[p] {
-					continue
-				}
-				isFolderAlreadyCreated[p] = true
-				if err := ctx.Backend.Mkdir(p); err != nil {
-					Log.Debug("extract::mkdir err %s", err.Error())
-				}
-			}
-			// STEP2: create the file
-			if f.FileInfo().IsDir() == false {
-				p, err := extractPath(path, f.Name)
-				if err != nil {
+			if f.FileInfo().IsDir() {
+				if err = mkdirAll(f.Name); err != nil {
 					Log.Debug("extract::chroot %s", err.Error())
 					return err
 				}
-				rc, err := f.Open()
-				if err != nil {
-					Log.Debug("extract::fopen %s", err.Error())
-					return err
-				}
-				err = ctx.Backend.Save(p, rc)
-				rc.Close()
-				if err != nil {
-					Log.Debug("extract::save err %s", err.Error())
-				}
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				errList = append(errList, fmt.Sprintf("extract::fopen %s %s\n", f.Name, err.Error()))
+				Log.Debug("extract::fopen %s", err.Error())
+				continue
+			}
+			err = saveEntry(f.Name, rc)
+			rc.Close()
+			if err != nil {
+				return err
 			}
 		}
 		return nil
 	}
-	var err error
-	for i := 0; i < len(paths); i++ {
-		if paths[i], err = PathBuilder(ctx, paths[i]); err != nil {
-			Log.Debug("extract::path '%s'", err.Error())
-			SendErrorResult(res, err)
-			return
+	extractTar := func(archive io.Reader) error {
+		tr := tar.NewReader(archive)
+		for {
+			time.Sleep(2 * time.Millisecond)
+			if err := c.Err(); err != nil {
+				return ErrTimeout
+			}
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				errList = append(errList, fmt.Sprintf("extract::next %s\n", err.Error()))
+				Log.Debug("extract::next %s", err.Error())
+				return nil
+			}
+			switch hdr.Typeflag {
+			case tar.TypeDir:
+				err = mkdirAll(hdr.Name)
+			case tar.TypeReg:
+				err = saveEntry(hdr.Name, tr)
+			default:
+				errList = append(errList, fmt.Sprintf("extract::type %s unsupported entry type '%c'\n", hdr.Name, hdr.Typeflag))
+			}
+			if err != nil {
+				return err
+			}
 		}
-		if err = extractZip(paths[i]); err != nil {
-			SendErrorResult(res, err)
-			return
+	}
+
+	if err := c.Err(); err != nil {
+		return ErrTimeout
+	}
+	file, err := ctx.Backend.Cat(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	format, archive, closer, err := archiveReader(c, file)
+	if err != nil {
+		Log.Debug("extract::format path['%s'] error['%s']", path, err.Error())
+		return err
+	}
+	defer closer()
+	switch format {
+	case "zip":
+		err = extractZip(archive)
+	case "tar":
+		err = extractTar(archive)
+	default:
+		err = saveEntry(decompressedName(path, format), archive)
+	}
+	if len(errList) > 0 {
+		errPath := path + ".error.log"
+		if e := ctx.Backend.Save(errPath, strings.NewReader(strings.Join(errList, ""))); e != nil {
+			Log.Debug("extract::errorlog path['%s'] error['%s']", errPath, e.Error())
 		}
 	}
-	SendSuccessResult(res, nil)
+	return err
+}
+
+// archiveReader finds out what kind of archive we are dealing with from its magic bytes. Compressed
+// streams are decompressed on the fly which is how a tarball can be told apart from a single file
+func archiveReader(ctx context.Context, file io.Reader) (string, io.Reader, func(), error) {
+	r := bufio.NewReaderSize(file, 1024)
+	head, _ := r.Peek(6)
+	var (
+		format string
+		stream io.Reader
+		closer = func() {}
+	)
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")), bytes.HasPrefix(head, []byte("PK\x05\x06")):
+		return "zip", r, closer, nil
+	case bytes.HasPrefix(head, []byte("\x1f\x8b")):
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return "", nil, closer, err
+		}
+		format, stream, closer = "gz", gz, func() { gz.Close() }
+	case bytes.HasPrefix(head, []byte("BZh")):
+		format, stream = "bz2", bzip2.NewReader(r)
+	case bytes.HasPrefix(head, []byte("\xfd7zXZ\x00")):
+		// there is no xz decoder in the standard library
+		if _, err := exec.LookPath("xz"); err != nil {
+			return "", nil, closer, ErrMissingDependency
+		}
+		cmd := exec.CommandContext(ctx, "xz", "--decompress", "--stdout")
+		cmd.Stdin = r
+		out, err := cmd.StdoutPipe()
+		if err != nil {
+			return "", nil, closer, err
+		} else if err = cmd.Start(); err != nil {
+			return "", nil, closer, err
+		}
+		format, stream, closer = "xz", out, func() {
+			out.Close()
+			cmd.Wait()
+		}
+	default:
+		if isTarHeader(r) {
+			return "tar", r, closer, nil
+		}
+		return "", nil, closer, ErrNotSupported
+	}
+	br := bufio.NewReaderSize(stream, 1024)
+	if isTarHeader(br) {
+		return "tar", br, closer, nil
+	}
+	return format, br, closer, nil
+}
+
+// isTarHeader verifies the checksum of what would be the first header of a tarball
+func isTarHeader(r *bufio.Reader) bool {
+	b, err := r.Peek(512)
+	if err != nil {
+		return false
+	}
+	expected, err := strconv.ParseInt(strings.Trim(string(b[148:156]), " \x00"), 8, 64)
+	if err != nil {
+		return false
+	}
+	var sum int64
+	for i := 0; i < 512; i++ {
+		if i >= 148 && i < 156 {
+			sum += ' '
+			continue
+		}
+		sum += int64(b[i])
+	}
+	return sum == expected
+}
+
+// decompressedName is the name of the file inside a single file archive like "notes.txt.gz"
+func decompressedName(path string, format string) string {
+	name := filepath.Base(path)
+	if ext := "." + format; strings.HasSuffix(strings.ToLower(name), ext) && len(name) > len(ext) {
+		return name[:len(name)-len(ext)]
+	}
+	return name + ".out"
 }
 
 func PathBuilder(ctx *App, path string) (string, error) {
@@ -1114,3 +1486,204 @@ func PathBuilder(ctx *App, path string) (string, error) {
 	}
 	return basePath, nil
 }
+
+type downloadEntry struct {
+	path    string
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+type downloadArchive interface {
+	Add(e downloadEntry, r io.Reader) error
+	Close() error
+}
+
+type zipDownloadArchive struct {
+	zw     *zip.Writer
+	method uint16
+	exact  bool
+}
+
+func (this *zipDownloadArchive) Add(e downloadEntry, r io.Reader) error {
+	h := &zip.FileHeader{Name: e.name, Method: this.method}
+	if e.modTime.Unix() > 0 {
+		h.Modified = e.modTime
+	}
+	w, err := this.zw.CreateHeader(h)
+	if err != nil || e.dir {
+		return err
+	} else if this.exact {
+		_, err = io.CopyN(w, r, e.size)
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+func (this *zipDownloadArchive) Close() error {
+	return this.zw.Close()
+}
+
+type tarDownloadArchive struct {
+	tw *tar.Writer
+	gz *gzip.Writer
+}
+
+func (this *tarDownloadArchive) Add(e downloadEntry, r io.Reader) error {
+	h := &tar.Header{Name: e.name, ModTime: e.modTime, Mode: 0644, Size: e.size, Typeflag: tar.TypeReg}
+	if e.modTime.Unix() <= 0 {
+		h.ModTime = time.Now()
+	}
+	if e.dir {
+		h.Typeflag = tar.TypeDir
+		h.Mode = 0755
+		h.Size = 0
+	}
+	if err := this.tw.WriteHeader(h); err != nil || e.dir {
+		return err
+	}
+	_, err := io.CopyN(this.tw, r, e.size)
+	return err
+}
+
+func (this *tarDownloadArchive) Close() error {
+	err := this.tw.Close()
+	if this.gz != nil {
+		if gerr := this.gz.Close(); err == nil {
+			err = gerr
+		}
+	}
+	return err
+}
+
+// zipStoreSize predicts the size of a zip made by archive/zip when nothing is compressed. It
+// mirrors the layout of its writer: every file has a data descriptor which becomes 64 bits over
+// 4GB and the central directory gets zip64 extra fields for anything that doesn't fit in 32 bits
+func zipStoreSize(entries []downloadEntry) int64 {
+	const max32 = uint64(1<<32 - 1)
+	var (
+		offset  uint64
+		central uint64
+		zip64   bool
+	)
+	for _, e := range entries {
+		name := uint64(len(e.name))
+		extra := uint64(0)
+		if e.modTime.Unix() > 0 {
+			extra = 9 // extended timestamp
+		}
+		size, descriptor := uint64(0), uint64(0)
+		if e.dir == false {
+			size, descriptor = uint64(e.size), 16
+			if size > max32 {
+				descriptor = 24
+			}
+		}
+		zip64Extra := uint64(0)
+		if size >= max32 {
+			zip64Extra += 16
+		}
+		if offset >= max32 {
+			zip64Extra += 8
+		}
+		if zip64Extra > 0 {
+			zip64Extra += 4
+			zip64 = true
+		}
+		central += 46 + name + extra + zip64Extra
+		offset += 30 + name + extra + size + descriptor
+	}
+	total := offset + central + 22
+	if zip64 || len(entries) >= 1<<16-1 || central >= max32 || offset >= max32 {
+		total += 56 + 20
+	}
+	return int64(total)
+}
+
+// downloadLiveness keeps track of the progress of a download. When it stalls, the file being
+// read and the connection are unblocked so the download can be stopped
+type downloadLiveness struct {
+	w       io.Writer
+	rc      *http.ResponseController
+	timeout time.Duration
+	done    chan struct{}
+	mu      sync.Mutex
+	last    time.Time
+	written int64
+	current io.Closer
+	err     error
+}
+
+func newDownloadLiveness(res http.ResponseWriter, timeout time.Duration) *downloadLiveness {
+	this := &downloadLiveness{
+		w:       res,
+		rc:      http.NewResponseController(res),
+		timeout: timeout,
+		done:    make(chan struct{}),
+		last:    time.Now(),
+	}
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-this.done:
+				return
+			case <-ticker.C:
+				this.mu.Lock()
+				if time.Since(this.last) > this.timeout {
+					Log.Debug("downloader::timeout no progress since %s", this.last.Format(time.RFC3339))
+					this.err = ErrTimeout
+					if this.current != nil {
+						this.current.Close()
+					}
+					this.rc.SetWriteDeadline(time.Now())
+					this.mu.Unlock()
+					return
+				}
+				this.mu.Unlock()
+			}
+		}
+	}()
+	return this
+}
+
+func (this *downloadLiveness) Write(p []byte) (int, error) {
+	if err := this.Err(); err != nil {
+		return 0, err
+	}
+	n, err := this.w.Write(p)
+	this.mu.Lock()
+	this.written += int64(n)
+	if n > 0 {
+		this.last = time.Now()
+	}
+	this.mu.Unlock()
+	return n, err
+}
+
+// Watch registers the file being read so it can be closed when the download stalls
+func (this *downloadLiveness) Watch(c io.Closer) {
+	this.mu.Lock()
+	this.current = c
+	this.last = time.Now()
+	this.mu.Unlock()
+}
+
+func (this *downloadLiveness) Err() error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.err
+}
+
+func (this *downloadLiveness) Written() int64 {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.written
+}
+
+func (this *downloadLiveness) Stop() {
+	close(this.done)
+}
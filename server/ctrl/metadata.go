@@ -6,6 +6,7 @@ import (
 	"net/http"
 
 	. "github.com/mickael-kerjean/filestash/server/common"
+	"github.com/mickael-kerjean/filestash/server/model"
 )
 
 func MetaGet(ctx *App, w http.ResponseWriter, r *http.Request) {
@@ -32,6 +33,9 @@ func MetaUpsert(ctx *App, w http.ResponseWriter, r *http.Request) {
 	if m == nil {
 		SendErrorResult(w, ErrNotImplemented)
 		return
+	} else if model.CanEdit(ctx) == false {
+		SendErrorResult(w, ErrPermissionDenied)
+		return
 	}
 	path, err := PathBuilder(ctx, r.URL.Query().Get("path"))
 	if err != nil {
@@ -12,12 +12,26 @@ func FileSearch(ctx *App, res http.ResponseWriter, req *http.Request) {
 	if err != nil {
 		path = "/"
 	}
-	q := req.URL.Query().Get("q")
 	if model.CanRead(ctx) == false {
 		Log.Debug("ctrl::search 'can not read \"%s\"'", path)
 		SendErrorResult(res, ErrPermissionDenied)
 		return
 	}
+	query, err := ParseSearchQuery(req.URL.Query().Get("q"))
+	if err != nil {
+		SendErrorResult(res, err)
+		return
+	}
+	for _, filter := range query.Filters("path") {
+		if filter.Value, err = PathBuilder(ctx, filter.Value); err != nil {
+			SendErrorResult(res, err)
+			return
+		}
+	}
+	if err = searchMetadata(ctx, query); err != nil {
+		SendErrorResult(res, err)
+		return
+	}
 
 	var searchResults []IFile
 	searchEngine := Hooks.Get.SearchEngine()
@@ -25,7 +39,7 @@ func FileSearch(ctx *App, res http.ResponseWriter, req *http.Request) {
 		SendErrorResult(res, ErrMissingDependency)
 		return
 	}
-	searchResults, err = searchEngine.Query(*ctx, path, q)
+	searchResults, err = searchEngine.Query(*ctx, path, query)
 	if err != nil {
 		SendErrorResult(res, err)
 		return
@@ -34,7 +48,7 @@ func FileSearch(ctx *App, res http.ResponseWriter, req *http.Request) {
 	// overwrite the path of a file according to chroot
 	if ctx.Session["path"] != "" {
 		for i := 0; i < len(searchResults); i++ {
-			searchResults[i] = File{
+			f := File{
 				FName: searchResults[i].Name(),
 				FSize: searchResults[i].Size(),
 				FType: func() string {
@@ -48,7 +62,52 @@ func FileSearch(ctx *App, res http.ResponseWriter, req *http.Request) {
 					ctx.Session["path"],
 				),
 			}
+			switch r := searchResults[i].(type) {
+			case File:
+				f.FTime = r.FTime
+				searchResults[i] = f
+			case SearchResult:
+				f.FTime = r.FTime
+				r.File = f
+				searchResults[i] = r
+			default:
+				searchResults[i] = f
+			}
 		}
 	}
 	SendSuccessResults(res, searchResults)
 }
+
+// searchMetadata resolves the metadata filters of a query onto the paths they match so search
+// engines can combine them with everything else without knowing about metadata
+func searchMetadata(ctx *App, query SearchQuery) error {
+	filters := query.MetadataFilters()
+	if len(filters) == 0 {
+		return nil
+	}
+	m := Hooks.Get.Metadata()
+	if m == nil {
+		return ErrNotImplemented
+	}
+	root, err := PathBuilder(ctx, "/")
+	if err != nil {
+		return err
+	}
+	for _, filter := range filters {
+		id := strings.TrimPrefix(filter.Field, "meta.")
+		if filter.Field == "tag" {
+			id = "tags"
+		}
+		results, err := m.Search(ctx, root, map[string]any{id: []any{filter.Value}})
+		if err != nil {
+			return err
+		}
+		filter.Paths = make(map[string]bool, len(results))
+		for path := range results {
+			if p, err := PathBuilder(ctx, path); err == nil {
+				filter.Paths[p] = true
+			}
+		}
+	}
+	return nil
+}
@@ -4,7 +4,6 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"net"
 	"net/http"
 	"net/url"
 	"slices"
@@ -57,7 +56,7 @@ func SessionAuthenticate(ctx *App, res http.ResponseWriter, req *http.Request) {
 	backend, err := model.NewBackend(ctx, session)
 	if err != nil {
 		Log.Debug("[auth] action=authenticate::newBackend err=%s", ferror(err))
-		Log.Stdout("AUDIT action[fail] backend[%s] user[%s] target[%s]", session["type"], backendID(session), ip(req))
+		Log.Stdout("AUDIT action[fail] backend[%s] user[%s] target[%s]", session["type"], backendID(session), ClientIP(req))
 		SendErrorResult(res, err)
 		return
 	}
@@ -75,7 +74,7 @@ func SessionAuthenticate(ctx *App, res http.ResponseWriter, req *http.Request) {
 		backend, err = model.NewBackend(ctx, session)
 		if err != nil {
 			Log.Debug("[auth] action=authenticate::oauth::newBackend err=%s", ferror(err))
-			Log.Stdout("AUDIT action[fail] backend[%s] user[%s] target[%s]", session["type"], username(session), ip(req))
+			Log.Stdout("AUDIT action[fail] backend[%s] user[%s] target[%s]", session["type"], username(session), ClientIP(req))
 			SendErrorResult(res, NewError("Can't authenticate", 401))
 			return
 		}
@@ -87,6 +86,11 @@ func SessionAuthenticate(ctx *App, res http.ResponseWriter, req *http.Request) {
 		SendErrorResult(res, ErrAuthenticationFailed)
 		return
 	}
+	if err = sessionRegister(session, req); err != nil {
+		Log.Error("[auth] action=authenticate::register err=%s", ferror(err))
+		SendErrorResult(res, NewError(err.Error(), 500))
+		return
+	}
 
 	s, err := json.Marshal(session)
 	if err != nil {
@@ -126,7 +130,7 @@ func SessionAuthenticate(ctx *App, res http.ResponseWriter, req *http.Request) {
 	if Config.Get("features.protection.iframe").String() != "" {
 		res.Header().Set("bearer", obfuscate)
 	}
-	Log.Stdout("AUDIT action[login] backend[%s] user[%s] target[%s]", session["type"], username(session), ip(req))
+	Log.Stdout("AUDIT action[login] backend[%s] user[%s] target[%s]", session["type"], username(session), ClientIP(req))
 	SendSuccessResult(res, Session{
 		IsAuth:        true,
 		Home:          NewString(home),
@@ -149,6 +153,9 @@ func SessionLogout(ctx *App, res http.ResponseWriter, req *http.Request) {
 					obj.Close()
 				}
 			}
+			if sid := c.Session["sid"]; sid != "" {
+				model.SessionRegistryRevoke(sid)
+			}
 		})(ctx, res, req)
 	}()
 	index := 0
@@ -177,7 +184,7 @@ func SessionLogout(ctx *App, res http.ResponseWriter, req *http.Request) {
 		MaxAge: -1,
 		Path:   COOKIE_PATH,
 	})
-	Log.Stdout("AUDIT action[logout] backend[%s] user[%s] target[%s]", ctx.Session["type"], username(ctx.Session), ip(req))
+	Log.Stdout("AUDIT action[logout] backend[%s] user[%s] target[%s]", ctx.Session["type"], username(ctx.Session), ClientIP(req))
 	SendSuccessResult(res, nil)
 }
 
@@ -223,18 +230,7 @@ func SessionAuthMiddleware(ctx *App, res http.ResponseWriter, req *http.Request)
 
 	// Step0: Initialisation
 	_get := req.URL.Query()
-	plugin := func() IAuthentication {
-		selectedPluginId := Config.Get("middleware.identity_provider.type").String()
-		if selectedPluginId == "" {
-			return nil
-		}
-		for key, plugin := range Hooks.Get.AuthenticationMiddleware() {
-			if key == selectedPluginId {
-				return plugin
-			}
-		}
-		return nil
-	}()
+	plugin := identityProvider()
 	if plugin == nil {
 		http.Redirect(
 			res, req,
@@ -267,47 +263,33 @@ func SessionAuthMiddleware(ctx *App, res http.ResponseWriter, req *http.Request)
 		}
 	}
 
-	idpParams := TmplParams(map[string]string{})
-	if err := json.Unmarshal(
-		[]byte(Config.Get("middleware.identity_provider.params").String()),
-		&idpParams,
-	); err != nil {
+	idpParams, err := identityProviderParams()
+	if err != nil {
 		http.Redirect(
 			res, req,
-			"/?error=Not%20Valid&trace=unpacking idp - "+err.Error(),
+			"/?error=Not%20Valid&trace="+err.Error(),
 			http.StatusTemporaryRedirect,
 		)
 		return
 	}
-	for k, v := range idpParams {
-		out, err := TmplExec(NewStringFromInterface(v), idpParams)
-		if err != nil {
-			http.Redirect(
-				res, req,
-				"/?error=Not%20Valid&trace=idp - "+err.Error(),
-				http.StatusTemporaryRedirect,
-			)
-			return
-		}
-		idpParams[k] = out
-	}
 
-	// Step1: Entrypoint of the authentication process is handled by the plugin
+	// Step1: Entrypoint of the authentication process is handled by the plugin. The ssoref
+	// cookie also carries a random reference plugins can bind their flow to, eg: to derive
+	// the state and nonce of openid and verify them in the callback
 	if req.Method == "GET" && _get.Get("action") == "redirect" {
-		if label := _get.Get("label"); label != "" {
-			http.SetCookie(
-				res,
-				applyCookieSameSiteRule(
-					applyCookieRules(&http.Cookie{
-						Name:   SSOCookieName,
-						Value:  label + "::" + _get.Get("state"),
-						MaxAge: 60 * 10,
-						Path:   COOKIE_PATH,
-					}, req),
-					http.SameSiteDefaultMode,
-				),
-			)
-		}
+		idpParams["ssoref"] = RandomString(32)
+		http.SetCookie(
+			res,
+			applyCookieSameSiteRule(
+				applyCookieRules(&http.Cookie{
+					Name:   SSOCookieName,
+					Value:  _get.Get("label") + "::" + _get.Get("state") + "::" + idpParams["ssoref"],
+					MaxAge: 60 * 10,
+					Path:   COOKIE_PATH,
+				}, req),
+				http.SameSiteDefaultMode,
+			),
+		)
 		if err := plugin.EntryPoint(idpParams, req, res); err != nil {
 			Log.Error("entrypoint - %s", err.Error())
 			res.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -320,6 +302,32 @@ func SessionAuthMiddleware(ctx *App, res http.ResponseWriter, req *http.Request)
 	// Step2: End of the authentication process. Could come from:
 	// - target of a html form. eg: ldap, mysql, ...
 	// - identity provider redirection uri. eg: oauth2, openid, ...
+	var (
+		label = ""
+		state = ""
+	)
+	if refCookie, err := req.Cookie(SSOCookieName); err == nil { // TODO: deprecate SSOCookieName
+		s := strings.SplitN(refCookie.Value, "::", 3)
+		switch len(s) {
+		case 1:
+			label = s[0]
+		case 2:
+			label = s[0]
+			state = s[1]
+		case 3:
+			label = s[0]
+			state = s[1]
+			idpParams["ssoref"] = s[2]
+		}
+	}
+	if label == "" {
+		if l := req.URL.Query().Get("label"); l != "" {
+			label = l
+			state = req.URL.Query().Get("state")
+		} else {
+			Log.Warning("session::authMiddleware action=callback_error err=missing_label url=%s", req.URL.String())
+		}
+	}
 	pluginCallback, err := plugin.Callback(formData, idpParams, res)
 	if err == ErrAuthenticationFailed {
 		Log.Warning("failed authentication - %s", err.Error())
@@ -341,26 +349,6 @@ func SessionAuthMiddleware(ctx *App, res http.ResponseWriter, req *http.Request)
 		return
 	}
 	templateBind := TmplParams(pluginCallback)
-
-	var (
-		label = ""
-		state = ""
-	)
-	if refCookie, err := req.Cookie(SSOCookieName); err == nil { // TODO: deprecate SSOCookieName
-		s := strings.SplitN(refCookie.Value, "::", 2)
-		switch len(s) {
-		case 1:
-			label = s[0]
-		case 2:
-			label = s[0]
-			state = s[1]
-		}
-	} else if l := req.URL.Query().Get("label"); l != "" {
-		label = l
-		state = req.URL.Query().Get("state")
-	} else {
-		Log.Warning("session::authMiddleware action=callback_error err=missing_label url=%s", req.URL.String())
-	}
 	if decodedState, err := base64.StdEncoding.DecodeString(state); err == nil {
 		stateStruct := map[string]string{}
 		json.Unmarshal(decodedState, &stateStruct)
@@ -408,32 +396,7 @@ func SessionAuthMiddleware(ctx *App, res http.ResponseWriter, req *http.Request)
 	}
 
 	// Step3: create a backend connection object
-	session, err := func(tb map[string]string) (map[string]string, error) {
-		globalMapping := map[string]map[string]interface{}{}
-		if err = json.Unmarshal(
-			[]byte(Config.Get("middleware.attribute_mapping.params").String()),
-			&globalMapping,
-		); err != nil {
-			Log.Warning("session::authMiddlware 'attribute mapping error' %s", err.Error())
-			return map[string]string{}, err
-		}
-		mappingToUse := map[string]string{}
-		for k, v := range globalMapping[label] {
-			out, err := TmplExec(NewStringFromInterface(v), tb)
-			if err != nil {
-				Log.Debug("session::authMiddleware action=tmplExec err=%s", err.Error())
-			}
-			mappingToUse[k] = out
-		}
-		mappingToUse["timestamp"] = time.Now().Format(time.RFC3339)
-		if label != "" && Config.Get("general.extended_session").Bool() {
-			pluginCallback["label"] = label
-			if jsonStr, err := json.Marshal(pluginCallback); err == nil {
-				mappingToUse["session"] = string(jsonStr)
-			}
-		}
-		return mappingToUse, nil
-	}(templateBind)
+	session, err := attributeMapping(label, templateBind, pluginCallback)
 	if err != nil {
 		Log.Debug("session::authMiddleware 'auth mapping failed %s'", err.Error())
 		http.Redirect(
@@ -446,7 +409,7 @@ func SessionAuthMiddleware(ctx *App, res http.ResponseWriter, req *http.Request)
 
 	if _, err := model.NewBackend(ctx, session); err != nil {
 		Log.Debug("session::authMiddleware 'backend connection failed %s'", err.Error())
-		Log.Info("[auth] status=failed user=%s backend=%s::%s ip=%s err=%s", username(session), session["type"], backendID(session), ip(req), ferror(err))
+		Log.Info("[auth] status=failed user=%s backend=%s::%s ip=%s err=%s", username(session), session["type"], backendID(session), ClientIP(req), ferror(err))
 		url := "/?error=" + ErrNotValid.Error() + "&trace=backend error - " + err.Error()
 		if IsATranslatedError(err) {
 			url = "/?error=" + err.Error() + "&trace=backend error - " + err.Error()
@@ -456,6 +419,11 @@ func SessionAuthMiddleware(ctx *App, res http.ResponseWriter, req *http.Request)
 	}
 
 	// Step4: persist connection with a cookie
+	if err = sessionRegister(session, req); err != nil {
+		Log.Error("session::authMiddleware 'register error - %s'", err.Error())
+		SendErrorResult(res, ErrNotValid)
+		return
+	}
 	s, err := json.Marshal(session)
 	if err != nil {
 		Log.Debug("session::authMiddleware 'session marshal error %+v'", session)
@@ -483,10 +451,95 @@ func SessionAuthMiddleware(ctx *App, res http.ResponseWriter, req *http.Request)
 	if Config.Get("features.protection.iframe").String() != "" {
 		redirectURI += "#bearer=" + obfuscate
 	}
-	Log.Info("[auth] status=success user=%s backend=%s::%s ip=%s", username(session), session["type"], backendID(session), ip(req))
+	Log.Info("[auth] status=success user=%s backend=%s::%s ip=%s", username(session), session["type"], backendID(session), ClientIP(req))
 	http.Redirect(res, req, redirectURI, http.StatusSeeOther)
 }
 
+// identityProvider is the authentication plugin selected from the admin console, if any
+func identityProvider() IAuthentication {
+	selectedPluginId := Config.Get("middleware.identity_provider.type").String()
+	if selectedPluginId == "" {
+		return nil
+	}
+	for key, plugin := range Hooks.Get.AuthenticationMiddleware() {
+		if key == selectedPluginId {
+			return plugin
+		}
+	}
+	return nil
+}
+
+func identityProviderParams() (map[string]string, error) {
+	idpParams := TmplParams(map[string]string{})
+	if err := json.Unmarshal(
+		[]byte(Config.Get("middleware.identity_provider.params").String()),
+		&idpParams,
+	); err != nil {
+		return nil, NewError("unpacking idp - "+err.Error(), 400)
+	}
+	for k, v := range idpParams {
+		out, err := TmplExec(NewStringFromInterface(v), idpParams)
+		if err != nil {
+			return nil, NewError("idp - "+err.Error(), 400)
+		}
+		idpParams[k] = out
+	}
+	return idpParams, nil
+}
+
+// attributeMapping turns what the identity provider told us about a user into the session of the
+// connection identified by label
+func attributeMapping(label string, tb map[string]string, pluginCallback map[string]string) (map[string]string, error) {
+	globalMapping := map[string]map[string]interface{}{}
+	if err := json.Unmarshal(
+		[]byte(Config.Get("middleware.attribute_mapping.params").String()),
+		&globalMapping,
+	); err != nil {
+		Log.Warning("session::authMiddlware 'attribute mapping error' %s", err.Error())
+		return map[string]string{}, err
+	}
+	mappingToUse := map[string]string{}
+	for k, v := range globalMapping[label] {
+		out, err := TmplExec(NewStringFromInterface(v), tb)
+		if err != nil {
+			Log.Debug("session::authMiddleware action=tmplExec err=%s", err.Error())
+		}
+		mappingToUse[k] = out
+	}
+	mappingToUse["timestamp"] = time.Now().Format(time.RFC3339)
+	if label != "" && Config.Get("general.extended_session").Bool() {
+		pluginCallback["label"] = label
+		if jsonStr, err := json.Marshal(pluginCallback); err == nil {
+			mappingToUse["session"] = string(jsonStr)
+		}
+	}
+	return mappingToUse, nil
+}
+
+// sessionRegister gives the session an id which the server side registry can revoke
+func sessionRegister(session map[string]string, req *http.Request) error {
+	delete(session, "sid")
+	if model.SessionRegistryEnabled() == false {
+		return nil
+	}
+	now := time.Now()
+	timeout := time.Duration(Config.Get("general.cookie_timeout").Int()) * time.Minute
+	if timeout <= 0 {
+		timeout = 24 * 365 * time.Hour
+	}
+	session["sid"] = RandomString(32)
+	return model.SessionRegistryCreate(model.SessionEntry{
+		Id:        session["sid"],
+		User:      username(session),
+		Backend:   session["type"] + "::" + backendID(session),
+		IP:        ClientIP(req),
+		UserAgent: req.UserAgent(),
+		CreatedAt: now,
+		LastSeen:  now,
+		ExpireAt:  now.Add(timeout),
+	})
+}
+
 func applyCookieRules(cookie *http.Cookie, req *http.Request) *http.Cookie {
 	cookie.HttpOnly = true
 	cookie.SameSite = http.SameSiteStrictMode
@@ -520,22 +573,6 @@ func username(session map[string]string) string {
 	return GenerateID(session)
 }
 
-func ip(req *http.Request) string {
-	if xff := req.Header.Get("X-Forwarded-For"); xff != "" {
-		if parts := strings.Split(xff, ","); len(parts) > 0 {
-			return strings.TrimSpace(parts[0])
-		}
-	}
-	if xrip := req.Header.Get("X-Real-Ip"); xrip != "" {
-		return xrip
-	}
-	host, _, err := net.SplitHostPort(req.RemoteAddr)
-	if err != nil {
-		return req.RemoteAddr
-	}
-	return host
-}
-
 func ferror(err error) string {
 	return strings.ReplaceAll(err.Error(), " ", "+")
 }
@@ -0,0 +1,112 @@
+package ctrl
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	. "github.com/mickael-kerjean/filestash/server/common"
+	"github.com/mickael-kerjean/filestash/server/model"
+)
+
+const ACCESS_TOKEN_DEFAULT_DURATION = 30 * 24 * time.Hour
+
+func TokenList(ctx *App, res http.ResponseWriter, req *http.Request) {
+	if canManageTokens(ctx) == false {
+		SendErrorResult(res, ErrPermissionDenied)
+		return
+	}
+	tokens, err := model.AccessTokenList(GenerateID(ctx.Session))
+	if err != nil {
+		Log.Debug("token::list '%s'", err.Error())
+		SendErrorResult(res, err)
+		return
+	}
+	SendSuccessResults(res, tokens)
+}
+
+func TokenCreate(ctx *App, res http.ResponseWriter, req *http.Request) {
+	if canManageTokens(ctx) == false {
+		SendErrorResult(res, ErrPermissionDenied)
+		return
+	}
+	t := AccessToken{
+		Name:    strings.TrimSpace(NewStringFromInterface(ctx.Body["name"])),
+		Backend: GenerateID(ctx.Session),
+		Path:    path.Clean("/" + NewStringFromInterface(ctx.Body["path"])),
+		Scope:   NewStringFromInterface(ctx.Body["scope"]),
+	}
+	if t.Name == "" || len(t.Name) > 256 {
+		SendErrorResult(res, NewError("Invalid token name", 400))
+		return
+	}
+	switch t.Scope {
+	case "":
+		t.Scope = ACCESS_TOKEN_SCOPE_READ
+	case ACCESS_TOKEN_SCOPE_READ, ACCESS_TOKEN_SCOPE_WRITE:
+	default:
+		SendErrorResult(res, NewError("Invalid token scope", 400))
+		return
+	}
+	now := time.Now()
+	t.Expire = now.Add(ACCESS_TOKEN_DEFAULT_DURATION).UnixNano() / 1000000
+	if expire := NewInt64pFromInterface(ctx.Body["expire"]); expire != nil {
+		t.Expire = *expire
+	}
+	if t.Expire <= now.UnixNano()/1000000 || t.Expire > now.Add(model.ACCESS_TOKEN_MAX_DURATION).UnixNano()/1000000 {
+		SendErrorResult(res, NewError("Token expiration must be within a year", 400))
+		return
+	}
+
+	// the token carries its own copy of the session, detached from the one of the browser so
+	// it survives a logout and doesn't age with the cookie
+	session := map[string]string{}
+	for k, v := range ctx.Session {
+		session[k] = v
+	}
+	delete(session, "sid")
+	session["timestamp"] = now.Format(time.RFC3339)
+	s, err := json.Marshal(session)
+	if err != nil {
+		SendErrorResult(res, err)
+		return
+	}
+	if t.Auth, err = EncryptString(SECRET_KEY_DERIVATE_FOR_USER, string(s)); err != nil {
+		SendErrorResult(res, err)
+		return
+	}
+	value, err := model.AccessTokenCreate(&t)
+	if err != nil {
+		Log.Debug("token::create '%s'", err.Error())
+		SendErrorResult(res, err)
+		return
+	}
+	Log.Stdout("AUDIT action[token_create] backend[%s] user[%s] target[%s] token[%s]", ctx.Session["type"], username(ctx.Session), ClientIP(req), t.Id)
+	SendSuccessResult(res, struct {
+		AccessToken
+		Token string `json:"token"`
+	}{t, value})
+}
+
+func TokenDelete(ctx *App, res http.ResponseWriter, req *http.Request) {
+	if canManageTokens(ctx) == false {
+		SendErrorResult(res, ErrPermissionDenied)
+		return
+	}
+	id := mux.Vars(req)["tokenID"]
+	if err := model.AccessTokenDelete(GenerateID(ctx.Session), id); err != nil {
+		SendErrorResult(res, err)
+		return
+	}
+	Log.Stdout("AUDIT action[token_revoke] backend[%s] user[%s] target[%s] token[%s]", ctx.Session["type"], username(ctx.Session), ClientIP(req), id)
+	SendSuccessResult(res, nil)
+}
+
+// canManageTokens restricts the management of tokens to an actual user session. Neither a shared
+// link nor a token can be used to create more tokens
+func canManageTokens(ctx *App) bool {
+	return ctx.Share.Id == "" && ctx.Token.Id == ""
+}
@@ -1,11 +1,16 @@
 package ctrl
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"net/http"
 	"path/filepath"
 	"strings"
+	"time"
 
 	. "github.com/mickael-kerjean/filestash/server/common"
+	"github.com/mickael-kerjean/filestash/server/middleware"
 	"github.com/mickael-kerjean/filestash/server/model"
 	"github.com/mickael-kerjean/net/webdav"
 )
@@ -15,7 +20,15 @@ func WebdavHandler(ctx *App, res http.ResponseWriter, req *http.Request) {
 		http.NotFound(res, req)
 		return
 	}
+	webdavServe(ctx, res, req, "/s/"+ctx.Share.Id, ctx.Share.Backend, ctx.Share.Path)
+}
+
+// WebdavUserHandler exposes the storage of a logged in user so it can be mounted as a network drive
+func WebdavUserHandler(ctx *App, res http.ResponseWriter, req *http.Request) {
+	webdavServe(ctx, res, req, WithBase("/dav"), GenerateID(ctx.Session), EnforceDirectory(ctx.Session["path"]))
+}
 
+func webdavServe(ctx *App, res http.ResponseWriter, req *http.Request, prefix string, primaryKey string, chroot string) {
 	// https://github.com/golang/net/blob/master/webdav/webdav.go#L49-L68
 	canRead := model.CanRead(ctx)
 	canWrite := model.CanEdit(ctx)
@@ -51,14 +64,173 @@ func WebdavHandler(ctx *App, res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	fs := model.NewWebdavFs(ctx, prefix, primaryKey, chroot, req)
 	h := &webdav.Handler{
-		Prefix:     "/s/" + ctx.Share.Id,
-		FileSystem: model.NewWebdavFs(ctx.Backend, ctx.Share.Backend, ctx.Share.Path, req),
-		LockSystem: model.NewWebdavLock(),
+		Prefix:     prefix,
+		FileSystem: fs,
+		LockSystem: model.NewWebdavLock(fs),
 	}
 	h.ServeHTTP(res, req)
 }
 
+/*
+ * Webdav clients that mount a network drive know nothing about cookies. They authenticate either:
+ * - with HTTP Basic, the credentials being those of the identity provider when there is one or those
+ *   asked by the login form of the storage otherwise. With more than one connection, the username is
+ *   prefixed with the label of the connection to use, eg: "sftp\bob"
+ * - with a bearer token, being a personal access token or the authorization of the web application.
+ *   Clients that can only do HTTP Basic can give a personal access token as the password
+ */
+var webdavLogins = NewQuickCache(60, 120)
+
+type webdavLogin struct {
+	digest  []byte
+	session map[string]string
+}
+
+func WebdavAuthenticate(fn HandlerFunc) HandlerFunc {
+	return HandlerFunc(func(ctx *App, res http.ResponseWriter, req *http.Request) {
+		user, password, ok := req.BasicAuth()
+		if ok && strings.HasPrefix(password, ACCESS_TOKEN_PREFIX) {
+			req.Header.Set("Authorization", "Bearer "+password)
+			ok = false
+		}
+		if ok == false {
+			if strings.HasPrefix(req.Header.Get("Authorization"), "Bearer ") == false {
+				webdavChallenge(res)
+				return
+			}
+			req.Header.Del("Cookie") // the bearer token is what we go with
+			middleware.SessionStart(fn)(ctx, res, req)
+			return
+		}
+
+		session, err := webdavBasicAuth(ctx, user, password, req)
+		if err != nil {
+			Log.Info("[auth] status=failed user=%s backend=webdav ip=%s err=%s", strings.ReplaceAll(user, " ", "+"), ClientIP(req), ferror(err))
+			webdavChallenge(res)
+			return
+		}
+		ctx.Session = session
+		if ctx.Backend, err = model.NewBackend(ctx, session); err != nil {
+			webdavChallenge(res)
+			return
+		}
+		fn(ctx, res, req)
+	})
+}
+
+func webdavBasicAuth(ctx *App, user string, password string, req *http.Request) (map[string]string, error) {
+	mac := hmac.New(sha256.New, []byte(SECRET_KEY))
+	mac.Write([]byte(user + "\x00" + password))
+	digest := mac.Sum(nil)
+	key := map[string]string{"digest": hex.EncodeToString(digest)}
+	if login, ok := webdavLogins.Get(key).(webdavLogin); ok && hmac.Equal(login.digest, digest) {
+		if model.SessionRegistryEnabled() == false {
+			return login.session, nil
+		} else if err := model.SessionRegistryVerify(login.session["sid"], ClientIP(req)); err == nil {
+			return login.session, nil
+		}
+		// a revoked session has to go through the login again, with the credentials being
+		// checked against the storage once more
+		webdavLogins.Del(key)
+	}
+
+	var (
+		label   = ""
+		name    = user
+		session map[string]string
+		err     error
+	)
+	if l, n, ok := strings.Cut(user, `\`); ok {
+		label, name = l, n
+	}
+	if plugin := identityProvider(); plugin != nil {
+		session, err = webdavIdentityProvider(plugin, label, name, password)
+	} else {
+		session, err = webdavConnection(label, name, password)
+	}
+	if err != nil {
+		return nil, err
+	}
+	session["path"] = EnforceDirectory(session["path"])
+	backend, err := model.NewBackend(ctx, session)
+	if err != nil {
+		return nil, err
+	} else if _, err = model.GetHome(backend, session["path"]); err != nil {
+		return nil, ErrAuthenticationFailed
+	}
+	if err = sessionRegister(session, req); err != nil {
+		Log.Error("[auth] action=webdav::register err=%s", ferror(err))
+		return nil, err
+	}
+	webdavLogins.Set(key, webdavLogin{digest, session})
+	Log.Info("[auth] status=success user=%s backend=%s::%s ip=%s", username(session), session["type"], backendID(session), ClientIP(req))
+	Log.Stdout("AUDIT action[login] backend[%s] user[%s] target[%s]", session["type"], username(session), ClientIP(req))
+	return session, nil
+}
+
+func webdavIdentityProvider(plugin IAuthentication, label string, user string, password string) (map[string]string, error) {
+	idpParams, err := identityProviderParams()
+	if err != nil {
+		return nil, err
+	}
+	pluginCallback, err := plugin.Callback(
+		map[string]string{"user": user, "password": password},
+		idpParams,
+		&webdavResponseWriter{header: http.Header{}},
+	)
+	if err != nil {
+		return nil, err
+	} else if label == "" {
+		label = strings.TrimSpace(strings.Split(Config.Get("middleware.attribute_mapping.related_backend").String(), ",")[0])
+	}
+	return attributeMapping(label, TmplParams(pluginCallback), pluginCallback)
+}
+
+func webdavConnection(label string, user string, password string) (map[string]string, error) {
+	for _, conn := range Config.Conn {
+		if label != "" && NewStringFromInterface(conn["label"]) != label && NewStringFromInterface(conn["type"]) != label {
+			continue
+		}
+		session := map[string]string{}
+		for k, v := range conn {
+			if k != "label" {
+				session[k] = NewStringFromInterface(v)
+			}
+		}
+		session["timestamp"] = time.Now().Format(time.RFC3339)
+		hasPassword := false
+		for _, el := range Backend.Get(session["type"]).LoginForm().Elmnts {
+			if session[el.Name] != "" {
+				continue
+			} else if el.Type == "password" && hasPassword == false {
+				session[el.Name] = password
+				hasPassword = true
+			} else if el.Name == "user" || el.Name == "username" {
+				session[el.Name] = user
+			}
+		}
+		return session, nil
+	}
+	return nil, ErrNotFound
+}
+
+func webdavChallenge(res http.ResponseWriter) {
+	res.Header().Set("WWW-Authenticate", `Basic realm="Filestash", charset="UTF-8"`)
+	SendErrorResult(res, ErrNotAuthorized)
+}
+
+// webdavResponseWriter is what identity providers get to write to as there is no browser on the
+// other end to make sense of it
+type webdavResponseWriter struct {
+	header http.Header
+}
+
+func (this *webdavResponseWriter) Header() http.Header         { return this.header }
+func (this *webdavResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (this *webdavResponseWriter) WriteHeader(statusCode int)  {}
+
 /*
  * OSX ask for a lot of crap while mounting as a network drive. To avoid wasting resources with such
  * an imbecile and considering we can't even see the source code they are running, the best approach we
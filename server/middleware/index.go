@@ -69,6 +69,11 @@ func (w *ResponseWriter) Flush() {
 	w.ResponseWriter.(http.Flusher).Flush()
 }
 
+// Unwrap lets http.ResponseController reach the underlying connection
+func (w *ResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
 func PluginInjector(fn HandlerFunc) HandlerFunc {
 	for _, middleware := range Hooks.Get.Middleware() {
 		fn = middleware(fn)
@@ -7,7 +7,6 @@ import (
 	"net/http"
 	"regexp"
 	"strings"
-	"time"
 
 	. "github.com/mickael-kerjean/filestash/server/common"
 	"github.com/mickael-kerjean/filestash/server/model"
@@ -63,6 +62,10 @@ func SessionStart(fn HandlerFunc) HandlerFunc {
 			return
 		}
 		ctx.Authorization = _extractAuthorization(req)
+		if ctx.Token, err = _extractToken(ctx); err != nil {
+			SendErrorResult(res, err)
+			return
+		}
 		if ctx.Session, err = _extractSession(req, ctx); err != nil {
 			RecoverFromBadCookie(res)
 			SendErrorResult(res, err)
@@ -86,6 +89,7 @@ func SessionTry(fn HandlerFunc) HandlerFunc {
 	return HandlerFunc(func(ctx *App, res http.ResponseWriter, req *http.Request) {
 		ctx.Share, _ = _extractShare(req)
 		ctx.Authorization = _extractAuthorization(req)
+		ctx.Token, _ = _extractToken(ctx)
 		ctx.Session, _ = _extractSession(req, ctx)
 		ctx.Backend, _ = _extractBackend(req, ctx)
 
@@ -288,29 +292,46 @@ func _extractSession(req *http.Request, ctx *App) (map[string]string, error) {
 		return session, err
 	}
 
-	if ctx.Authorization == "" {
+	if ctx.Token.Id != "" { // Personal access token
+		str, err = DecryptString(SECRET_KEY_DERIVATE_FOR_USER, ctx.Token.Auth)
+		if err != nil {
+			return session, ErrNotAuthorized
+		} else if err = json.Unmarshal([]byte(str), &session); err != nil {
+			return session, err
+		}
+		if ctx.Token.Path != "" {
+			session["path"] = EnforceDirectory(JoinPath(EnforceDirectory(session["path"]), ctx.Token.Path))
+		}
 		return session, nil
 	}
-	str, err = DecryptString(SECRET_KEY_DERIVATE_FOR_USER, ctx.Authorization)
-	if err != nil {
-		// This typically happen when changing the secret key
-		Log.Debug("middleware::session decrypt error '%s'", err.Error())
-		return session, ErrNotAuthorized
+
+	if ctx.Authorization == "" {
+		return session, nil
 	}
-	if err = json.Unmarshal([]byte(str), &session); err != nil {
+	if session, err = model.SessionDecrypt(ctx.Authorization); err != nil {
 		return session, err
 	}
-	t, err := time.Parse(time.RFC3339, session["timestamp"])
-	if err != nil {
-		Log.Warning("middleware::session 'cannot parse time - %s'", err.Error())
-		return session, ErrNotAuthorized
-	} else if t.Add(24 * 365 * time.Hour).Before(time.Now()) {
-		Log.Warning("middleware::session 'cookie too old - %s'", t.Format(time.RFC3339))
-		return session, ErrNotAuthorized
+	if model.SessionRegistryEnabled() {
+		if err = model.SessionRegistryVerify(session["sid"], ClientIP(req)); err != nil {
+			Log.Debug("middleware::session 'unknown or revoked session - %s'", err.Error())
+			return make(map[string]string), ErrNotAuthorized
+		}
 	}
 	return session, err
 }
 
+func _extractToken(ctx *App) (AccessToken, error) {
+	if strings.HasPrefix(ctx.Authorization, ACCESS_TOKEN_PREFIX) == false {
+		return AccessToken{}, nil
+	}
+	token, err := model.AccessTokenVerify(ctx.Authorization)
+	if err != nil {
+		Log.Debug("middleware::session 'invalid access token - %s'", err.Error())
+		return AccessToken{}, err
+	}
+	return token, nil
+}
+
 func _extractBackend(req *http.Request, ctx *App) (IBackend, error) {
 	return model.NewBackend(ctx, ctx.Session)
 }
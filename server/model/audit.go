@@ -24,7 +24,7 @@ var AuditForm Form = Form{
 				FormElement{
 					Name: "action",
 					Type: "select",
-					Opts: []string{"", "rename", "list", "download", "create_folder", "remove", "move", "save_file", "create_file"},
+					Opts: []string{"", "rename", "list", "download", "create_folder", "remove", "move", "save_file", "create_file", "extract", "copy"},
 				},
 				FormElement{
 					Name: "path",
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"context"
 	"fmt"
 	. "github.com/mickael-kerjean/filestash/server/common"
 	"strings"
@@ -50,6 +51,29 @@ func NewBackend(ctx *App, conn map[string]string) (IBackend, error) {
 	return Backend.Get(conn["type"]).Init(conn, ctx)
 }
 
+/*
+ * NewAppFromToken opens the session behind a token, the token being the encrypted session a user
+ * handed over to run things in the background on its behalf: transfers, workflows, ...
+ * As those can start long after the token was given, a session revoked in the meantime is refused
+ */
+func NewAppFromToken(ctx context.Context, token string) (*App, error) {
+	session, err := SessionDecrypt(token)
+	if err != nil {
+		return nil, err
+	}
+	if SessionRegistryEnabled() {
+		if err = SessionRegistryVerify(session["sid"], ""); err != nil {
+			Log.Debug("model::files 'unknown or revoked session - %s'", err.Error())
+			return nil, ErrNotAuthorized
+		}
+	}
+	app := &App{Context: ctx, Session: session}
+	if app.Backend, err = NewBackend(app, session); err != nil {
+		return nil, err
+	}
+	return app, nil
+}
+
 func GetHome(b IBackend, base string) (string, error) {
 	if strings.TrimSpace(base) == "" {
 		base = "/"
@@ -72,6 +96,55 @@ func GetHome(b IBackend, base string) (string, error) {
 	return "/", nil
 }
 
+/*
+ * Copy a file or a folder within the same backend. When the backend knows how to do it natively, we
+ * let it handle the copy, otherwise the content is streamed from the source to the destination
+ */
+func Cp(b IBackend, from string, to string) error {
+	if from == to {
+		return NewError("Source and destination are the same", 400)
+	} else if IsDirectory(from) != IsDirectory(to) {
+		return NewError("Source and destination must be of the same type", 400)
+	} else if IsDirectory(from) && strings.HasPrefix(to, from) {
+		return NewError("Cannot copy a folder into itself", 400)
+	}
+	if obj, ok := b.(IBackendCopy); ok {
+		if err := obj.Cp(from, to); err != ErrNotSupported && err != ErrNotImplemented {
+			return err
+		}
+	}
+	return cpStream(b, from, to)
+}
+
+func cpStream(b IBackend, from string, to string) error {
+	if IsDirectory(from) == false {
+		f, err := b.Cat(from)
+		if err != nil {
+			return err
+		}
+		err = b.Save(to, f)
+		f.Close()
+		return err
+	}
+	if err := b.Mkdir(to); err != nil {
+		Log.Debug("model::files::cp action=mkdir path=%s err=%s", to, err.Error())
+	}
+	entries, err := b.Ls(from)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() {
+			name += "/"
+		}
+		if err = cpStream(b, from+name, to+name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func MapStringInterfaceToMapStringString(m map[string]interface{}) map[string]string {
 	res := make(map[string]string)
 	for key, value := range m {
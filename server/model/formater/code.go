@@ -0,0 +1,22 @@
+package formater
+
+// source code is plain text, it's only a matter of knowing the mime types they come with
+var codeMimeTypes = []string{
+	"text/x-go",
+	"text/x-python",
+	"text/x-java",
+	"text/x-c",
+	"text/x-c++",
+	"text/x-csharp",
+	"text/x-rust",
+	"text/x-ruby",
+	"text/x-php",
+	"text/x-shellscript",
+	"text/x-kotlin",
+	"text/x-swift",
+	"text/x-scala",
+	"text/x-lua",
+	"text/x-yaml",
+	"text/x-toml",
+	"text/x-log",
+}
@@ -0,0 +1,38 @@
+package formater
+
+import (
+	"encoding/csv"
+	"io"
+	"strings"
+)
+
+func CsvFormater(r io.ReadCloser) (io.ReadCloser, error) {
+	return tabularFormater(r, ',')
+}
+
+func TsvFormater(r io.ReadCloser) (io.ReadCloser, error) {
+	return tabularFormater(r, '\t')
+}
+
+func tabularFormater(r io.ReadCloser, comma rune) (io.ReadCloser, error) {
+	reader := csv.NewReader(r)
+	reader.Comma = comma
+	reader.LazyQuotes = true
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	content := strings.Builder{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			if _, ok := err.(*csv.ParseError); ok {
+				continue
+			}
+			return nil, err
+		}
+		content.WriteString(strings.Join(record, " "))
+		content.WriteString("\n")
+	}
+	return cleanText(content.String()), nil
+}
@@ -0,0 +1,94 @@
+package formater
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+)
+
+// EmlFormater extracts the headers people search for and the body of an email. When a
+// message comes with both a plain text and an html version, we only keep the text one
+func EmlFormater(r io.ReadCloser) (io.ReadCloser, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, err
+	}
+	content := bytes.NewBuffer([]byte{})
+	dec := new(mime.WordDecoder)
+	for _, key := range []string{"Subject", "From", "To", "Cc", "Date"} {
+		value := msg.Header.Get(key)
+		if value == "" {
+			continue
+		} else if v, err := dec.DecodeHeader(value); err == nil {
+			value = v
+		}
+		content.WriteString(key + ": " + value + "\n")
+	}
+	content.WriteString("\n")
+	emlPart(
+		msg.Header.Get("Content-Type"),
+		msg.Header.Get("Content-Transfer-Encoding"),
+		msg.Body,
+		content,
+	)
+	return cleanText(content.String()), nil
+}
+
+func emlPart(contentType string, encoding string, body io.Reader, w *bytes.Buffer) {
+	mType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mType = "text/plain"
+	}
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+
+	if strings.HasPrefix(mType, "multipart/") {
+		parts := multipart.NewReader(body, params["boundary"])
+		alternatives := map[string]*bytes.Buffer{}
+		for {
+			p, err := parts.NextRawPart()
+			if err != nil {
+				break
+			}
+			partType, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+			if mType == "multipart/alternative" {
+				alternatives[partType] = bytes.NewBuffer([]byte{})
+				emlPart(p.Header.Get("Content-Type"), p.Header.Get("Content-Transfer-Encoding"), p, alternatives[partType])
+				continue
+			}
+			emlPart(p.Header.Get("Content-Type"), p.Header.Get("Content-Transfer-Encoding"), p, w)
+		}
+		if b, ok := alternatives["text/plain"]; ok {
+			w.Write(b.Bytes())
+		} else if b, ok := alternatives["text/html"]; ok {
+			w.Write(b.Bytes())
+		} else {
+			for _, b := range alternatives {
+				w.Write(b.Bytes())
+			}
+		}
+		return
+	}
+	switch mType {
+	case "text/plain":
+		io.Copy(w, body)
+		w.WriteString("\n")
+	case "text/html":
+		htmlText(body, w)
+		w.WriteString("\n")
+	case "message/rfc822":
+		if rc, err := EmlFormater(io.NopCloser(body)); err == nil {
+			io.Copy(w, rc)
+			w.WriteString("\n")
+		}
+	}
+}
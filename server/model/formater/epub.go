@@ -0,0 +1,101 @@
+package formater
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+// EpubFormater reads the chapters of a book in the order given by its spine, if the package
+// document can't be found we fallback on every html file of the archive
+func EpubFormater(r io.ReadCloser) (io.ReadCloser, error) {
+	z, cleanup, err := openZip(r)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	chapters := epubSpine(z)
+	if len(chapters) == 0 {
+		for _, f := range z.File {
+			switch strings.ToLower(path.Ext(f.Name)) {
+			case ".html", ".htm", ".xhtml":
+				chapters = append(chapters, f)
+			}
+		}
+	}
+	if len(chapters) == 0 {
+		return nil, ErrNotFound
+	}
+	content := bytes.NewBuffer([]byte{})
+	for _, f := range chapters {
+		o, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		htmlText(o, content)
+		o.Close()
+		content.WriteString("\n")
+	}
+	return cleanText(content.String()), nil
+}
+
+func epubSpine(z *zip.ReadCloser) []*zip.File {
+	container := struct {
+		Rootfiles []struct {
+			FullPath string `xml:"full-path,attr"`
+		} `xml:"rootfiles>rootfile"`
+	}{}
+	if err := epubDecode(z, "META-INF/container.xml", &container); err != nil || len(container.Rootfiles) == 0 {
+		return nil
+	}
+	opfPath := container.Rootfiles[0].FullPath
+	opf := struct {
+		Manifest []struct {
+			ID   string `xml:"id,attr"`
+			Href string `xml:"href,attr"`
+		} `xml:"manifest>item"`
+		Spine []struct {
+			IDRef string `xml:"idref,attr"`
+		} `xml:"spine>itemref"`
+	}{}
+	if err := epubDecode(z, opfPath, &opf); err != nil {
+		return nil
+	}
+	hrefs := map[string]string{}
+	for _, item := range opf.Manifest {
+		hrefs[item.ID] = item.Href
+	}
+	files := []*zip.File{}
+	for _, itemref := range opf.Spine {
+		href, ok := hrefs[itemref.IDRef]
+		if !ok {
+			continue
+		} else if h, err := url.PathUnescape(href); err == nil {
+			href = h
+		}
+		if f := zipFile(z, path.Join(path.Dir(opfPath), href)); f != nil {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+func epubDecode(z *zip.ReadCloser, name string, v any) error {
+	f := zipFile(z, name)
+	if f == nil {
+		return ErrNotFound
+	}
+	o, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer o.Close()
+	return xml.NewDecoder(o).Decode(v)
+}
@@ -0,0 +1,128 @@
+package formater
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+func ExcelFormater(r io.ReadCloser) (io.ReadCloser, error) {
+	z, cleanup, err := openZip(r)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	sharedStrings := []string{}
+	if f := zipFile(z, "xl/sharedStrings.xml"); f != nil {
+		o, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		sharedStrings = excelSharedStrings(o)
+		o.Close()
+	}
+
+	hasData := false
+	content := bytes.NewBuffer([]byte{})
+	for _, f := range z.File {
+		if strings.HasPrefix(f.Name, "xl/worksheets/sheet") == false || strings.HasSuffix(f.Name, ".xml") == false {
+			continue
+		}
+		hasData = true
+		o, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		excelSheet(o, sharedStrings, content)
+		o.Close()
+	}
+	if hasData == false {
+		return nil, ErrNotFound
+	}
+	return cleanText(content.String()), nil
+}
+
+func excelSharedStrings(r io.Reader) []string {
+	out := []string{}
+	dec := xml.NewDecoder(r)
+	var curr *strings.Builder
+	inText := false
+	for {
+		t, err := dec.Token()
+		if err != nil {
+			return out
+		}
+		switch el := t.(type) {
+		case xml.StartElement:
+			if el.Name.Local == "si" {
+				curr = &strings.Builder{}
+			} else if el.Name.Local == "t" {
+				inText = true
+			}
+		case xml.CharData:
+			if inText && curr != nil {
+				curr.Write(el)
+			}
+		case xml.EndElement:
+			if el.Name.Local == "t" {
+				inText = false
+			} else if el.Name.Local == "si" && curr != nil {
+				out = append(out, curr.String())
+				curr = nil
+			}
+		}
+	}
+}
+
+// excelSheet writes a line per row with the value of its cells. Strings are most of the time
+// stored as a reference to the shared string table
+func excelSheet(r io.Reader, sharedStrings []string, w *bytes.Buffer) {
+	dec := xml.NewDecoder(r)
+	cellType := ""
+	inValue := false
+	for {
+		t, err := dec.Token()
+		if err != nil {
+			return
+		}
+		switch el := t.(type) {
+		case xml.StartElement:
+			switch el.Name.Local {
+			case "c":
+				cellType = ""
+				for _, attr := range el.Attr {
+					if attr.Name.Local == "t" {
+						cellType = attr.Value
+					}
+				}
+			case "v", "t":
+				inValue = true
+			}
+		case xml.CharData:
+			if inValue == false {
+				continue
+			}
+			if cellType == "s" {
+				if i, err := strconv.Atoi(string(el)); err == nil && i >= 0 && i < len(sharedStrings) {
+					w.WriteString(sharedStrings[i])
+				}
+			} else {
+				w.Write(el)
+			}
+		case xml.EndElement:
+			switch el.Name.Local {
+			case "v", "t":
+				inValue = false
+			case "c":
+				w.WriteString(" ")
+			case "row":
+				w.WriteString("\n")
+			}
+		}
+	}
+}
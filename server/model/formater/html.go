@@ -0,0 +1,64 @@
+package formater
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+var htmlBlocks = map[string]bool{
+	"p": true, "div": true, "br": true, "li": true, "tr": true, "title": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"section": true, "article": true, "blockquote": true, "pre": true,
+}
+
+func HtmlFormater(r io.ReadCloser) (io.ReadCloser, error) {
+	content := bytes.NewBuffer([]byte{})
+	htmlText(r, content)
+	return cleanText(content.String()), nil
+}
+
+// htmlText writes the visible text of an html document, leaving out scripts and styles
+func htmlText(r io.Reader, w *bytes.Buffer) {
+	z := html.NewTokenizer(r)
+	skip := 0
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return
+		case html.StartTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "script", "style", "noscript", "template":
+				skip += 1
+			case "br":
+				w.WriteString("\n")
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "script", "style", "noscript", "template":
+				if skip > 0 {
+					skip -= 1
+				}
+			}
+			if htmlBlocks[string(name)] {
+				w.WriteString("\n")
+			}
+		case html.SelfClosingTagToken:
+			if name, _ := z.TagName(); htmlBlocks[string(name)] {
+				w.WriteString("\n")
+			}
+		case html.TextToken:
+			if skip > 0 {
+				continue
+			}
+			if text := strings.TrimSpace(string(z.Text())); text != "" {
+				w.WriteString(text)
+				w.WriteString(" ")
+			}
+		}
+	}
+}
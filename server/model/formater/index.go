@@ -0,0 +1,33 @@
+package formater
+
+import (
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+func init() {
+	for _, mType := range []string{
+		"text/plain", "text/org", "text/markdown", "application/x-form",
+		"text/x-ini", "text/css", "application/json", "application/xml",
+		"application/javascript", "application/x-perl", "application/x-tex",
+	} {
+		Hooks.Register.TextExtractor(mType, TxtFormater)
+	}
+	for _, mType := range codeMimeTypes {
+		Hooks.Register.TextExtractor(mType, TxtFormater)
+	}
+	Hooks.Register.TextExtractor("application/pdf", PdfFormater)
+	Hooks.Register.TextExtractor("application/powerpoint", OfficeFormater)
+	Hooks.Register.TextExtractor("application/vnd.ms-powerpoint", OfficeFormater)
+	Hooks.Register.TextExtractor("application/word", OfficeFormater)
+	Hooks.Register.TextExtractor("application/msword", OfficeFormater)
+	Hooks.Register.TextExtractor("application/excel", ExcelFormater)
+	Hooks.Register.TextExtractor("application/vnd.oasis.opendocument.text", OpenDocumentFormater)
+	Hooks.Register.TextExtractor("application/vnd.oasis.opendocument.spreadsheet", OpenDocumentFormater)
+	Hooks.Register.TextExtractor("application/vnd.oasis.opendocument.presentation", OpenDocumentFormater)
+	Hooks.Register.TextExtractor("application/epub+zip", EpubFormater)
+	Hooks.Register.TextExtractor("text/html", HtmlFormater)
+	Hooks.Register.TextExtractor("application/rtf", RtfFormater)
+	Hooks.Register.TextExtractor("message/rfc822", EmlFormater)
+	Hooks.Register.TextExtractor("text/csv", CsvFormater)
+	Hooks.Register.TextExtractor("text/tab-separated-values", TsvFormater)
+}
@@ -0,0 +1,38 @@
+package formater
+
+import (
+	"bytes"
+	"io"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+// OpenDocumentFormater handles the odt, ods and odp formats as they all store their content
+// in the same content.xml file
+func OpenDocumentFormater(r io.ReadCloser) (io.ReadCloser, error) {
+	z, cleanup, err := openZip(r)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	f := zipFile(z, "content.xml")
+	if f == nil {
+		return nil, ErrNotFound
+	}
+	o, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer o.Close()
+	content := bytes.NewBuffer([]byte{})
+	xmlText(o, content, map[string]bool{
+		"p":          true,
+		"h":          true,
+		"table-row":  true,
+		"list-item":  true,
+		"page":       true,
+		"line-break": true,
+	})
+	return cleanText(content.String()), nil
+}
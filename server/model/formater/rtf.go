@@ -0,0 +1,133 @@
+package formater
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// groups whose content isn't part of the document text
+var rtfDestinations = map[string]bool{
+	"fonttbl": true, "colortbl": true, "stylesheet": true, "info": true, "pict": true,
+	"header": true, "footer": true, "headerl": true, "headerr": true, "footerl": true,
+	"footerr": true, "listtable": true, "listoverridetable": true, "revtbl": true,
+	"rsidtbl": true, "generator": true, "xmlnstbl": true, "themedata": true,
+	"colorschememapping": true, "latentstyles": true, "datastore": true, "object": true,
+}
+
+func RtfFormater(r io.ReadCloser) (io.ReadCloser, error) {
+	var (
+		in      = bufio.NewReader(r)
+		out     = strings.Builder{}
+		skip    = []bool{false} // is the current group ignored
+		uc      = 1             // number of fallback characters after a unicode character
+		pending = 0             // fallback characters left to ignore
+	)
+	emit := func(s string) {
+		if pending > 0 {
+			pending -= 1
+			return
+		} else if skip[len(skip)-1] == false {
+			out.WriteString(s)
+		}
+	}
+	for {
+		c, err := in.ReadByte()
+		if err != nil {
+			break
+		}
+		switch c {
+		case '{':
+			skip = append(skip, skip[len(skip)-1])
+		case '}':
+			if len(skip) > 1 {
+				skip = skip[:len(skip)-1]
+			}
+		case '\\':
+			word, param, hasParam := rtfControl(in)
+			switch word {
+			case "*":
+				skip[len(skip)-1] = true
+			case "'":
+				emit(string(rune(param)))
+			case "u":
+				if skip[len(skip)-1] == false {
+					if param < 0 {
+						param += 65536
+					}
+					out.WriteRune(rune(param))
+				}
+				pending = uc
+			case "uc":
+				if hasParam {
+					uc = param
+				}
+			case "par", "line", "row", "sect", "page":
+				emit("\n")
+			case "tab", "cell":
+				emit(" ")
+			case "\\", "{", "}":
+				emit(word)
+			default:
+				if rtfDestinations[word] {
+					skip[len(skip)-1] = true
+				}
+			}
+		case '\r', '\n':
+		default:
+			emit(string(c))
+		}
+	}
+	return cleanText(out.String()), nil
+}
+
+// rtfControl reads what comes after a backslash: either a control symbol or a control
+// word with an optional numeric parameter
+func rtfControl(in *bufio.Reader) (word string, param int, hasParam bool) {
+	c, err := in.ReadByte()
+	if err != nil {
+		return "", 0, false
+	}
+	if c == '\'' {
+		hex := make([]byte, 2)
+		if _, err := io.ReadFull(in, hex); err != nil {
+			return "", 0, false
+		}
+		v, _ := strconv.ParseUint(string(hex), 16, 8)
+		return "'", int(v), true
+	} else if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') {
+		return string(c), 0, false
+	}
+	name := []byte{c}
+	for {
+		if c, err = in.ReadByte(); err != nil {
+			return string(name), 0, false
+		} else if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+			name = append(name, c)
+			continue
+		}
+		break
+	}
+	num := []byte{}
+	if c == '-' || (c >= '0' && c <= '9') {
+		num = append(num, c)
+		for {
+			if c, err = in.ReadByte(); err != nil {
+				break
+			} else if c >= '0' && c <= '9' {
+				num = append(num, c)
+				continue
+			}
+			break
+		}
+	}
+	if err == nil && c != ' ' {
+		in.UnreadByte()
+	}
+	if len(num) > 0 {
+		param, _ = strconv.Atoi(string(num))
+		return string(name), param, true
+	}
+	return string(name), 0, false
+}
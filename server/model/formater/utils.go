@@ -0,0 +1,83 @@
+package formater
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+var (
+	spaceRegexp   = regexp.MustCompile("[ \t\u00a0]+")
+	newlineRegexp = regexp.MustCompile("\\s*\n\\s*")
+)
+
+// openZip makes a zip archive readable from a stream, it needs random access which we don't
+// have with the reader we are given hence the temporary file
+func openZip(r io.Reader) (*zip.ReadCloser, func(), error) {
+	f, err := os.CreateTemp(GetAbsolutePath(TMP_PATH), "formater_*.zip")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() { os.Remove(f.Name()) }
+	_, err = io.Copy(f, r)
+	f.Close()
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	z, err := zip.OpenReader(f.Name())
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return z, func() {
+		z.Close()
+		cleanup()
+	}, nil
+}
+
+func zipFile(z *zip.ReadCloser, name string) *zip.File {
+	for _, f := range z.File {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// xmlText writes the text content of an xml document. Elements listed in blocks are
+// followed by a new line, everything else is separated with a space
+func xmlText(r io.Reader, w *bytes.Buffer, blocks map[string]bool) {
+	dec := xml.NewDecoder(r)
+	dec.Strict = false
+	dec.AutoClose = xml.HTMLAutoClose
+	dec.Entity = xml.HTMLEntity
+	for {
+		t, err := dec.Token()
+		if err != nil {
+			return
+		}
+		switch el := t.(type) {
+		case xml.CharData:
+			w.Write(el)
+		case xml.EndElement:
+			if blocks[el.Name.Local] {
+				w.WriteString("\n")
+			} else {
+				w.WriteString(" ")
+			}
+		}
+	}
+}
+
+func cleanText(s string) io.ReadCloser {
+	s = spaceRegexp.ReplaceAllString(s, " ")
+	s = newlineRegexp.ReplaceAllString(s, "\n")
+	return NewReadCloserFromReader(strings.NewReader(strings.TrimSpace(s)))
+}
@@ -32,6 +32,24 @@ func init() {
 			}
 		}
 
+		if stmt, err := DB.Prepare("CREATE TABLE IF NOT EXISTS Session(id VARCHAR(64) PRIMARY KEY, user VARCHAR(512), backend VARCHAR(64), ip VARCHAR(64), user_agent VARCHAR(512), created_at INTEGER, last_seen INTEGER, expire_at INTEGER)"); err == nil {
+			stmt.Exec()
+		}
+
+		if stmt, err := DB.Prepare("CREATE TABLE IF NOT EXISTS AccessToken(id VARCHAR(16) PRIMARY KEY, name VARCHAR(256), backend VARCHAR(32), auth TEXT NOT NULL, path VARCHAR(512), scope VARCHAR(16), secret VARCHAR(64), created_at INTEGER, expire INTEGER, last_used INTEGER)"); err == nil {
+			stmt.Exec()
+			if stmt, err = DB.Prepare("CREATE INDEX IF NOT EXISTS idx_accesstoken_backend ON AccessToken(backend)"); err == nil {
+				stmt.Exec()
+			}
+		}
+
+		if stmt, err := DB.Prepare("CREATE TABLE IF NOT EXISTS Lock(id VARCHAR(32) PRIMARY KEY, backend VARCHAR(64), path VARCHAR(1024), token VARCHAR(1024), owner TEXT, source VARCHAR(16), infinite BOOLEAN, created_at INTEGER, expire_at INTEGER)"); err == nil {
+			stmt.Exec()
+			if stmt, err = DB.Prepare("CREATE INDEX IF NOT EXISTS idx_lock_path ON Lock(backend, path)"); err == nil {
+				stmt.Exec()
+			}
+		}
+
 		go func() {
 			autovacuum()
 		}()
@@ -42,5 +60,11 @@ func autovacuum() {
 	if stmt, err := DB.Prepare("DELETE FROM Verification WHERE expire < datetime('now')"); err == nil {
 		stmt.Exec()
 	}
+	if stmt, err := DB.Prepare("DELETE FROM Session WHERE expire_at < ?"); err == nil {
+		stmt.Exec(time.Now().Unix())
+	}
+	if stmt, err := DB.Prepare("DELETE FROM Lock WHERE expire_at < ?"); err == nil {
+		stmt.Exec(time.Now().Unix())
+	}
 	time.Sleep(6 * time.Hour)
 }
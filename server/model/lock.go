@@ -0,0 +1,252 @@
+package model
+
+/*
+ * Locks are shared by everything that can change a file: webdav clients, the office editors and
+ * the files API. A lock is held on a path of a backend, covering everything under it when its
+ * depth is infinite, and is only lifted by the one holding its token, by its expiration or by an
+ * administrator. Paths are stored without their trailing slash, the root being an empty string, so
+ * that whatever is under a path starts with the path followed by a slash.
+ */
+
+import (
+	"database/sql"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+// LOCK_MAX_DURATION is how long the longest lock lasts, including those asked for without a timeout
+const LOCK_MAX_DURATION = 24 * time.Hour
+
+type Lock struct {
+	Id        string    `json:"id"`
+	Backend   string    `json:"backend"`
+	Path      string    `json:"path"`
+	Token     string    `json:"-"`
+	Owner     string    `json:"owner"`
+	Source    string    `json:"source"`
+	Infinite  bool      `json:"infinite"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpireAt  time.Time `json:"expire_at"`
+}
+
+// lockMutex makes checking for a conflict and taking the lock a single step
+var lockMutex sync.Mutex
+
+// LockCreate takes a lock unless something already locks the same path
+func LockCreate(l Lock, duration time.Duration) (Lock, error) {
+	lockMutex.Lock()
+	defer lockMutex.Unlock()
+
+	conflicts, err := lockConflicts(l.Backend, l.Path, l.Infinite)
+	if err != nil {
+		return l, err
+	} else if len(conflicts) > 0 {
+		return l, ErrLocked
+	}
+	now := time.Now()
+	l.Id = RandomString(32)
+	l.CreatedAt = now
+	l.ExpireAt = now.Add(lockDuration(duration))
+	stmt, err := DB.Prepare("INSERT INTO Lock(id, backend, path, token, owner, source, infinite, created_at, expire_at) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return l, err
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(l.Id, l.Backend, lockPath(l.Path), l.Token, l.Owner, l.Source, l.Infinite, l.CreatedAt.Unix(), l.ExpireAt.Unix())
+	return l, err
+}
+
+// LockRefresh pushes back the expiration of a lock
+func LockRefresh(backend string, token string, duration time.Duration) (Lock, error) {
+	lockMutex.Lock()
+	defer lockMutex.Unlock()
+
+	l, err := lockGet(backend, token)
+	if err != nil {
+		return l, err
+	}
+	l.ExpireAt = time.Now().Add(lockDuration(duration))
+	stmt, err := DB.Prepare("UPDATE Lock SET expire_at = ? WHERE id = ?")
+	if err != nil {
+		return l, err
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(l.ExpireAt.Unix(), l.Id)
+	return l, err
+}
+
+// LockUpdate gives a new token to a lock, it is how office editors swap a lock for another
+func LockUpdate(backend string, token string, newToken string, duration time.Duration) (Lock, error) {
+	lockMutex.Lock()
+	defer lockMutex.Unlock()
+
+	l, err := lockGet(backend, token)
+	if err != nil {
+		return l, err
+	}
+	l.Token = newToken
+	l.ExpireAt = time.Now().Add(lockDuration(duration))
+	stmt, err := DB.Prepare("UPDATE Lock SET token = ?, expire_at = ? WHERE id = ?")
+	if err != nil {
+		return l, err
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(l.Token, l.ExpireAt.Unix(), l.Id)
+	return l, err
+}
+
+// LockRelease lifts a lock for the one holding its token
+func LockRelease(backend string, token string) error {
+	stmt, err := DB.Prepare("DELETE FROM Lock WHERE backend = ? AND token = ?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	r, err := stmt.Exec(backend, token)
+	if err != nil {
+		return err
+	} else if n, err := r.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// LockForceRelease lifts a lock regardless of who holds it. It is meant for administrators
+func LockForceRelease(id string) error {
+	stmt, err := DB.Prepare("DELETE FROM Lock WHERE id = ?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	r, err := stmt.Exec(id)
+	if err != nil {
+		return err
+	} else if n, err := r.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// LockMove keeps the locks of something which was renamed
+func LockMove(backend string, from string, to string) error {
+	from, to = lockPath(from), lockPath(to)
+	stmt, err := DB.Prepare("UPDATE Lock SET path = ? || substr(path, length(?) + 1) WHERE backend = ? AND (path = ? OR substr(path, 1, length(?) + 1) = ? || '/')")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(to, from, backend, from, from, from)
+	return err
+}
+
+// LockFind gives the locks that apply to a path, either set on the path itself or on one of its
+// parent with an infinite depth
+func LockFind(backend string, path string) ([]Lock, error) {
+	p := lockPath(path)
+	return lockQuery(
+		"backend = ? AND (path = ? OR (infinite AND substr(?, 1, length(path) + 1) = path || '/'))",
+		backend, p, p,
+	)
+}
+
+// LockFindUnder gives the locks that apply to a path and everything that's under it
+func LockFindUnder(backend string, path string) ([]Lock, error) {
+	return lockConflicts(backend, path, true)
+}
+
+// LockCheck makes sure nobody but the holder of one of the tokens has locked a path. Something
+// locked under a folder prevents the folder from being changed
+func LockCheck(backend string, path string, tokens ...string) error {
+	locks, err := lockConflicts(backend, path, IsDirectory(path))
+	if err != nil {
+		return err
+	}
+	for _, l := range locks {
+		held := false
+		for _, token := range tokens {
+			if token != "" && token == l.Token {
+				held = true
+				break
+			}
+		}
+		if held == false {
+			return ErrLocked
+		}
+	}
+	return nil
+}
+
+func LockList() ([]Lock, error) {
+	return lockQuery("1 = 1 ORDER BY created_at DESC")
+}
+
+// LockCovers tells if a lock applies to a path
+func LockCovers(l Lock, path string) bool {
+	lp, p := lockPath(l.Path), lockPath(path)
+	return lp == p || (l.Infinite && strings.HasPrefix(p, lp+"/"))
+}
+
+func lockConflicts(backend string, path string, infinite bool) ([]Lock, error) {
+	if infinite == false {
+		return LockFind(backend, path)
+	}
+	p := lockPath(path)
+	return lockQuery(
+		"backend = ? AND (path = ? OR (infinite AND substr(?, 1, length(path) + 1) = path || '/') OR substr(path, 1, length(?) + 1) = ? || '/')",
+		backend, p, p, p, p,
+	)
+}
+
+func lockGet(backend string, token string) (Lock, error) {
+	locks, err := lockQuery("backend = ? AND token = ?", backend, token)
+	if err != nil {
+		return Lock{}, err
+	} else if len(locks) == 0 {
+		return Lock{}, ErrNotFound
+	}
+	return locks[0], nil
+}
+
+func lockQuery(where string, args ...any) ([]Lock, error) {
+	rows, err := DB.Query(
+		"SELECT id, backend, path, token, owner, source, infinite, created_at, expire_at FROM Lock WHERE expire_at > ? AND "+where,
+		append([]any{time.Now().Unix()}, args...)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	locks := []Lock{}
+	for rows.Next() {
+		var (
+			l                   Lock
+			owner               sql.NullString
+			createdAt, expireAt int64
+		)
+		if err = rows.Scan(&l.Id, &l.Backend, &l.Path, &l.Token, &owner, &l.Source, &l.Infinite, &createdAt, &expireAt); err != nil {
+			return nil, err
+		}
+		if l.Path == "" {
+			l.Path = "/"
+		}
+		l.Owner = owner.String
+		l.CreatedAt = time.Unix(createdAt, 0)
+		l.ExpireAt = time.Unix(expireAt, 0)
+		locks = append(locks, l)
+	}
+	return locks, rows.Err()
+}
+
+func lockPath(path string) string {
+	return strings.TrimSuffix(path, "/")
+}
+
+func lockDuration(d time.Duration) time.Duration {
+	if d <= 0 || d > LOCK_MAX_DURATION {
+		return LOCK_MAX_DURATION
+	}
+	return d
+}
@@ -14,6 +14,8 @@ func CanRead(ctx *App) bool {
 func CanEdit(ctx *App) bool {
 	if ctx.Share.Id != "" {
 		return ctx.Share.CanWrite
+	} else if ctx.Token.Id != "" {
+		return ctx.Token.CanWrite()
 	}
 	return true
 }
@@ -21,6 +23,8 @@ func CanEdit(ctx *App) bool {
 func CanUpload(ctx *App) bool {
 	if ctx.Share.Id != "" {
 		return ctx.Share.CanUpload
+	} else if ctx.Token.Id != "" {
+		return ctx.Token.CanWrite()
 	}
 	return true
 }
@@ -28,6 +32,8 @@ func CanUpload(ctx *App) bool {
 func CanShare(ctx *App) bool {
 	if ctx.Share.Id != "" {
 		return ctx.Share.CanShare
+	} else if ctx.Token.Id != "" {
+		return false
 	}
 	return true
 }
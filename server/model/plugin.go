@@ -4,10 +4,13 @@ import (
 	"archive/zip"
 	"encoding/json"
 	"io"
+	"net/http"
 	"os"
 	"strings"
 
 	. "github.com/mickael-kerjean/filestash/server/common"
+
+	"github.com/gorilla/mux"
 )
 
 var PLUGINS = map[string]PluginImpl{}
@@ -61,13 +64,71 @@ func PluginDiscovery() error {
 				if err != nil {
 					return err
 				}
-				m, err := WasmAdapterForMiddleware(b)
+				m, err := WasmAdapterForMiddleware(name, b)
 				if err != nil {
 					return err
 				}
 				Hooks.Register.Middleware(m)
-			case "http": // TODO
-				return ErrNotImplemented
+			case "http":
+				b, err := GetPluginFile(name, impl.Modules[i]["entrypoint"])
+				if err != nil {
+					return err
+				}
+				h, err := WasmAdapterForHttp(name, b)
+				if err != nil {
+					return err
+				}
+				prefix := WithBase("/api/plugin/" + name + "/")
+				Hooks.Register.HttpEndpoint(func(r *mux.Router) error {
+					r.PathPrefix(prefix).HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+						h(&App{Context: req.Context()}, w, req)
+					})
+					return nil
+				})
+			case "backend":
+				b, err := GetPluginFile(name, impl.Modules[i]["entrypoint"])
+				if err != nil {
+					return err
+				}
+				backend, err := WasmAdapterForBackend(name, b)
+				if err != nil {
+					return err
+				}
+				Backend.Register(name, backend)
+			case "authorisation":
+				b, err := GetPluginFile(name, impl.Modules[i]["entrypoint"])
+				if err != nil {
+					return err
+				}
+				a, err := WasmAdapterForAuthorisation(name, b)
+				if err != nil {
+					return err
+				}
+				Hooks.Register.AuthorisationMiddleware(a)
+			case "thumbnailer":
+				b, err := GetPluginFile(name, impl.Modules[i]["entrypoint"])
+				if err != nil {
+					return err
+				}
+				t, err := WasmAdapterForThumbnailer(name, b)
+				if err != nil {
+					return err
+				}
+				for _, mType := range strings.Split(impl.Modules[i]["mime"], ",") {
+					if mType = strings.TrimSpace(mType); mType != "" {
+						Hooks.Register.Thumbnailer(mType, t)
+					}
+				}
+			case "action":
+				b, err := GetPluginFile(name, impl.Modules[i]["entrypoint"])
+				if err != nil {
+					return err
+				}
+				a, err := WasmAdapterForAction(name, b)
+				if err != nil {
+					return err
+				}
+				Hooks.Register.WorkflowAction(a)
 			}
 		}
 		PLUGINS[name] = impl
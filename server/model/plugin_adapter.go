@@ -3,41 +3,87 @@ package model
 import (
 	"bufio"
 	"bytes"
-	"context"
+	"encoding/json"
 	"io"
 	"net/http"
+	"net/url"
+	"strings"
 
 	. "github.com/mickael-kerjean/filestash/server/common"
 
-	"github.com/tetratelabs/wazero"
 	"github.com/tetratelabs/wazero/api"
 )
 
-func WasmAdapterForMiddleware(wasmBytes []byte) (Middleware, error) {
+// WASM_BODY_MAX_SIZE is how much of the request body a middleware gets to see. A larger body
+// isn't given to the guest at all
+const WASM_BODY_MAX_SIZE = 1024 * 1024
+
+/*
+ * A middleware is given the request it is about to handle:
+ * {
+ *   "method": "POST",
+ *   "url": "/api/files/save?path=/test.txt",
+ *   "proto": "HTTP/1.1",
+ *   "host": "demo.filestash.app",
+ *   "remote_addr": "127.0.0.1:46372",
+ *   "headers": {"Content-Type": ["text/plain"]},
+ *   "body": "aGVsbG8gd29ybGQ=",
+ *   "context": {
+ *     "backend": "ZdIyHbxVDbxbFbKbLcge",
+ *     "session": {"type": "local", "path": "/home/user/"},
+ *     "share": {"id": "...", "path": "/", "can_read": true, ...},
+ *     "languages": ["en"]
+ *   }
+ * }
+ * The body is base64 encoded and only there when it's no larger than WASM_BODY_MAX_SIZE. The
+ * credentials of the user aren't part of it: the Authorization and Cookie headers are removed as
+ * well as the token the url can carry, and the session only has the keys listed in
+ * wasmSessionKeys.
+ * It answers with a raw HTTP response which is sent as is, except for a "204 No Content" which
+ * lets the request through with the headers of the response.
+ */
+type wasmRequest struct {
+	Method     string              `json:"method"`
+	URL        string              `json:"url"`
+	Proto      string              `json:"proto"`
+	Host       string              `json:"host"`
+	RemoteAddr string              `json:"remote_addr"`
+	Headers    map[string][]string `json:"headers"`
+	Body       []byte              `json:"body,omitempty"`
+	Context    wasmAppContext      `json:"context"`
+}
+
+// wasmSessionKeys is what a plugin gets to know about the session, the rest of it being the
+// credentials of the user
+var wasmSessionKeys = []string{"type", "path", "hostname", "user", "username"}
+
+type wasmAppContext struct {
+	Backend   string            `json:"backend,omitempty"`
+	Session   map[string]string `json:"session"`
+	Share     map[string]any    `json:"share,omitempty"`
+	Languages []string          `json:"languages,omitempty"`
+}
+
+func WasmAdapterForMiddleware(name string, wasmBytes []byte) (Middleware, error) {
+	module, err := NewWasmModule(name, wasmBytes)
+	if err != nil {
+		return nil, err
+	} else if module.Exports("middleware") == false {
+		return nil, NewError("plugin::adapter action=export error=missing+middleware+function", http.StatusInternalServerError)
+	}
 	return func(next HandlerFunc) HandlerFunc {
 		return func(app *App, w http.ResponseWriter, r *http.Request) {
-			module, err := wasmPrepare(wasmBytes)
-			if err != nil {
-				SendErrorResult(w, NewError("plugin::adapter action=prepare error="+err.Error(), http.StatusInternalServerError))
-				return
-			}
-			defer module.Close(app.Context)
-			wasmFunc := module.ExportedFunction("middleware")
-			if wasmFunc == nil {
-				SendErrorResult(w, NewError("plugin::adapter action=export error=missing+middleware+function", http.StatusInternalServerError))
-				return
-			}
-			response, err := wasmFunc.Call(app.Context)
+			input, err := wasmMiddlewareInput(app, r)
 			if err != nil {
-				SendErrorResult(w, NewError(err.Error(), http.StatusInternalServerError))
+				SendErrorResult(w, err)
 				return
 			}
-			responseBytes, err := wasmOutput(module.Memory(), response)
+			responseBytes, err := module.Call(app, "middleware", input)
 			if err != nil {
 				SendErrorResult(w, err)
 				return
 			}
-			resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(responseBytes)), nil)
+			resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(responseBytes)), r)
 			if err != nil {
 				SendErrorResult(w, err)
 				return
@@ -58,16 +104,325 @@ func WasmAdapterForMiddleware(wasmBytes []byte) (Middleware, error) {
 	}, nil
 }
 
-func wasmPrepare(wasmBytes []byte) (api.Module, error) {
-	ctx := context.Background()
-	runtime := wazero.NewRuntime(ctx)
-	compiledModule, err := runtime.CompileModule(ctx, wasmBytes)
+func wasmMiddlewareInput(app *App, r *http.Request) ([]byte, error) {
+	in := wasmRequest{
+		Method:     r.Method,
+		URL:        wasmURL(r.URL),
+		Proto:      r.Proto,
+		Host:       r.Host,
+		RemoteAddr: r.RemoteAddr,
+		Headers:    map[string][]string{},
+		Context:    wasmContext(app),
+	}
+	for key, value := range r.Header {
+		switch key {
+		case "Authorization", "Cookie":
+		default:
+			in.Headers[key] = value
+		}
+	}
+	if r.Body != nil && r.ContentLength != 0 && r.ContentLength <= WASM_BODY_MAX_SIZE {
+		// the body is read once and given back to the request for the handlers that come next
+		body, err := io.ReadAll(io.LimitReader(r.Body, WASM_BODY_MAX_SIZE+1))
+		if err != nil {
+			return nil, err
+		}
+		r.Body = wasmBody{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		if len(body) <= WASM_BODY_MAX_SIZE {
+			in.Body = body
+		}
+	}
+	return json.Marshal(in)
+}
+
+// wasmURL is the url of the request without the token a client can authenticate with
+func wasmURL(u *url.URL) string {
+	query := u.Query()
+	if query.Has("authorization") == false && query.Has("access_token") == false {
+		return u.RequestURI()
+	}
+	query.Del("authorization")
+	query.Del("access_token")
+	c := *u
+	c.RawQuery = query.Encode()
+	return c.RequestURI()
+}
+
+func wasmContext(app *App) wasmAppContext {
+	c := wasmAppContext{Session: map[string]string{}}
+	if app == nil {
+		return c
+	}
+	for _, key := range wasmSessionKeys {
+		if value, ok := app.Session[key]; ok {
+			c.Session[key] = value
+		}
+	}
+	if len(app.Session) > 0 {
+		c.Backend = GenerateID(app.Session)
+	}
+	if app.Share.Id != "" {
+		c.Share = map[string]any{
+			"id":         app.Share.Id,
+			"path":       app.Share.Path,
+			"can_read":   app.Share.CanRead,
+			"can_write":  app.Share.CanWrite,
+			"can_upload": app.Share.CanUpload,
+			"can_share":  app.Share.CanShare,
+		}
+	}
+	c.Languages = app.Languages
+	return c
+}
+
+/*
+ * An http endpoint is given the request the same way a middleware is and answers with a raw
+ * HTTP response. It is mounted under /api/plugin/<name>/ and there's no session behind it: it's
+ * for the plugin to decide who can use it.
+ */
+func WasmAdapterForHttp(name string, wasmBytes []byte) (HandlerFunc, error) {
+	module, err := NewWasmModule(name, wasmBytes)
+	if err != nil {
+		return nil, err
+	} else if module.Exports("http") == false {
+		return nil, NewError("plugin::adapter action=export error=missing+http+function", http.StatusInternalServerError)
+	}
+	return func(app *App, w http.ResponseWriter, r *http.Request) {
+		input, err := wasmMiddlewareInput(app, r)
+		if err != nil {
+			SendErrorResult(w, err)
+			return
+		}
+		responseBytes, err := module.Call(app, "http", input)
+		if err != nil {
+			SendErrorResult(w, err)
+			return
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(responseBytes)), r)
+		if err != nil {
+			SendErrorResult(w, err)
+			return
+		}
+		defer resp.Body.Close()
+		for head, value := range resp.Header {
+			w.Header()[head] = value
+		}
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+	}, nil
+}
+
+/*
+ * An authorisation plugin is asked about every operation:
+ * {"action": "mv", "path": "/home/user/a.txt", "to": "/home/user/b.txt", "context": {...}}
+ * where the context is the same as for a middleware. The operation is allowed unless the guest
+ * answers with an error: {"status": "error", "message": "..."}
+ */
+type wasmAuthorisation struct {
+	module *WasmModule
+}
+
+func WasmAdapterForAuthorisation(name string, wasmBytes []byte) (IAuthorisation, error) {
+	module, err := NewWasmModule(name, wasmBytes)
 	if err != nil {
 		return nil, err
+	} else if module.Exports("authorisation") == false {
+		return nil, NewError("plugin::adapter action=export error=missing+authorisation+function", http.StatusInternalServerError)
 	}
-	return runtime.InstantiateModule(ctx, compiledModule, wazero.NewModuleConfig())
+	return wasmAuthorisation{module}, nil
+}
+
+func (this wasmAuthorisation) Ls(ctx *App, path string) error {
+	return this.call(ctx, "ls", path, "")
+}
+
+func (this wasmAuthorisation) Cat(ctx *App, path string) error {
+	return this.call(ctx, "cat", path, "")
+}
+
+func (this wasmAuthorisation) Stat(ctx *App, path string) error {
+	return this.call(ctx, "stat", path, "")
+}
+
+func (this wasmAuthorisation) Mkdir(ctx *App, path string) error {
+	return this.call(ctx, "mkdir", path, "")
+}
+
+func (this wasmAuthorisation) Rm(ctx *App, path string) error {
+	return this.call(ctx, "rm", path, "")
+}
+
+func (this wasmAuthorisation) Mv(ctx *App, from string, to string) error {
+	return this.call(ctx, "mv", from, to)
+}
+
+func (this wasmAuthorisation) Cp(ctx *App, from string, to string) error {
+	return this.call(ctx, "cp", from, to)
+}
+
+func (this wasmAuthorisation) Save(ctx *App, path string) error {
+	return this.call(ctx, "save", path, "")
+}
+
+func (this wasmAuthorisation) Touch(ctx *App, path string) error {
+	return this.call(ctx, "touch", path, "")
+}
+
+func (this wasmAuthorisation) call(ctx *App, action string, path string, to string) error {
+	input, err := json.Marshal(map[string]any{
+		"action":  action,
+		"path":    path,
+		"to":      to,
+		"context": wasmContext(ctx),
+	})
+	if err != nil {
+		return err
+	}
+	out, err := this.module.Call(ctx, "authorisation", input)
+	if err != nil {
+		return err
+	}
+	return wasmDecode(out, nil)
+}
+
+/*
+ * A thumbnailer is given the content of a file as is, not as JSON, and answers with its thumbnail.
+ * The content type of the thumbnail is guessed from what it looks like
+ */
+const WASM_THUMBNAIL_MAX_SIZE = 16 * 1024 * 1024
+
+type wasmThumbnailer struct {
+	module *WasmModule
+}
+
+func WasmAdapterForThumbnailer(name string, wasmBytes []byte) (IThumbnailer, error) {
+	module, err := NewWasmModule(name, wasmBytes)
+	if err != nil {
+		return nil, err
+	} else if module.Exports("thumbnailer") == false {
+		return nil, NewError("plugin::adapter action=export error=missing+thumbnailer+function", http.StatusInternalServerError)
+	}
+	return wasmThumbnailer{module}, nil
+}
+
+func (this wasmThumbnailer) Generate(reader io.ReadCloser, ctx *App, res *http.ResponseWriter, req *http.Request) (io.ReadCloser, error) {
+	defer reader.Close()
+	b, err := io.ReadAll(io.LimitReader(reader, WASM_THUMBNAIL_MAX_SIZE+1))
+	if err != nil {
+		return nil, err
+	} else if len(b) > WASM_THUMBNAIL_MAX_SIZE {
+		return nil, NewError("File is too large to generate a thumbnail", http.StatusRequestEntityTooLarge)
+	}
+	out, err := this.module.Call(ctx, "thumbnailer", b)
+	if err != nil {
+		return nil, err
+	} else if len(out) == 0 {
+		return nil, NewError("Plugin didn't generate a thumbnail", http.StatusInternalServerError)
+	}
+	mType := http.DetectContentType(out)
+	if strings.HasPrefix(mType, "text/") && bytes.Contains(out[:min(len(out), 512)], []byte("<svg")) {
+		mType = "image/svg+xml" // not something http.DetectContentType knows about
+	}
+	(*res).Header().Set("Content-Type", mType)
+	return NewReadCloserFromBytes(out), nil
+}
+
+/*
+ * A workflow action describes itself with {"action": "manifest"} which answers with its specs:
+ * {"name": "...", "title": "...", "subtitle": "...", "icon": "...", "specs": {...}}
+ * and is run with {"action": "execute", "params": {...}, "input": {...}} which answers with its
+ * output as a map of string
+ */
+type wasmAction struct {
+	module   *WasmModule
+	manifest WorkflowSpecs
+}
+
+func WasmAdapterForAction(name string, wasmBytes []byte) (IAction, error) {
+	module, err := NewWasmModule(name, wasmBytes)
+	if err != nil {
+		return nil, err
+	} else if module.Exports("action") == false {
+		return nil, NewError("plugin::adapter action=export error=missing+action+function", http.StatusInternalServerError)
+	}
+	this := &wasmAction{module: module}
+	out, err := module.Call(nil, "action", []byte(`{"action":"manifest"}`))
+	if err != nil {
+		return nil, err
+	} else if err = wasmDecode(out, &this.manifest); err != nil {
+		return nil, err
+	} else if this.manifest.Name == "" {
+		this.manifest.Name = name
+	}
+	return this, nil
+}
+
+func (this *wasmAction) Manifest() WorkflowSpecs {
+	return this.manifest
+}
+
+func (this *wasmAction) Execute(params map[string]string, input map[string]string) (map[string]string, error) {
+	b, err := json.Marshal(map[string]any{
+		"action": "execute",
+		"params": params,
+		"input":  input,
+	})
+	if err != nil {
+		return nil, err
+	}
+	out, err := this.module.Call(nil, "action", b)
+	if err != nil {
+		return nil, err
+	}
+	output := map[string]string{}
+	if err = wasmDecode(out, &output); err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+// wasmDecode reads what a guest answered, in the same envelope as the API. An error can come with
+// the status code that best describes it: {"status": "error", "message": "...", "code": 404}
+func wasmDecode(out []byte, result any) error {
+	if len(out) == 0 {
+		return nil
+	}
+	var r struct {
+		Status  string          `json:"status"`
+		Message string          `json:"message"`
+		Code    int             `json:"code"`
+		Result  json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(out, &r); err != nil {
+		return NewError("Invalid WASM response", http.StatusInternalServerError)
+	} else if r.Status == "error" {
+		switch r.Code {
+		case http.StatusNotFound:
+			return ErrNotFound
+		case http.StatusUnauthorized:
+			return ErrNotAuthorized
+		case http.StatusForbidden:
+			return ErrPermissionDenied
+		case http.StatusConflict:
+			return ErrConflict
+		case http.StatusNotImplemented:
+			return ErrNotImplemented
+		case 0:
+			r.Code = http.StatusInternalServerError
+		}
+		return NewError(r.Message, r.Code)
+	} else if result == nil || len(r.Result) == 0 {
+		return nil
+	}
+	return json.Unmarshal(r.Result, result)
+}
+
+type wasmBody struct {
+	io.Reader
+	io.Closer
 }
 
+// wasmOutput reads the NUL terminated answer of a guest which predates the ABI taking parameters
 func wasmOutput(memory api.Memory, response []uint64) ([]byte, error) {
 	if len(response) != 1 {
 		return nil, NewError("Invalid WASM response", http.StatusInternalServerError)
@@ -89,5 +444,7 @@ func wasmOutput(memory api.Memory, response []uint64) ([]byte, error) {
 	}
 found:
 	responseBytes, _ := memory.Read(ptr, responseLength)
-	return responseBytes, nil
+	out := make([]byte, len(responseBytes))
+	copy(out, responseBytes)
+	return out, nil
 }
@@ -0,0 +1,142 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+// WASM_BACKEND_MAX_SIZE is the largest file a backend can be given or send back, as it goes
+// through the memory of the guest in one go
+const WASM_BACKEND_MAX_SIZE = 16 * 1024 * 1024
+
+/*
+ * A storage backend is asked to do one thing at a time with the parameters of the connection:
+ * {"action": "ls", "path": "/home/", "params": {"hostname": "...", "password": "..."}}
+ * An action being one of: login_form, ls, stat, cat, mkdir, rm, mv, save and touch. "mv" comes with
+ * a "to" and "save" with the "content" of the file, base64 encoded.
+ * It answers using the same envelope as the API, a file having its modification time in seconds:
+ *   - login_form: [{"label": "hostname", "type": "text"}, ...]
+ *   - ls:         [{"name": "a.txt", "type": "file", "size": 12, "time": 1700000000}, ...]
+ *   - stat:       {"name": "a.txt", "type": "file", "size": 12, "time": 1700000000}
+ *   - cat:        the content of the file, base64 encoded
+ * A guest has no state of its own between calls, the parameters being all it knows about the
+ * connection.
+ */
+type wasmBackend struct {
+	module *WasmModule
+	name   string
+	params map[string]string
+	app    *App
+	form   Form
+}
+
+func WasmAdapterForBackend(name string, wasmBytes []byte) (IBackend, error) {
+	module, err := NewWasmModule(name, wasmBytes)
+	if err != nil {
+		return nil, err
+	} else if module.Exports("backend") == false {
+		return nil, NewError("plugin::adapter action=export error=missing+backend+function", http.StatusInternalServerError)
+	}
+	this := &wasmBackend{module: module, name: name}
+	elmnts := []FormElement{}
+	if err = this.call("login_form", map[string]any{}, &elmnts); err != nil {
+		return nil, err
+	}
+	this.form = Form{Elmnts: []FormElement{{Name: "type", Type: "hidden", Value: name}}}
+	for _, el := range elmnts {
+		if el.Name != "type" {
+			this.form.Elmnts = append(this.form.Elmnts, el)
+		}
+	}
+	return this, nil
+}
+
+func (this *wasmBackend) Init(params map[string]string, app *App) (IBackend, error) {
+	backend := *this
+	backend.params = params
+	// the guest can't reach a backend through the host, it would be itself
+	backend.app = &App{}
+	if app != nil {
+		backend.app.Context = app.Context
+	}
+	return &backend, nil
+}
+
+func (this *wasmBackend) LoginForm() Form {
+	return this.form
+}
+
+func (this *wasmBackend) Ls(path string) ([]os.FileInfo, error) {
+	files := []File{}
+	if err := this.call("ls", map[string]any{"path": path}, &files); err != nil {
+		return nil, err
+	}
+	out := make([]os.FileInfo, len(files))
+	for i := range files {
+		out[i] = files[i]
+	}
+	return out, nil
+}
+
+func (this *wasmBackend) Stat(path string) (os.FileInfo, error) {
+	var file *File
+	if err := this.call("stat", map[string]any{"path": path}, &file); err != nil {
+		return nil, err
+	} else if file == nil {
+		return nil, ErrNotFound
+	}
+	return *file, nil
+}
+
+func (this *wasmBackend) Cat(path string) (io.ReadCloser, error) {
+	var content []byte
+	if err := this.call("cat", map[string]any{"path": path}, &content); err != nil {
+		return nil, err
+	}
+	return NewReadCloserFromBytes(content), nil
+}
+
+func (this *wasmBackend) Mkdir(path string) error {
+	return this.call("mkdir", map[string]any{"path": path}, nil)
+}
+
+func (this *wasmBackend) Rm(path string) error {
+	return this.call("rm", map[string]any{"path": path}, nil)
+}
+
+func (this *wasmBackend) Mv(from string, to string) error {
+	return this.call("mv", map[string]any{"path": from, "to": to}, nil)
+}
+
+func (this *wasmBackend) Save(path string, file io.Reader) error {
+	var content bytes.Buffer
+	if n, err := io.Copy(&content, io.LimitReader(file, WASM_BACKEND_MAX_SIZE+1)); err != nil {
+		return err
+	} else if n > WASM_BACKEND_MAX_SIZE {
+		return NewError("File is too large", http.StatusRequestEntityTooLarge)
+	}
+	return this.call("save", map[string]any{"path": path, "content": content.Bytes()}, nil)
+}
+
+func (this *wasmBackend) Touch(path string) error {
+	return this.call("touch", map[string]any{"path": path}, nil)
+}
+
+func (this *wasmBackend) call(action string, input map[string]any, result any) error {
+	input["action"] = action
+	input["params"] = this.params
+	b, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	out, err := this.module.Call(this.app, "backend", b)
+	if err != nil {
+		return err
+	}
+	return wasmDecode(out, result)
+}
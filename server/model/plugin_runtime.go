@@ -0,0 +1,363 @@
+package model
+
+/*
+ * WASM plugins talk to Filestash through a small ABI.
+ *
+ * The guest exports:
+ *   - memory
+ *   - alloc(size: i32) -> i32                   gives the host somewhere to write its input. The
+ *                                                memory is the guest's to free once it's done
+ *   - an entrypoint per kind of plugin, eg:      middleware(ptr: i32, len: i32) -> i64
+ *
+ * The host writes what the guest needs to know as JSON in memory it got from alloc and calls the
+ * entrypoint with it. The guest answers with a pointer and a length packed in an i64, the pointer
+ * being the high 32 bits. Answering 0 means there is nothing to say. A guest built before the
+ * entrypoint took any parameter still works and answers with a NUL terminated string.
+ *
+ * The host exports under the "filestash" module:
+ *   - log(level: i32, ptr: i32, len: i32)        level being 0: debug, 1: info, 2: warning, 3: error
+ *   - config_get(ptr: i32, len: i32) -> i64      the value of a configuration key, eg: "general.host"
+ *   - backend_stat(ptr: i32, len: i32) -> i64    a file of the current user, eg:
+ *                                                {"name": "a.txt", "type": "file", "size": 12, "time": 1700000000}
+ *   - backend_ls(ptr: i32, len: i32) -> i64      what's in a folder of the current user
+ * Those answer using the same envelope as the API: {"status": "ok", "result": ...} or
+ * {"status": "error", "message": "..."}.
+ * WASI is also available for guests compiled against it, without access to the file system. They
+ * must be built as a reactor, eg: "-buildmode=c-shared", as a command exits once its main is done.
+ *
+ * A module is compiled once and its instances are kept in a pool to be reused between calls.
+ * An instance can't grow its memory past WASM_MEMORY_LIMIT and a call can't last more than
+ * WASM_TIMEOUT. An instance which failed is thrown away.
+ */
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+const (
+	WASM_MEMORY_LIMIT = 64 * 1024 * 1024
+	WASM_TIMEOUT      = 10 * time.Second
+	WASM_PAGE_SIZE    = 64 * 1024
+)
+
+type wasmContextKey struct{}
+
+// wasmCompilationCache saves us from compiling the same module again when a plugin uses it for
+// several things
+var wasmCompilationCache = wazero.NewCompilationCache()
+
+type WasmModule struct {
+	name     string
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+	pool     chan api.Module
+}
+
+func NewWasmModule(name string, wasmBytes []byte) (*WasmModule, error) {
+	ctx := context.Background()
+	r := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(WASM_MEMORY_LIMIT/WASM_PAGE_SIZE).
+		WithCompilationCache(wasmCompilationCache).
+		WithCloseOnContextDone(true))
+	this := &WasmModule{
+		name:    name,
+		runtime: r,
+		pool:    make(chan api.Module, runtime.NumCPU()),
+	}
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, r); err != nil {
+		r.Close(ctx)
+		return nil, err
+	}
+	_, err := r.NewHostModuleBuilder("filestash").
+		NewFunctionBuilder().WithFunc(this.hostLog).Export("log").
+		NewFunctionBuilder().WithFunc(this.hostConfigGet).Export("config_get").
+		NewFunctionBuilder().WithFunc(this.hostBackendStat).Export("backend_stat").
+		NewFunctionBuilder().WithFunc(this.hostBackendLs).Export("backend_ls").
+		Instantiate(ctx)
+	if err != nil {
+		r.Close(ctx)
+		return nil, err
+	}
+	if this.compiled, err = r.CompileModule(ctx, wasmBytes); err != nil {
+		r.Close(ctx)
+		return nil, err
+	}
+	return this, nil
+}
+
+// Exports tells if the guest has a function
+func (this *WasmModule) Exports(fn string) bool {
+	_, ok := this.compiled.ExportedFunctions()[fn]
+	return ok
+}
+
+// Call runs a function of the guest in the context of a request. The input is given to the guest
+// as it is, a nil input meaning the function doesn't take any
+func (this *WasmModule) Call(app *App, fn string, input []byte) ([]byte, error) {
+	parent := context.Background()
+	if app != nil && app.Context != nil {
+		parent = app.Context
+	}
+	ctx, cancel := context.WithTimeout(context.WithValue(parent, wasmContextKey{}, app), WASM_TIMEOUT)
+	defer cancel()
+
+	module, err := this.acquire(ctx)
+	if err != nil {
+		return nil, this.error(ctx, "instantiate", err)
+	}
+	wasmFunc := module.ExportedFunction(fn)
+	if wasmFunc == nil {
+		this.release(module)
+		return nil, NewError("plugin::wasm missing function "+fn, http.StatusNotImplemented)
+	}
+	if len(wasmFunc.Definition().ParamTypes()) == 0 {
+		res, err := wasmFunc.Call(ctx)
+		if err != nil {
+			module.Close(context.Background())
+			return nil, this.error(ctx, fn, err)
+		}
+		out, err := wasmOutput(module.Memory(), res)
+		this.release(module)
+		return out, err
+	}
+	params := []uint64{0, 0}
+	if len(input) > 0 {
+		ptr, err := wasmWrite(ctx, module, input)
+		if err != nil {
+			module.Close(context.Background())
+			return nil, this.error(ctx, "alloc", err)
+		}
+		params = []uint64{uint64(ptr), uint64(len(input))}
+	}
+	res, err := wasmFunc.Call(ctx, params...)
+	if err != nil {
+		module.Close(context.Background())
+		return nil, this.error(ctx, fn, err)
+	}
+	out, err := wasmRead(module.Memory(), res)
+	if err != nil {
+		module.Close(context.Background())
+		return nil, err
+	}
+	this.release(module)
+	return out, nil
+}
+
+func (this *WasmModule) acquire(ctx context.Context) (api.Module, error) {
+	for {
+		select {
+		case module := <-this.pool:
+			if module.IsClosed() {
+				continue
+			}
+			return module, nil
+		default:
+			return this.runtime.InstantiateModule(
+				ctx, this.compiled,
+				wazero.NewModuleConfig().WithName("").WithStartFunctions("_initialize"),
+			)
+		}
+	}
+}
+
+func (this *WasmModule) release(module api.Module) {
+	if module.IsClosed() {
+		return
+	}
+	select {
+	case this.pool <- module:
+	default:
+		module.Close(context.Background())
+	}
+}
+
+func (this *WasmModule) error(ctx context.Context, action string, err error) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		Log.Warning("plugin::wasm name=%s action=%s err=timeout", this.name, action)
+		return NewError("Plugin took too long to answer", http.StatusGatewayTimeout)
+	}
+	Log.Warning("plugin::wasm name=%s action=%s err=%s", this.name, action, err.Error())
+	return NewError(err.Error(), http.StatusInternalServerError)
+}
+
+func (this *WasmModule) hostLog(ctx context.Context, module api.Module, level, ptr, size uint32) {
+	msg, ok := module.Memory().Read(ptr, size)
+	if !ok {
+		return
+	}
+	switch level {
+	case 0:
+		Log.Debug("plugin::%s %s", this.name, string(msg))
+	case 1:
+		Log.Info("plugin::%s %s", this.name, string(msg))
+	case 2:
+		Log.Warning("plugin::%s %s", this.name, string(msg))
+	default:
+		Log.Error("plugin::%s %s", this.name, string(msg))
+	}
+}
+
+// hostConfigGet gives what's in the general, features and log sections of the configuration.
+// The other sections are where the authentication lives, secrets are kept out from everywhere
+func (this *WasmModule) hostConfigGet(ctx context.Context, module api.Module, ptr, size uint32) uint64 {
+	key, ok := module.Memory().Read(ptr, size)
+	if !ok {
+		return wasmReply(ctx, module, nil, ErrNotValid)
+	}
+	allowed := false
+	for _, section := range []string{"general.", "features.", "log."} {
+		if strings.HasPrefix(string(key), section) {
+			allowed = true
+			break
+		}
+	}
+	if allowed == false || strings.Contains(strings.ToLower(string(key)), "secret") {
+		return wasmReply(ctx, module, nil, ErrNotFound)
+	}
+	el, ok := Config.Lookup(string(key))
+	if !ok || el.Type == "password" || el.Type == "bcrypt" {
+		return wasmReply(ctx, module, nil, ErrNotFound)
+	} else if el.Value == nil {
+		return wasmReply(ctx, module, el.Default, nil)
+	}
+	return wasmReply(ctx, module, el.Value, nil)
+}
+
+func (this *WasmModule) hostBackendStat(ctx context.Context, module api.Module, ptr, size uint32) uint64 {
+	app, path, err := wasmBackendPath(ctx, module, ptr, size)
+	if err != nil {
+		return wasmReply(ctx, module, nil, err)
+	}
+	for _, auth := range Hooks.Get.AuthorisationMiddleware() {
+		if err = auth.Stat(app, path); err != nil {
+			return wasmReply(ctx, module, nil, ErrNotAuthorized)
+		}
+	}
+	f, err := app.Backend.Stat(path)
+	if err != nil {
+		return wasmReply(ctx, module, nil, err)
+	}
+	return wasmReply(ctx, module, wasmFile(f), nil)
+}
+
+func (this *WasmModule) hostBackendLs(ctx context.Context, module api.Module, ptr, size uint32) uint64 {
+	app, path, err := wasmBackendPath(ctx, module, ptr, size)
+	if err != nil {
+		return wasmReply(ctx, module, nil, err)
+	}
+	for _, auth := range Hooks.Get.AuthorisationMiddleware() {
+		if err = auth.Ls(app, path); err != nil {
+			return wasmReply(ctx, module, nil, ErrNotAuthorized)
+		}
+	}
+	entries, err := app.Backend.Ls(EnforceDirectory(path))
+	if err != nil {
+		return wasmReply(ctx, module, nil, err)
+	}
+	files := make([]File, len(entries))
+	for i := range entries {
+		files[i] = wasmFile(entries[i])
+	}
+	return wasmReply(ctx, module, files, nil)
+}
+
+// wasmBackendPath resolves a path given by the guest the same way the files API does, from where
+// the user is allowed to go
+func wasmBackendPath(ctx context.Context, module api.Module, ptr, size uint32) (*App, string, error) {
+	app, _ := ctx.Value(wasmContextKey{}).(*App)
+	if app == nil || app.Backend == nil {
+		return nil, "", ErrNotAllowed
+	}
+	p, ok := module.Memory().Read(ptr, size)
+	if !ok || len(p) == 0 {
+		return nil, "", ErrNotValid
+	}
+	path := filepath.ToSlash(filepath.Join(app.Session["path"], string(p)))
+	if strings.HasSuffix(string(p), "/") && path != "/" {
+		path += "/"
+	}
+	if strings.HasPrefix(path, app.Session["path"]) == false {
+		return nil, "", ErrFilesystemError
+	}
+	return app, path, nil
+}
+
+func wasmFile(f os.FileInfo) File {
+	file := File{FName: f.Name(), FType: "file", FSize: f.Size()}
+	if f.IsDir() {
+		file.FType = "directory"
+	}
+	if t := f.ModTime(); t.IsZero() == false {
+		file.FTime = t.Unix()
+	}
+	return file
+}
+
+// wasmReply writes the answer of a host function in the guest memory
+func wasmReply(ctx context.Context, module api.Module, result any, err error) uint64 {
+	var (
+		b    []byte
+		jerr error
+	)
+	if err != nil {
+		b, jerr = json.Marshal(map[string]any{"status": "error", "message": err.Error()})
+	} else {
+		b, jerr = json.Marshal(map[string]any{"status": "ok", "result": result})
+	}
+	if jerr != nil {
+		return 0
+	}
+	ptr, err := wasmWrite(ctx, module, b)
+	if err != nil {
+		return 0
+	}
+	return uint64(ptr)<<32 | uint64(len(b))
+}
+
+func wasmWrite(ctx context.Context, module api.Module, data []byte) (uint32, error) {
+	alloc := module.ExportedFunction("alloc")
+	if alloc == nil {
+		return 0, NewError("missing alloc function", http.StatusInternalServerError)
+	}
+	res, err := alloc.Call(ctx, uint64(len(data)))
+	if err != nil {
+		return 0, err
+	} else if len(res) != 1 {
+		return 0, NewError("Invalid WASM allocation", http.StatusInternalServerError)
+	}
+	ptr := uint32(res[0])
+	if module.Memory().Write(ptr, data) == false {
+		return 0, NewError("Invalid WASM allocation", http.StatusInternalServerError)
+	}
+	return ptr, nil
+}
+
+func wasmRead(memory api.Memory, response []uint64) ([]byte, error) {
+	if len(response) != 1 {
+		return nil, NewError("Invalid WASM response", http.StatusInternalServerError)
+	} else if response[0] == 0 {
+		return nil, nil
+	}
+	ptr, size := uint32(response[0]>>32), uint32(response[0])
+	b, ok := memory.Read(ptr, size)
+	if !ok {
+		return nil, NewError("Invalid WASM response", http.StatusInternalServerError)
+	}
+	// the instance is reused, what's in its memory won't stay there
+	out := make([]byte, len(b))
+	copy(out, b)
+	return out, nil
+}
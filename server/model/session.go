@@ -0,0 +1,136 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+// SessionEntry is what the server side registry knows about a session. The session itself still
+// lives in the encrypted cookie, the registry only holds its id so it can be listed and revoked
+type SessionEntry struct {
+	Id        string    `json:"id"`
+	User      string    `json:"user"`
+	Backend   string    `json:"backend"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	CreatedAt time.Time `json:"created_at"`
+	LastSeen  time.Time `json:"last_seen"`
+	ExpireAt  time.Time `json:"expire_at"`
+}
+
+// sessionVerified avoids hitting the database on every request. Revoking a session removes it
+// from there, it is only the last seen information which is slightly behind
+var sessionVerified = NewQuickCache(30, 60)
+
+func SessionRegistryEnabled() bool {
+	return Config.Get("general.session_registry").Bool()
+}
+
+func SessionRegistryCreate(s SessionEntry) error {
+	stmt, err := DB.Prepare("INSERT INTO Session(id, user, backend, ip, user_agent, created_at, last_seen, expire_at) VALUES(?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(s.Id, s.User, s.Backend, s.IP, s.UserAgent, s.CreatedAt.Unix(), s.LastSeen.Unix(), s.ExpireAt.Unix())
+	return err
+}
+
+// SessionRegistryVerify makes sure a session is still known to the registry and keep track of
+// where it was last seen from. An empty ip is for the work done in the background on behalf of
+// the user, it doesn't change where the session was last seen from
+func SessionRegistryVerify(id string, ip string) error {
+	if id == "" {
+		return ErrNotAuthorized
+	}
+	if lastIP, ok := sessionVerified.Get(map[string]string{"id": id}).(string); ok && (lastIP == ip || ip == "") {
+		return nil
+	}
+	stmt, err := DB.Prepare("UPDATE Session SET last_seen = ?, ip = COALESCE(NULLIF(?, ''), ip) WHERE id = ? AND expire_at > ?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	now := time.Now().Unix()
+	r, err := stmt.Exec(now, ip, id, now)
+	if err != nil {
+		return err
+	} else if n, err := r.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotAuthorized
+	}
+	if ip != "" {
+		sessionVerified.Set(map[string]string{"id": id}, ip)
+	}
+	return nil
+}
+
+func SessionRegistryList() ([]SessionEntry, error) {
+	rows, err := DB.Query(
+		"SELECT id, user, backend, ip, user_agent, created_at, last_seen, expire_at FROM Session WHERE expire_at > ? ORDER BY last_seen DESC",
+		time.Now().Unix(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sessions := []SessionEntry{}
+	for rows.Next() {
+		var (
+			s                             SessionEntry
+			createdAt, lastSeen, expireAt int64
+		)
+		if err = rows.Scan(&s.Id, &s.User, &s.Backend, &s.IP, &s.UserAgent, &createdAt, &lastSeen, &expireAt); err != nil {
+			return nil, err
+		}
+		s.CreatedAt = time.Unix(createdAt, 0)
+		s.LastSeen = time.Unix(lastSeen, 0)
+		s.ExpireAt = time.Unix(expireAt, 0)
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+func SessionRegistryRevoke(id string) error {
+	stmt, err := DB.Prepare("DELETE FROM Session WHERE id = ?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	r, err := stmt.Exec(id)
+	if err != nil {
+		return err
+	}
+	sessionVerified.Del(map[string]string{"id": id})
+	if n, err := r.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SessionDecrypt opens the session behind an authorization token. A session is good for a year,
+// after which the user has to login again
+func SessionDecrypt(token string) (map[string]string, error) {
+	session := map[string]string{}
+	str, err := DecryptString(SECRET_KEY_DERIVATE_FOR_USER, token)
+	if err != nil {
+		// This typically happen when changing the secret key
+		Log.Debug("model::session decrypt error '%s'", err.Error())
+		return session, ErrNotAuthorized
+	}
+	if err = json.Unmarshal([]byte(str), &session); err != nil {
+		return session, err
+	}
+	t, err := time.Parse(time.RFC3339, session["timestamp"])
+	if err != nil {
+		Log.Warning("model::session 'cannot parse time - %s'", err.Error())
+		return session, ErrNotAuthorized
+	} else if t.Add(24 * 365 * time.Hour).Before(time.Now()) {
+		Log.Warning("model::session 'cookie too old - %s'", t.Format(time.RFC3339))
+		return session, ErrNotAuthorized
+	}
+	return session, nil
+}
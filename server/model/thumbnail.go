@@ -0,0 +1,285 @@
+package model
+
+/*
+ * Thumbnails are expensive to make: the original has to be fetched from the storage before being
+ * handed over to a thumbnailer. The result is kept on disk in a size bounded LRU cache shared by all
+ * the thumbnailers. Entries are keyed by backend, path, modification time and size so a change to
+ * the original naturally stops its old thumbnail from being used.
+ * Each entry is a single file made of the content type on the first line followed by the thumbnail
+ * itself. The modification time of those files is how we know what was used last after a restart.
+ */
+
+import (
+	"bufio"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+// THUMBNAIL_CACHE_MAX_ENTRY prevents a thumbnailer giving back the original file from filling up
+// the cache with it
+const THUMBNAIL_CACHE_MAX_ENTRY = 4 * 1024 * 1024
+
+var ThumbnailCacheSize = func() int {
+	return Config.Get("features.thumbnail.cache_size").Schema(func(f *FormElement) *FormElement {
+		if f == nil {
+			f = &FormElement{}
+		}
+		f.Name = "cache_size"
+		f.Type = "number"
+		f.Description = "Maximum size in MB of the disk cache for generated thumbnails. 0 disables the cache. Default: 512"
+		f.Placeholder = "Default: 512MB"
+		f.Default = 512
+		return f
+	}).Int()
+}
+
+var thumbnails = &thumbnailCache{}
+
+func init() {
+	Hooks.Register.Onload(func() {
+		ThumbnailCacheSize()
+	})
+}
+
+type thumbnailCache struct {
+	mu      sync.Mutex
+	once    sync.Once
+	lru     *list.List // front is the most recently used
+	entries map[string]*list.Element
+	size    int64
+}
+
+type thumbnailEntry struct {
+	key  string
+	size int64
+}
+
+// ThumbnailCacheKey gives the key under which the thumbnail of a file is stored. It is empty when
+// the backend can't tell when the file was last modified as we would have no way to invalidate it
+func ThumbnailCacheKey(ctx *App, path string, f os.FileInfo) string {
+	if f == nil || f.ModTime().Unix() <= 0 {
+		return ""
+	}
+	h := sha256.Sum256([]byte(fmt.Sprintf(
+		"%s::%s::%d::%d",
+		GenerateID(ctx.Session), path, f.ModTime().UnixNano(), f.Size(),
+	)))
+	return hex.EncodeToString(h[:])
+}
+
+// ThumbnailCacheGet returns a cached thumbnail alongside its content type
+func ThumbnailCacheGet(key string) (io.ReadCloser, string, bool) {
+	if key == "" || thumbnailCacheMaxSize() <= 0 {
+		return nil, "", false
+	}
+	thumbnails.mu.Lock()
+	defer thumbnails.mu.Unlock()
+	thumbnails.init()
+
+	el, ok := thumbnails.entries[key]
+	if !ok {
+		return nil, "", false
+	}
+	p := thumbnailCachePath(key)
+	f, err := os.Open(p)
+	if err != nil {
+		thumbnails.remove(el)
+		return nil, "", false
+	}
+	r := bufio.NewReader(f)
+	contentType, err := r.ReadString('\n')
+	if err != nil {
+		f.Close()
+		thumbnails.remove(el)
+		return nil, "", false
+	}
+	thumbnails.lru.MoveToFront(el)
+	now := time.Now()
+	os.Chtimes(p, now, now)
+	return thumbnailReader{r, f}, strings.TrimSpace(contentType), true
+}
+
+// ThumbnailCachePut returns a reader giving the same content as the thumbnail it was given while
+// storing it in the cache. Nothing is stored unless the thumbnail was read in full
+func ThumbnailCachePut(key string, contentType string, thumbnail io.ReadCloser) io.ReadCloser {
+	if key == "" || thumbnailCacheMaxSize() <= 0 || strings.ContainsAny(contentType, "\r\n") {
+		return thumbnail
+	}
+	tmp, err := os.CreateTemp(GetAbsolutePath(THUMB_PATH), ".*.tmp")
+	if err != nil {
+		Log.Debug("model::thumbnail::put err=%s", err.Error())
+		return thumbnail
+	}
+	if _, err = tmp.WriteString(contentType + "\n"); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return thumbnail
+	}
+	return &thumbnailWriter{
+		ReadCloser: thumbnail,
+		key:        key,
+		tmp:        tmp,
+		header:     int64(len(contentType) + 1),
+	}
+}
+
+// ThumbnailCachePurge empties the cache and returns how many thumbnails and bytes were removed
+func ThumbnailCachePurge() (int, int64, error) {
+	thumbnails.mu.Lock()
+	defer thumbnails.mu.Unlock()
+	thumbnails.init()
+
+	n, size := thumbnails.lru.Len(), thumbnails.size
+	thumbnails.lru.Init()
+	thumbnails.entries = map[string]*list.Element{}
+	thumbnails.size = 0
+	entries, err := os.ReadDir(GetAbsolutePath(THUMB_PATH))
+	if err != nil {
+		return n, size, err
+	}
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".tmp") {
+			continue // still being written, it will be discarded as it finishes
+		}
+		if err = os.RemoveAll(GetAbsolutePath(THUMB_PATH, e.Name())); err != nil {
+			return n, size, err
+		}
+	}
+	return n, size, nil
+}
+
+func thumbnailCacheMaxSize() int64 {
+	return int64(ThumbnailCacheSize()) * 1024 * 1024
+}
+
+func thumbnailCachePath(key string) string {
+	return GetAbsolutePath(THUMB_PATH, key[:2], key)
+}
+
+// init rebuilds the index from what is on disk, the least recently used going at the back
+func (this *thumbnailCache) init() {
+	this.once.Do(func() {
+		this.lru = list.New()
+		this.entries = map[string]*list.Element{}
+		type file struct {
+			key   string
+			size  int64
+			mtime time.Time
+		}
+		files := []file{}
+		root := GetAbsolutePath(THUMB_PATH)
+		filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return nil
+			} else if strings.HasSuffix(info.Name(), ".tmp") {
+				os.Remove(p)
+				return nil
+			}
+			files = append(files, file{info.Name(), info.Size(), info.ModTime()})
+			return nil
+		})
+		sort.Slice(files, func(i, j int) bool {
+			return files[i].mtime.After(files[j].mtime)
+		})
+		for _, f := range files {
+			this.entries[f.key] = this.lru.PushBack(&thumbnailEntry{f.key, f.size})
+			this.size += f.size
+		}
+		this.evict()
+	})
+}
+
+func (this *thumbnailCache) add(key string, size int64) {
+	if el, ok := this.entries[key]; ok {
+		this.size -= el.Value.(*thumbnailEntry).size
+		el.Value.(*thumbnailEntry).size = size
+		this.lru.MoveToFront(el)
+	} else {
+		this.entries[key] = this.lru.PushFront(&thumbnailEntry{key, size})
+	}
+	this.size += size
+	this.evict()
+}
+
+func (this *thumbnailCache) evict() {
+	max := thumbnailCacheMaxSize()
+	for this.size > max && this.lru.Len() > 0 {
+		this.remove(this.lru.Back())
+	}
+}
+
+func (this *thumbnailCache) remove(el *list.Element) {
+	entry := el.Value.(*thumbnailEntry)
+	this.lru.Remove(el)
+	delete(this.entries, entry.key)
+	this.size -= entry.size
+	os.Remove(thumbnailCachePath(entry.key))
+}
+
+type thumbnailReader struct {
+	io.Reader
+	io.Closer
+}
+
+type thumbnailWriter struct {
+	io.ReadCloser
+	key    string
+	tmp    *os.File
+	header int64
+	size   int64
+	eof    bool
+	failed bool
+}
+
+func (this *thumbnailWriter) Read(p []byte) (int, error) {
+	n, err := this.ReadCloser.Read(p)
+	if n > 0 && this.failed == false {
+		this.size += int64(n)
+		if this.size > THUMBNAIL_CACHE_MAX_ENTRY {
+			this.failed = true
+		} else if _, werr := this.tmp.Write(p[:n]); werr != nil {
+			this.failed = true
+		}
+	}
+	if err == io.EOF {
+		this.eof = true
+	} else if err != nil {
+		this.failed = true
+	}
+	return n, err
+}
+
+func (this *thumbnailWriter) Close() error {
+	err := this.ReadCloser.Close()
+	tmpPath := this.tmp.Name()
+	if cerr := this.tmp.Close(); cerr != nil || this.eof == false || this.failed {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	thumbnails.mu.Lock()
+	defer thumbnails.mu.Unlock()
+	thumbnails.init()
+	p := thumbnailCachePath(this.key)
+	if merr := os.MkdirAll(filepath.Dir(p), os.ModePerm); merr != nil {
+		os.Remove(tmpPath)
+		return err
+	} else if merr = os.Rename(tmpPath, p); merr != nil {
+		Log.Debug("model::thumbnail::close err=%s", merr.Error())
+		os.Remove(tmpPath)
+		return err
+	}
+	thumbnails.add(this.key, this.header+this.size)
+	return err
+}
@@ -0,0 +1,120 @@
+package model
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+const (
+	ACCESS_TOKEN_ID_LENGTH     = 16
+	ACCESS_TOKEN_SECRET_LENGTH = 40
+	ACCESS_TOKEN_MAX_DURATION  = 365 * 24 * time.Hour
+)
+
+// tokenLastUsed throttles the writes made to keep track of when a token was last used
+var tokenLastUsed = NewQuickCache(60, 120)
+
+// AccessTokenCreate stores a new token and returns its value. Only a hash of the secret part is
+// kept which is why the value can't be shown again later on
+func AccessTokenCreate(t *AccessToken) (string, error) {
+	t.Id = RandomString(ACCESS_TOKEN_ID_LENGTH)
+	t.CreatedAt = time.Now().UnixNano() / 1000000
+	secret := RandomString(ACCESS_TOKEN_SECRET_LENGTH)
+	stmt, err := DB.Prepare("INSERT INTO AccessToken(id, name, backend, auth, path, scope, secret, created_at, expire) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return "", err
+	}
+	defer stmt.Close()
+	if _, err = stmt.Exec(t.Id, t.Name, t.Backend, t.Auth, t.Path, t.Scope, hashTokenSecret(secret), t.CreatedAt, t.Expire); err != nil {
+		return "", err
+	}
+	return ACCESS_TOKEN_PREFIX + t.Id + "_" + secret, nil
+}
+
+func AccessTokenList(backend string) ([]AccessToken, error) {
+	rows, err := DB.Query(
+		"SELECT id, name, path, scope, created_at, expire, last_used FROM AccessToken WHERE backend = ? ORDER BY created_at DESC",
+		backend,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tokens := []AccessToken{}
+	for rows.Next() {
+		var (
+			t        AccessToken
+			lastUsed sql.NullInt64
+		)
+		if err = rows.Scan(&t.Id, &t.Name, &t.Path, &t.Scope, &t.CreatedAt, &t.Expire, &lastUsed); err != nil {
+			return nil, err
+		}
+		if lastUsed.Valid {
+			t.LastUsed = &lastUsed.Int64
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+func AccessTokenDelete(backend string, id string) error {
+	stmt, err := DB.Prepare("DELETE FROM AccessToken WHERE backend = ? AND id = ?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	r, err := stmt.Exec(backend, id)
+	if err != nil {
+		return err
+	} else if n, err := r.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	tokenLastUsed.Del(map[string]string{"id": id})
+	return nil
+}
+
+// AccessTokenVerify finds the token matching the value sent by a client
+func AccessTokenVerify(value string) (AccessToken, error) {
+	var t AccessToken
+	value = strings.TrimPrefix(value, ACCESS_TOKEN_PREFIX)
+	if len(value) != ACCESS_TOKEN_ID_LENGTH+1+ACCESS_TOKEN_SECRET_LENGTH || value[ACCESS_TOKEN_ID_LENGTH] != '_' {
+		return t, ErrNotAuthorized
+	}
+	id, secret := value[:ACCESS_TOKEN_ID_LENGTH], value[ACCESS_TOKEN_ID_LENGTH+1:]
+
+	var hash string
+	if err := DB.QueryRow(
+		"SELECT id, name, backend, auth, path, scope, secret, created_at, expire FROM AccessToken WHERE id = ?",
+		id,
+	).Scan(&t.Id, &t.Name, &t.Backend, &t.Auth, &t.Path, &t.Scope, &hash, &t.CreatedAt, &t.Expire); err == sql.ErrNoRows {
+		return AccessToken{}, ErrNotAuthorized
+	} else if err != nil {
+		return AccessToken{}, err
+	}
+	if hmac.Equal([]byte(hash), []byte(hashTokenSecret(secret))) == false {
+		return AccessToken{}, ErrNotAuthorized
+	} else if err := t.IsValid(); err != nil {
+		return AccessToken{}, err
+	}
+
+	if tokenLastUsed.Get(map[string]string{"id": t.Id}) == nil {
+		if stmt, err := DB.Prepare("UPDATE AccessToken SET last_used = ? WHERE id = ?"); err == nil {
+			stmt.Exec(time.Now().UnixNano()/1000000, t.Id)
+			stmt.Close()
+		}
+		tokenLastUsed.Set(map[string]string{"id": t.Id}, true)
+	}
+	return t, nil
+}
+
+func hashTokenSecret(secret string) string {
+	mac := hmac.New(sha256.New, []byte(SECRET_KEY_DERIVATE_FOR_HASH))
+	mac.Write([]byte(secret))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -3,12 +3,15 @@ package model
 /*
  * Implementation of a webdav.FileSystem: https://godoc.org/golang.org/x/net/webdav#FileSystem that is used
  * to generate our webdav server.
- * A lot of memoization is happening so that we don't DDOS the underlying storage which was important
- * considering most webdav client within OS are extremely greedy in HTTP request
+ * Metadata comes straight from what the backend gives us with Stat and Ls, content is streamed from Cat
+ * and to Save without going through the disk. Listings are memoized for a few seconds so that we don't
+ * DDOS the underlying storage which was important considering most webdav client within OS are
+ * extremely greedy in HTTP request
  */
 
 import (
 	"context"
+	"encoding/xml"
 	"fmt"
 	"io"
 	"net/http"
@@ -24,317 +27,607 @@ import (
 var webdav_cache AppCache
 
 func init() {
-	webdav_cache = NewQuickCache(20, 10)
-	webdav_cache.OnEvict(func(filename string, _ interface{}) {
-		os.Remove(filename)
-	})
+	webdav_cache = NewQuickCache(5, 10)
 }
 
 type WebdavFs struct {
-	req        *http.Request
-	backend    IBackend
-	path       string
-	id         string
-	chroot     string
-	webdavFile *WebdavFile
+	app     *App
+	req     *http.Request
+	backend IBackend
+	prefix  string
+	id      string
+	lockID  string
+	chroot  string
 }
 
-func NewWebdavFs(b IBackend, primaryKey string, chroot string, req *http.Request) *WebdavFs {
+func NewWebdavFs(app *App, prefix string, primaryKey string, chroot string, req *http.Request) *WebdavFs {
 	return &WebdavFs{
-		backend: b,
+		app:     app,
+		backend: app.Backend,
+		prefix:  prefix,
 		id:      primaryKey,
+		lockID:  GenerateStorageID(app.Session),
 		chroot:  chroot,
 		req:     req,
 	}
 }
 
-func (this WebdavFs) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+func (this *WebdavFs) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
 	if name = this.fullpath(name); name == "" {
 		return os.ErrNotExist
 	}
+	name = EnforceDirectory(name)
+	if err := webdavAuthorise(this.app, func(auth IAuthorisation) error {
+		return auth.Mkdir(this.app, name)
+	}); err != nil {
+		return err
+	}
+	defer this.invalidate(name)
 	return this.backend.Mkdir(name)
 }
 
 func (this *WebdavFs) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
-	cachePath := filepath.Join(GetAbsolutePath(TMP_PATH), "webdav_"+Hash(this.id+name, 20))
-	fwriteFile := func() *os.File {
-		if this.req.Method == "PUT" {
-			f, err := os.OpenFile(cachePath+"_writer", os.O_WRONLY|os.O_CREATE|os.O_EXCL, os.ModePerm)
-			if err != nil {
-				return nil
-			}
-			return f
-		}
-		return nil
-	}
-	if this.webdavFile != nil {
-		this.webdavFile.fwrite = fwriteFile()
-		return this.webdavFile, nil
-	}
 	if name = this.fullpath(name); name == "" {
 		return nil, os.ErrNotExist
+	} else if strings.HasPrefix(filepath.Base(name), ".") {
+		return nil, os.ErrNotExist
+	}
+	if flag&(os.O_CREATE|os.O_TRUNC) == 0 {
+		return &WebdavFile{fs: this, path: name}, nil
 	}
-	this.webdavFile = &WebdavFile{
-		path:    name,
-		backend: this.backend,
-		cache:   cachePath,
-		fwrite:  fwriteFile(),
+
+	if err := webdavAuthorise(this.app, func(auth IAuthorisation) error {
+		return auth.Save(this.app, name)
+	}); err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	file := &WebdavFile{
+		fs:     this,
+		path:   name,
+		writer: pw,
+		expect: -1,
+		saved:  make(chan error, 1),
+	}
+	if this.req.Method == "PUT" {
+		file.expect = this.req.ContentLength
 	}
-	return this.webdavFile, nil
+	go func() {
+		err := this.backend.Save(name, pr)
+		pr.CloseWithError(err)
+		file.saved <- err
+	}()
+	return file, nil
 }
 
-func (this WebdavFs) RemoveAll(ctx context.Context, name string) error {
+func (this *WebdavFs) RemoveAll(ctx context.Context, name string) error {
 	if name = this.fullpath(name); name == "" {
 		return os.ErrNotExist
+	} else if info, err := this.stat(name); err != nil {
+		return err
+	} else if info.IsDir() {
+		name = EnforceDirectory(name)
+	}
+	if err := webdavAuthorise(this.app, func(auth IAuthorisation) error {
+		return auth.Rm(this.app, name)
+	}); err != nil {
+		return err
 	}
+	defer this.invalidate(name)
 	return this.backend.Rm(name)
 }
 
-func (this WebdavFs) Rename(ctx context.Context, oldName, newName string) error {
+func (this *WebdavFs) Rename(ctx context.Context, oldName, newName string) error {
 	if oldName = this.fullpath(oldName); oldName == "" {
 		return os.ErrNotExist
 	} else if newName = this.fullpath(newName); newName == "" {
 		return os.ErrNotExist
+	} else if info, err := this.stat(oldName); err != nil {
+		return err
+	} else if info.IsDir() {
+		oldName = EnforceDirectory(oldName)
+		newName = EnforceDirectory(newName)
 	}
-	return this.backend.Mv(oldName, newName)
+	if err := webdavAuthorise(this.app, func(auth IAuthorisation) error {
+		return auth.Mv(this.app, oldName, newName)
+	}); err != nil {
+		return err
+	}
+	defer this.invalidate(oldName, newName)
+	if err := this.backend.Mv(oldName, newName); err != nil {
+		return err
+	} else if err = LockMove(this.lockID, oldName, newName); err != nil {
+		Log.Warning("model::webdav 'cannot move locks - %s'", err.Error())
+	}
+	return nil
 }
 
 func (this *WebdavFs) Stat(ctx context.Context, name string) (os.FileInfo, error) {
-	if this.webdavFile != nil {
-		this.webdavFile.push_to_remote_if_needed()
-		return this.webdavFile.Stat()
-	}
 	fullname := this.fullpath(name)
-	if isMicrosoftWebDAVClient(this.req) && this.req.Method == "PROPFIND" {
-		if name == "" {
-			fullname = this.chroot
-		}
-		fullname = EnforceDirectory(fullname)
-	}
 	if fullname == "" {
 		return nil, os.ErrNotExist
+	} else if err := webdavAuthorise(this.app, func(auth IAuthorisation) error {
+		return auth.Stat(this.app, fullname)
+	}); err != nil {
+		return nil, err
 	}
-	this.webdavFile = &WebdavFile{
-		path:    fullname,
-		backend: this.backend,
-		cache:   filepath.Join(GetAbsolutePath(TMP_PATH), "webdav_"+Hash(this.id+name, 20)),
+	return this.stat(fullname)
+}
+
+// stat asks the backend about a file, falling back on the listing of its parent for the backends
+// that can't tell us about a single file
+func (this *WebdavFs) stat(fullname string) (os.FileInfo, error) {
+	name := filepath.Base(fullname)
+	if fullname == this.chroot && IsDirectory(fullname) {
+		return webdavFileInfo{fs: this, path: fullname, name: name, dir: true}, nil
+	}
+	f, err := this.backend.Stat(fullname)
+	if err == nil && f != nil {
+		return this.newFileInfo(fullname, f), nil
+	} else if err == ErrNotFound || os.IsNotExist(err) {
+		return nil, os.ErrNotExist
 	}
-	return this.webdavFile.Stat()
+	files, err := this.ls(filepath.Dir(strings.TrimSuffix(fullname, "/")) + "/")
+	if err != nil {
+		return nil, os.ErrNotExist
+	}
+	for i := range files {
+		if files[i].Name() == name {
+			return this.newFileInfo(fullname, files[i]), nil
+		}
+	}
+	return nil, os.ErrNotExist
 }
 
-func (this WebdavFs) fullpath(path string) string {
-	p := filepath.Join(this.chroot, path)
-	if strings.HasSuffix(path, "/") == true && strings.HasSuffix(p, "/") == false {
-		p += "/"
+func (this *WebdavFs) ls(dir string) ([]os.FileInfo, error) {
+	key := map[string]string{"id": this.id, "path": dir}
+	if files, ok := webdav_cache.Get(key).([]os.FileInfo); ok {
+		return files, nil
+	}
+	files, err := this.backend.Ls(dir)
+	if err != nil {
+		return nil, err
 	}
-	if strings.HasPrefix(p, this.chroot) == false {
+	webdav_cache.Set(key, files)
+	return files, nil
+}
+
+// invalidate forgets about the listings a change to those paths has made stale
+func (this *WebdavFs) invalidate(paths ...string) {
+	for _, p := range paths {
+		webdav_cache.Del(map[string]string{"id": this.id, "path": filepath.Dir(strings.TrimSuffix(p, "/")) + "/"})
+		webdav_cache.Del(map[string]string{"id": this.id, "path": EnforceDirectory(p)})
+	}
+}
+
+func (this *WebdavFs) fullpath(path string) string {
+	p := filepath.Join(this.chroot, path)
+	if p == filepath.Clean(this.chroot) {
+		return this.chroot
+	} else if strings.HasPrefix(p, EnforceDirectory(filepath.Clean(this.chroot))) == false {
 		return ""
 	}
 	return p
 }
 
 /*
- * Implement a webdav.File and os.Stat : https://godoc.org/golang.org/x/net/webdav#File
+ * Implement a webdav.File: https://godoc.org/golang.org/x/net/webdav#File
+ * A file is either read from Cat or written to Save, never both. Reads are seekable, either because
+ * the backend gives us a reader that can seek or by skipping what's before the requested offset,
+ * starting over from the beginning when going backward
  */
 type WebdavFile struct {
+	fs      *WebdavFs
 	path    string
-	backend IBackend
-	cache   string
-	fread   *os.File
-	fwrite  *os.File
-	files   []os.FileInfo
+	info    os.FileInfo
+	reader  io.ReadCloser
+	pos     int64 // where the next read should start
+	offset  int64 // where the reader currently is
+	writer  *io.PipeWriter
+	written int64
+	expect  int64 // what the client told us it would send, -1 when it doesn't say
+	saved   chan error
 }
 
-func (this *WebdavFile) Read(p []byte) (n int, err error) {
-	if strings.HasPrefix(filepath.Base(this.path), ".") {
-		return 0, os.ErrNotExist
+func (this *WebdavFile) Read(p []byte) (int, error) {
+	if this.writer != nil {
+		return 0, os.ErrInvalid
+	} else if err := this.open(); err != nil {
+		return 0, err
 	}
-	if this.fread == nil {
-		if this.fread = this.pull_remote_file(); this.fread == nil {
-			return -1, os.ErrInvalid
+	n, err := this.reader.Read(p)
+	this.pos += int64(n)
+	this.offset = this.pos
+	return n, err
+}
+
+func (this *WebdavFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += this.pos
+	case io.SeekEnd:
+		info, err := this.Stat()
+		if err != nil {
+			return 0, err
 		}
+		offset += info.Size()
+	}
+	if offset < 0 {
+		return 0, os.ErrInvalid
 	}
-	return this.fread.Read(p)
+	this.pos = offset
+	return offset, nil
 }
 
-func (this *WebdavFile) Close() error {
-	if this.fread != nil {
-		if this.fread.Close() == nil {
-			this.fread = nil
+// open makes sure the reader is where the next read should start
+func (this *WebdavFile) open() error {
+	if this.reader != nil && this.offset == this.pos {
+		return nil
+	}
+	if this.reader != nil {
+		if s, ok := this.reader.(io.Seeker); ok {
+			if _, err := s.Seek(this.pos, io.SeekStart); err != nil {
+				return err
+			}
+			this.offset = this.pos
+			return nil
+		} else if this.pos < this.offset {
+			this.reader.Close()
+			this.reader = nil
 		}
 	}
-	if this.fwrite != nil {
-		if err := this.push_to_remote_if_needed(); err == nil {
-			if this.fwrite.Close() == nil {
-				this.fwrite = nil
+	if this.reader == nil {
+		if err := webdavAuthorise(this.fs.app, func(auth IAuthorisation) error {
+			return auth.Cat(this.fs.app, this.path)
+		}); err != nil {
+			return err
+		}
+		r, err := this.fs.backend.Cat(this.path)
+		if err != nil {
+			return err
+		}
+		this.reader = r
+		this.offset = 0
+		if s, ok := r.(io.Seeker); ok && this.pos > 0 {
+			if _, err = s.Seek(this.pos, io.SeekStart); err != nil {
+				return err
 			}
+			this.offset = this.pos
+		}
+	}
+	if this.pos > this.offset {
+		n, err := io.CopyN(io.Discard, this.reader, this.pos-this.offset)
+		this.offset += n
+		if err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
-func (this *WebdavFile) Seek(offset int64, whence int) (int64, error) {
-	if this.fread == nil {
-		this.fread = this.pull_remote_file()
-		if this.fread == nil {
-			return offset, ErrNotFound
-		}
+func (this *WebdavFile) Write(p []byte) (int, error) {
+	if this.writer == nil {
+		return 0, os.ErrInvalid
 	}
-	a, err := this.fread.Seek(offset, whence)
-	if err != nil {
-		return a, ErrNotFound
+	n, err := this.writer.Write(p)
+	this.written += int64(n)
+	return n, err
+}
+
+// Close finishes off what was sent to the backend. An upload that didn't make it in full ends with an
+// error on the reader given to Save so the backend doesn't mistake it for a complete file
+func (this *WebdavFile) Close() error {
+	if this.reader != nil {
+		err := this.reader.Close()
+		this.reader = nil
+		return err
+	}
+	if this.writer == nil {
+		return nil
 	}
-	return a, nil
+	if err := this.fs.req.Context().Err(); err != nil {
+		this.writer.CloseWithError(err)
+	} else if this.expect >= 0 && this.expect != this.written {
+		this.writer.CloseWithError(io.ErrUnexpectedEOF)
+	} else {
+		this.writer.Close()
+	}
+	err := <-this.saved
+	this.writer = nil
+	this.fs.invalidate(this.path)
+	return err
 }
 
 func (this *WebdavFile) Readdir(count int) ([]os.FileInfo, error) {
-	if this.files != nil {
-		return this.files, nil
-	}
+	dir := EnforceDirectory(this.path)
 	if strings.HasPrefix(filepath.Base(this.path), ".") {
 		return nil, os.ErrNotExist
+	} else if err := webdavAuthorise(this.fs.app, func(auth IAuthorisation) error {
+		return auth.Ls(this.fs.app, dir)
+	}); err != nil {
+		return nil, err
 	}
-	f, err := this.backend.Ls(this.path)
-	this.files = f
-	return f, err
-}
-
-func (this *WebdavFile) Stat() (os.FileInfo, error) {
-	this.push_to_remote_if_needed()
-	if strings.HasSuffix(this.path, "/") {
-		_, err := this.Readdir(0)
-		if err != nil {
-			return nil, os.ErrNotExist
-		}
-		return this, nil
+	files, err := this.fs.ls(dir)
+	if err != nil {
+		return nil, err
 	}
-	baseDir := filepath.Base(this.path)
-	files, err := this.backend.Ls(strings.TrimSuffix(this.path, baseDir))
+	locks, err := LockFindUnder(this.fs.lockID, dir)
 	if err != nil {
-		return nil, os.ErrNotExist
+		return nil, err
 	}
-	found := false
+	out := make([]os.FileInfo, len(files))
 	for i := range files {
-		if files[i].Name() == baseDir {
-			found = true
-			break
+		info := this.fs.newFileInfo(dir+files[i].Name(), files[i])
+		info.locks = []Lock{}
+		for _, l := range locks {
+			if LockCovers(l, info.path) {
+				info.locks = append(info.locks, l)
+			}
 		}
+		out[i] = info
 	}
-	if found == false {
-		return nil, os.ErrNotExist
-	}
-	return this, nil
+	return out, nil
 }
 
-func (this *WebdavFile) Write(p []byte) (int, error) {
-	if this.fwrite == nil {
-		return 0, os.ErrNotExist
-	}
-	if strings.HasPrefix(filepath.Base(this.path), ".") {
-		return 0, os.ErrNotExist
+func (this *WebdavFile) Stat() (os.FileInfo, error) {
+	if this.writer != nil {
+		return webdavFileInfo{
+			fs:      this.fs,
+			path:    this.path,
+			name:    filepath.Base(this.path),
+			size:    this.written,
+			modTime: time.Now(),
+		}, nil
+	} else if this.info == nil {
+		info, err := this.fs.stat(this.path)
+		if err != nil {
+			return nil, err
+		}
+		this.info = info
 	}
-	return this.fwrite.Write(p)
+	return this.info, nil
 }
 
-func (this WebdavFile) pull_remote_file() *os.File {
-	filename := this.cache + "_reader"
-	if f, err := os.OpenFile(filename, os.O_RDONLY, os.ModePerm); err == nil {
-		return f
-	}
-	if f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, os.ModePerm); err == nil {
-		if reader, err := this.backend.Cat(this.path); err == nil {
-			io.Copy(f, reader)
-			f.Close()
-			webdav_cache.SetKey(this.cache+"_reader", nil)
-			reader.Close()
-			if f, err = os.OpenFile(filename, os.O_RDONLY, os.ModePerm); err == nil {
-				return f
-			}
-			return nil
-		}
-		f.Close()
-	}
-	return nil
+/*
+ * Implement os.FileInfo with what the backend told us. As we don't set an ETag ourselves, it is made
+ * of the modification time and size of the file. The locks are given as the lockdiscovery property
+ */
+type webdavFileInfo struct {
+	fs      *WebdavFs
+	path    string
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+	dir     bool
+	locks   []Lock // nil until we've looked for them
 }
 
-func (this *WebdavFile) push_to_remote_if_needed() error {
-	if this.fwrite == nil {
-		return nil
+func (this *WebdavFs) newFileInfo(fullname string, f os.FileInfo) webdavFileInfo {
+	return webdavFileInfo{
+		fs:      this,
+		path:    fullname,
+		name:    filepath.Base(fullname),
+		size:    f.Size(),
+		mode:    f.Mode(),
+		modTime: f.ModTime(),
+		dir:     f.IsDir(),
 	}
-	this.fwrite.Close()
-	f, err := os.OpenFile(this.cache+"_writer", os.O_RDONLY, os.ModePerm)
-	if err != nil {
-		return err
+}
+
+func (this webdavFileInfo) Name() string {
+	return this.name
+}
+
+func (this webdavFileInfo) Size() int64 {
+	if this.dir {
+		return 0
 	}
-	err = this.backend.Save(this.path, f)
-	if err == nil {
-		if err = os.Rename(this.cache+"_writer", this.cache+"_reader"); err == nil {
-			this.fwrite = nil
-			webdav_cache.SetKey(this.cache+"_reader", nil)
+	return this.size
+}
+
+func (this webdavFileInfo) Mode() os.FileMode {
+	mode := this.mode.Perm()
+	if mode == 0 {
+		mode = 0644
+		if this.dir {
+			mode = 0755
 		}
 	}
-	f.Close()
-	return err
+	if this.dir {
+		return mode | os.ModeDir
+	}
+	return mode
+}
+
+func (this webdavFileInfo) ModTime() time.Time {
+	return this.modTime
 }
 
-func (this WebdavFile) Name() string {
-	return filepath.Base(this.path)
+func (this webdavFileInfo) IsDir() bool {
+	return this.dir
 }
 
-func (this *WebdavFile) Size() int64 {
-	if this.fread == nil {
-		if this.fread = this.pull_remote_file(); this.fread == nil {
-			return 0
+func (this webdavFileInfo) Sys() interface{} {
+	return nil
+}
+
+func (this webdavFileInfo) ContentType(ctx context.Context) (string, error) {
+	return GetMimeType(this.name), nil
+}
+
+func (this webdavFileInfo) DeadProps() (map[xml.Name]webdav.Property, error) {
+	locks := this.locks
+	if locks == nil {
+		var err error
+		if locks, err = LockFind(this.fs.lockID, this.path); err != nil {
+			return nil, err
 		}
 	}
-	if info, err := this.fread.Stat(); err == nil {
-		return info.Size()
-	}
-	return 0
+	var activelocks strings.Builder
+	for _, l := range locks {
+		depth := "0"
+		if l.Infinite {
+			depth = "infinity"
+		}
+		owner := l.Owner
+		if l.Source != "webdav" {
+			owner = webdavEscape(owner)
+		}
+		fmt.Fprintf(
+			&activelocks,
+			`<D:activelock xmlns:D="DAV:"><D:locktype><D:write/></D:locktype><D:lockscope><D:exclusive/></D:lockscope>`+
+				`<D:depth>%s</D:depth><D:owner>%s</D:owner><D:timeout>Second-%d</D:timeout>`+
+				`<D:lockroot><D:href>%s</D:href></D:lockroot></D:activelock>`,
+			depth, owner, int64(time.Until(l.ExpireAt).Seconds()), webdavEscape(this.fs.href(l.Path)),
+		)
+	}
+	name := xml.Name{Space: "DAV:", Local: "lockdiscovery"}
+	return map[xml.Name]webdav.Property{
+		name: {XMLName: name, InnerXML: []byte(activelocks.String())},
+	}, nil
 }
 
-func (this WebdavFile) Mode() os.FileMode {
-	return 0
+// Patch is never called on a file info, dead properties being set through the file itself
+func (this webdavFileInfo) Patch(patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	return nil, ErrNotImplemented
 }
 
-func (this WebdavFile) ModTime() time.Time {
-	return time.Now()
-}
-func (this WebdavFile) IsDir() bool {
-	if strings.HasSuffix(this.path, "/") {
-		return true
+// href gives the url a client knows a path by
+func (this *WebdavFs) href(fullname string) string {
+	rel := strings.TrimPrefix(lockPath(fullname)+"/", EnforceDirectory(this.chroot))
+	if IsDirectory(fullname) == false {
+		rel = strings.TrimSuffix(rel, "/")
 	}
-	return false
+	return strings.TrimSuffix(this.prefix, "/") + "/" + rel
 }
 
-func (this WebdavFile) Sys() interface{} {
-	return nil
+/*
+ * Implement a webdav.LockSystem: https://godoc.org/golang.org/x/net/webdav#LockSystem on top of the
+ * locks shared with the rest of the application.
+ * Clients that don't care about locks still get a temporary lock for the duration of each request
+ * that changes something. Those aren't worth persisting, we only make sure nobody else holds a lock
+ * on what they touch. The lock token isn't part of the lockdiscovery property as it is what proves
+ * a client holds the lock
+ */
+const WEBDAV_TEMPORARY_TOKEN = "filestash-temporary:"
+
+type webdavLockSystem struct {
+	fs *WebdavFs
 }
 
-func (this WebdavFile) ETag(ctx context.Context) (string, error) {
-	// Building an etag can be an expensive call if the data isn't available locally.
-	// => 2 etags strategies:
-	// - use a legit etag value when the data is already in our cache
-	// - use a dummy value that's changing all the time when we don't have much info
+func NewWebdavLock(fs *WebdavFs) webdav.LockSystem {
+	return &webdavLockSystem{fs}
+}
 
-	etag := Hash(fmt.Sprintf("%d%s", this.ModTime().UnixNano(), this.path), 20)
-	if this.fread != nil {
-		if s, err := this.fread.Stat(); err == nil {
-			etag = Hash(fmt.Sprintf(`"%x%x"`, this.path, s.Size()), 20)
+func (this *webdavLockSystem) Confirm(now time.Time, name0, name1 string, conditions ...webdav.Condition) (func(), error) {
+	tokens := []string{}
+	for _, c := range conditions {
+		if c.Not == false && c.Token != "" {
+			tokens = append(tokens, c.Token)
+		}
+	}
+	for _, name := range []string{name0, name1} {
+		if name == "" {
+			continue
+		}
+		fullname := this.fs.fullpath(name)
+		if fullname == "" {
+			return nil, webdav.ErrConfirmationFailed
+		}
+		locks, err := LockFind(this.fs.lockID, fullname)
+		if err != nil {
+			return nil, err
 		}
+		// a name nobody has locked, like the destination of a move, has nothing to confirm
+		held := len(locks) == 0
+		for _, l := range locks {
+			for _, token := range tokens {
+				held = held || token == l.Token
+			}
+		}
+		if held == false {
+			return nil, webdav.ErrConfirmationFailed
+		}
+		// what's under a folder might be locked by someone else
+		if err = LockCheck(this.fs.lockID, EnforceDirectory(fullname), tokens...); err == ErrLocked {
+			return nil, webdav.ErrLocked
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return func() {}, nil
+}
+
+func (this *webdavLockSystem) Create(now time.Time, details webdav.LockDetails) (string, error) {
+	fullname := this.fs.fullpath(details.Root)
+	if fullname == "" {
+		return "", os.ErrNotExist
 	}
-	return etag, nil
+	if details.Duration < 0 && details.ZeroDepth && details.OwnerXML == "" {
+		if err := LockCheck(this.fs.lockID, EnforceDirectory(fullname)); err == ErrLocked {
+			return "", webdav.ErrLocked
+		} else if err != nil {
+			return "", err
+		}
+		return WEBDAV_TEMPORARY_TOKEN + RandomString(16), nil
+	}
+	l, err := LockCreate(Lock{
+		Backend:  this.fs.lockID,
+		Path:     fullname,
+		Token:    "opaquelocktoken:" + RandomString(32),
+		Owner:    details.OwnerXML,
+		Source:   "webdav",
+		Infinite: details.ZeroDepth == false,
+	}, details.Duration)
+	if err == ErrLocked {
+		return "", webdav.ErrLocked
+	} else if err != nil {
+		return "", err
+	}
+	return l.Token, nil
 }
 
-var lock webdav.LockSystem
+func (this *webdavLockSystem) Refresh(now time.Time, token string, duration time.Duration) (webdav.LockDetails, error) {
+	l, err := LockRefresh(this.fs.lockID, token, duration)
+	if err == ErrNotFound {
+		return webdav.LockDetails{}, webdav.ErrNoSuchLock
+	} else if err != nil {
+		return webdav.LockDetails{}, err
+	}
+	return webdav.LockDetails{
+		Root:      this.fs.href(l.Path),
+		Duration:  time.Until(l.ExpireAt).Round(time.Second),
+		OwnerXML:  l.Owner,
+		ZeroDepth: l.Infinite == false,
+	}, nil
+}
 
-func NewWebdavLock() webdav.LockSystem {
-	if lock == nil {
-		lock = webdav.NewMemLS()
+func (this *webdavLockSystem) Unlock(now time.Time, token string) error {
+	if strings.HasPrefix(token, WEBDAV_TEMPORARY_TOKEN) {
+		return nil
+	}
+	if err := LockRelease(this.fs.lockID, token); err == ErrNotFound {
+		return webdav.ErrNoSuchLock
+	} else if err != nil {
+		return err
 	}
-	return lock
+	return nil
 }
 
-func isMicrosoftWebDAVClient(req *http.Request) bool {
-	return strings.HasPrefix(req.Header.Get("User-Agent"), "Microsoft-WebDAV-MiniRedir/")
+func webdavEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// webdavAuthorise gives the authorisation plugins a say on what a webdav client is trying to do
+func webdavAuthorise(app *App, check func(auth IAuthorisation) error) error {
+	for _, auth := range Hooks.Get.AuthorisationMiddleware() {
+		if err := check(auth); err != nil {
+			return os.ErrPermission
+		}
+	}
+	return nil
 }
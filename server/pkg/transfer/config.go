@@ -0,0 +1,40 @@
+package transfer
+
+import (
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+func init() {
+	Hooks.Register.Onload(func() {
+		PluginEnable()
+		PluginNumberWorker()
+	})
+}
+
+var PluginEnable = func() bool {
+	return Config.Get("features.transfer.enable").Schema(func(f *FormElement) *FormElement {
+		if f == nil {
+			f = &FormElement{}
+		}
+		f.Name = "enable"
+		f.Type = "enable"
+		f.Target = []string{"transfer_workers"}
+		f.Description = "Enable/Disable background transfers between storage backends"
+		f.Default = true
+		return f
+	}).Bool()
+}
+
+var PluginNumberWorker = func() int {
+	return Config.Get("features.transfer.workers").Schema(func(f *FormElement) *FormElement {
+		if f == nil {
+			f = &FormElement{}
+		}
+		f.Id = "transfer_workers"
+		f.Name = "workers"
+		f.Type = "number"
+		f.Description = "Number of transfers running in parallel. Default: 2"
+		f.Default = 2
+		return f
+	}).Int()
+}
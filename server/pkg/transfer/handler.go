@@ -0,0 +1,176 @@
+package transfer
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+	"github.com/mickael-kerjean/filestash/server/ctrl"
+	"github.com/mickael-kerjean/filestash/server/model"
+	. "github.com/mickael-kerjean/filestash/server/pkg/workflow/model"
+
+	"github.com/gorilla/mux"
+)
+
+type transferRequest struct {
+	From transferRequestTarget `json:"from"`
+	To   transferRequestTarget `json:"to"`
+}
+
+type transferRequestTarget struct {
+	Token string `json:"token"` // session to use, default to the current one
+	Path  string `json:"path"`
+}
+
+func List(ctx *App, res http.ResponseWriter, req *http.Request) {
+	transfers, err := ListTransfers(GenerateID(ctx.Session))
+	if err != nil {
+		SendErrorResult(res, err)
+		return
+	}
+	SendSuccessResults(res, transfers)
+}
+
+func Create(ctx *App, res http.ResponseWriter, req *http.Request) {
+	if PluginEnable() == false {
+		SendErrorResult(res, ErrNotAllowed)
+		return
+	} else if ctx.Share.Id != "" || ctx.Token.Id != "" || ctx.Authorization == "" {
+		SendErrorResult(res, ErrPermissionDenied)
+		return
+	}
+	var body transferRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		SendErrorResult(res, ErrNotValid)
+		return
+	}
+	srcApp, src, err := resolveTarget(ctx, body.From)
+	if err != nil {
+		Log.Debug("[transfer] action=create step=source err=%s", err.Error())
+		SendErrorResult(res, err)
+		return
+	}
+	dstApp, dst, err := resolveTarget(ctx, body.To)
+	if err != nil {
+		Log.Debug("[transfer] action=create step=destination err=%s", err.Error())
+		SendErrorResult(res, err)
+		return
+	}
+	if IsDirectory(src.Path) && IsDirectory(dst.Path) == false {
+		SendErrorResult(res, NewError("Destination of a folder must be a folder", 400))
+		return
+	} else if IsDirectory(src.Path) == false && IsDirectory(dst.Path) {
+		dst.Path += filepath.Base(src.Path)
+	}
+	if src.Token == dst.Token && IsDirectory(src.Path) && strings.HasPrefix(dst.Path, src.Path) {
+		SendErrorResult(res, NewError("Cannot transfer a folder into itself", 400))
+		return
+	}
+
+	from, err := ctrl.PathBuilder(srcApp, src.Path)
+	if err != nil {
+		SendErrorResult(res, err)
+		return
+	}
+	to, err := ctrl.PathBuilder(dstApp, dst.Path)
+	if err != nil {
+		SendErrorResult(res, err)
+		return
+	}
+	for _, auth := range Hooks.Get.AuthorisationMiddleware() {
+		if IsDirectory(from) {
+			err = auth.Ls(srcApp, from)
+		} else {
+			err = auth.Cat(srcApp, from)
+		}
+		if err == nil {
+			err = auth.Save(dstApp, to)
+		}
+		if err != nil {
+			Log.Info("[transfer] action=create step=auth err=%s", err.Error())
+			SendErrorResult(res, ErrNotAuthorized)
+			return
+		}
+	}
+
+	transfer, err := CreateTransfer(GenerateID(ctx.Session), src, dst)
+	if err != nil {
+		SendErrorResult(res, err)
+		return
+	}
+	notify()
+	SendSuccessResult(res, transfer)
+}
+
+func Status(ctx *App, res http.ResponseWriter, req *http.Request) {
+	transfer, err := ownTransfer(ctx, req)
+	if err != nil {
+		SendErrorResult(res, err)
+		return
+	}
+	SendSuccessResult(res, transfer)
+}
+
+func Cancel(ctx *App, res http.ResponseWriter, req *http.Request) {
+	transfer, err := ownTransfer(ctx, req)
+	if err != nil {
+		SendErrorResult(res, err)
+		return
+	} else if err = CancelTransfer(transfer.ID); err != nil {
+		SendErrorResult(res, err)
+		return
+	}
+	abort(transfer.ID)
+	SendSuccessResult(res, nil)
+}
+
+func Resume(ctx *App, res http.ResponseWriter, req *http.Request) {
+	transfer, err := ownTransfer(ctx, req)
+	if err != nil {
+		SendErrorResult(res, err)
+		return
+	} else if err = ResumeTransfer(transfer.ID); err != nil {
+		SendErrorResult(res, err)
+		return
+	}
+	notify()
+	SendSuccessResult(res, nil)
+}
+
+func ownTransfer(ctx *App, req *http.Request) (Transfer, error) {
+	id, err := strconv.Atoi(mux.Vars(req)["transferID"])
+	if err != nil {
+		return Transfer{}, ErrNotValid
+	}
+	transfer, err := GetTransfer(id)
+	if err != nil {
+		return Transfer{}, err
+	} else if transfer.Owner != GenerateID(ctx.Session) {
+		return Transfer{}, ErrNotFound
+	}
+	return transfer, nil
+}
+
+func resolveTarget(ctx *App, target transferRequestTarget) (*App, TransferTarget, error) {
+	var (
+		app = ctx
+		err error
+	)
+	if target.Token == "" {
+		target.Token = ctx.Authorization
+	} else if app, err = model.NewAppFromToken(ctx.Context, target.Token); err != nil {
+		return nil, TransferTarget{}, err
+	}
+	path, err := ctrl.PathBuilder(app, target.Path)
+	if err != nil {
+		return nil, TransferTarget{}, err
+	}
+	return app, TransferTarget{
+		Token:   target.Token,
+		Backend: app.Session["type"],
+		Path:    "/" + strings.TrimLeft(strings.TrimPrefix(path, app.Session["path"]), "/"),
+	}, nil
+}
@@ -0,0 +1,62 @@
+package transfer
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+	. "github.com/mickael-kerjean/filestash/server/pkg/workflow/model"
+)
+
+var (
+	transfer_event   = make(chan interface{}, 100)
+	transfer_running sync.Map // map[int]context.CancelFunc
+)
+
+func Init() error {
+	if PluginEnable() == false {
+		Log.Debug("[transfer] state=disabled")
+		return nil
+	}
+	Log.Debug("[transfer] state=enabled worker=%d", PluginNumberWorker())
+
+	for i := 0; i < PluginNumberWorker(); i++ {
+		go func(i int) {
+			time.Sleep(time.Duration((i+1)*100) * time.Millisecond)
+			for {
+				t, err := NextTransfer()
+				if err == ErrNotFound {
+					select {
+					case <-transfer_event:
+					case <-time.After(60 * time.Second):
+					}
+					continue
+				} else if err != nil {
+					Log.Error("[transfer] type=worker err=%s", err.Error())
+					time.Sleep(10 * time.Second)
+					continue
+				}
+				ctx, cancel := context.WithCancel(context.Background())
+				transfer_running.Store(t.ID, cancel)
+				ExecuteTransfer(ctx, t)
+				transfer_running.Delete(t.ID)
+				cancel()
+			}
+		}(i)
+	}
+	return nil
+}
+
+func notify() {
+	select {
+	case transfer_event <- nil:
+	default:
+	}
+}
+
+func abort(id int) {
+	if cancel, ok := transfer_running.Load(id); ok {
+		cancel.(context.CancelFunc)()
+	}
+}
@@ -0,0 +1,236 @@
+package transfer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+	"github.com/mickael-kerjean/filestash/server/ctrl"
+	"github.com/mickael-kerjean/filestash/server/model"
+	. "github.com/mickael-kerjean/filestash/server/pkg/workflow/model"
+)
+
+type transferJob struct {
+	ctx      context.Context
+	id       int
+	src      *App
+	dst      *App
+	mu       sync.Mutex
+	progress TransferProgress
+}
+
+// ExecuteTransfer copies everything from the source to the destination. A transfer that
+// gets resumed starts again from the top but skips the files that are already present on the
+// destination with the expected size
+func ExecuteTransfer(ctx context.Context, t Transfer) {
+	Log.Debug("[transfer] action=execute id=%d from=%s:%s to=%s:%s", t.ID, t.Source.Backend, t.Source.Path, t.Destination.Backend, t.Destination.Path)
+	job := &transferJob{ctx: ctx, id: t.ID}
+	var err error
+	if job.src, err = model.NewAppFromToken(ctx, t.Source.Token); err != nil {
+		Log.Debug("[transfer] action=execute step=source id=%d err=%s", t.ID, err.Error())
+		UpdateTransfer(t.ID, "FAILURE", t.Progress, "source: "+err.Error())
+		return
+	} else if job.dst, err = model.NewAppFromToken(ctx, t.Destination.Token); err != nil {
+		Log.Debug("[transfer] action=execute step=destination id=%d err=%s", t.ID, err.Error())
+		UpdateTransfer(t.ID, "FAILURE", t.Progress, "destination: "+err.Error())
+		return
+	}
+	from, err := ctrl.PathBuilder(job.src, t.Source.Path)
+	if err != nil {
+		UpdateTransfer(t.ID, "FAILURE", t.Progress, "source: "+err.Error())
+		return
+	}
+	to, err := ctrl.PathBuilder(job.dst, t.Destination.Path)
+	if err != nil {
+		UpdateTransfer(t.ID, "FAILURE", t.Progress, "destination: "+err.Error())
+		return
+	}
+	UpdateTransfer(t.ID, "RUNNING", job.snapshot(), "")
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				UpdateTransfer(t.ID, "RUNNING", job.snapshot(), "")
+			}
+		}
+	}()
+	if err = job.scan(from); err == nil {
+		err = job.copy(from, to, nil)
+	}
+	close(done)
+
+	if ctx.Err() != nil {
+		Log.Debug("[transfer] action=execute step=cancelled id=%d", t.ID)
+		return
+	} else if err != nil {
+		Log.Debug("[transfer] action=execute step=copy id=%d err=%s", t.ID, err.Error())
+		UpdateTransfer(t.ID, "FAILURE", job.snapshot(), err.Error())
+		return
+	}
+	progress := job.snapshot()
+	progress.Current = ""
+	UpdateTransfer(t.ID, "SUCCESS", progress, "")
+}
+
+func (this *transferJob) scan(path string) error {
+	if this.ctx.Err() != nil {
+		return this.ctx.Err()
+	}
+	if IsDirectory(path) == false {
+		var size int64
+		if f, err := this.src.Backend.Stat(path); err == nil {
+			size = f.Size()
+		}
+		this.mu.Lock()
+		this.progress.FilesTotal += 1
+		this.progress.BytesTotal += size
+		this.mu.Unlock()
+		return nil
+	}
+	entries, err := this.src.Backend.Ls(path)
+	if err != nil {
+		return fmt.Errorf("ls %s: %s", path, err.Error())
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			if err = this.scan(path + entry.Name() + "/"); err != nil {
+				return err
+			}
+			continue
+		}
+		this.mu.Lock()
+		this.progress.FilesTotal += 1
+		this.progress.BytesTotal += entry.Size()
+		this.mu.Unlock()
+	}
+	return nil
+}
+
+func (this *transferJob) copy(from string, to string, existing os.FileInfo) error {
+	if this.ctx.Err() != nil {
+		return this.ctx.Err()
+	}
+	if IsDirectory(from) == false {
+		return this.copyFile(from, to, existing)
+	}
+	if err := this.authorise(func(auth IAuthorisation) error {
+		if err := auth.Ls(this.src, from); err != nil {
+			return err
+		}
+		return auth.Mkdir(this.dst, to)
+	}); err != nil {
+		return err
+	}
+	if err := this.dst.Backend.Mkdir(to); err != nil {
+		Log.Debug("[transfer] action=mkdir id=%d path=%s err=%s", this.id, to, err.Error())
+	}
+	entries, err := this.src.Backend.Ls(from)
+	if err != nil {
+		return fmt.Errorf("ls %s: %s", from, err.Error())
+	}
+	present := map[string]os.FileInfo{}
+	if files, err := this.dst.Backend.Ls(to); err == nil {
+		for _, f := range files {
+			present[f.Name()] = f
+		}
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() {
+			name += "/"
+		}
+		if err = this.copy(from+name, to+name, present[entry.Name()]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (this *transferJob) copyFile(from string, to string, existing os.FileInfo) error {
+	if existing == nil {
+		if f, err := this.dst.Backend.Stat(to); err == nil {
+			existing = f
+		}
+	}
+	var size int64 = -1
+	if f, err := this.src.Backend.Stat(from); err == nil {
+		size = f.Size()
+	}
+	if existing != nil && existing.IsDir() == false && existing.Size() == size {
+		this.mu.Lock()
+		this.progress.FilesDone += 1
+		this.progress.BytesDone += size
+		this.mu.Unlock()
+		return nil
+	}
+	if err := this.authorise(func(auth IAuthorisation) error {
+		if err := auth.Cat(this.src, from); err != nil {
+			return err
+		}
+		return auth.Save(this.dst, to)
+	}); err != nil {
+		return err
+	}
+
+	this.mu.Lock()
+	this.progress.Current = "/" + strings.TrimLeft(strings.TrimPrefix(from, this.src.Session["path"]), "/")
+	this.mu.Unlock()
+	file, err := this.src.Backend.Cat(from)
+	if err != nil {
+		return fmt.Errorf("cat %s: %s", from, err.Error())
+	}
+	defer file.Close()
+	if err = this.dst.Backend.Save(to, &progressReader{file, this}); err != nil {
+		if this.ctx.Err() != nil {
+			return this.ctx.Err()
+		}
+		return fmt.Errorf("save %s: %s", to, err.Error())
+	}
+	this.mu.Lock()
+	this.progress.FilesDone += 1
+	this.mu.Unlock()
+	return nil
+}
+
+func (this *transferJob) authorise(fn func(auth IAuthorisation) error) error {
+	for _, auth := range Hooks.Get.AuthorisationMiddleware() {
+		if err := fn(auth); err != nil {
+			Log.Info("[transfer] action=authorise id=%d err=%s", this.id, err.Error())
+			return ErrNotAuthorized
+		}
+	}
+	return nil
+}
+
+func (this *transferJob) snapshot() TransferProgress {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.progress
+}
+
+type progressReader struct {
+	reader io.Reader
+	job    *transferJob
+}
+
+func (this *progressReader) Read(p []byte) (int, error) {
+	if err := this.job.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := this.reader.Read(p)
+	this.job.mu.Lock()
+	this.job.progress.BytesDone += int64(n)
+	this.job.mu.Unlock()
+	return n, err
+}
@@ -0,0 +1,66 @@
+package actions
+
+import (
+	"path/filepath"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+	"github.com/mickael-kerjean/filestash/server/model"
+)
+
+func init() {
+	Hooks.Register.WorkflowAction(&FilesCopy{})
+}
+
+type FilesCopy struct{}
+
+func (this *FilesCopy) Manifest() WorkflowSpecs {
+	return WorkflowSpecs{
+		Name:  "files/copy",
+		Title: "Copy",
+		Icon:  fileIcon,
+		Specs: Form{
+			Elmnts: []FormElement{
+				{
+					Name: "token",
+					Type: "password",
+				},
+				{
+					Name:        "path",
+					Type:        "text",
+					Placeholder: "eg: {{ .path }}",
+				},
+				{
+					Name:        "destination",
+					Type:        "text",
+					Placeholder: "eg: /backup/",
+				},
+			},
+		},
+	}
+}
+
+func (this *FilesCopy) Execute(params map[string]string, input map[string]string) (map[string]string, error) {
+	app, err := createApp(params["token"])
+	if err != nil {
+		return input, err
+	}
+	from, err := resolvePath(app, Render(params["path"], input))
+	if err != nil {
+		return input, err
+	}
+	to, err := resolvePath(app, Render(params["destination"], input))
+	if err != nil {
+		return input, err
+	}
+	if IsDirectory(from) == false && IsDirectory(to) {
+		to += filepath.Base(from)
+	}
+	if err = authorise(func(auth IAuthorisation) error {
+		return auth.Cp(app, from, to)
+	}); err != nil {
+		return input, err
+	} else if err = model.Cp(app.Backend, from, to); err != nil {
+		return input, err
+	}
+	return withOutput(input, "path", userPath(app, to)), nil
+}
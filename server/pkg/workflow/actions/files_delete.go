@@ -0,0 +1,90 @@
+package actions
+
+import (
+	"strconv"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+func init() {
+	Hooks.Register.WorkflowAction(&FilesDelete{})
+}
+
+type FilesDelete struct{}
+
+func (this *FilesDelete) Manifest() WorkflowSpecs {
+	return WorkflowSpecs{
+		Name:  "files/delete",
+		Title: "Delete",
+		Icon:  fileIcon,
+		Specs: Form{
+			Elmnts: []FormElement{
+				{
+					Name: "token",
+					Type: "password",
+				},
+				{
+					Name:        "path",
+					Type:        "text",
+					Placeholder: "eg: {{ .path }}",
+				},
+				{
+					Name:        "older_than",
+					Type:        "number",
+					Placeholder: "number of days",
+					Description: "When set and the path is a folder, only the entries of that folder last modified more than this number of days ago are removed",
+				},
+			},
+		},
+	}
+}
+
+func (this *FilesDelete) Execute(params map[string]string, input map[string]string) (map[string]string, error) {
+	app, err := createApp(params["token"])
+	if err != nil {
+		return input, err
+	}
+	path, err := resolvePath(app, Render(params["path"], input))
+	if err != nil {
+		return input, err
+	} else if path == EnforceDirectory(app.Session["path"]) && params["older_than"] == "" {
+		return input, NewError("Refusing to delete the root folder", 400)
+	}
+	if IsDirectory(path) == false || params["older_than"] == "" {
+		if err = authorise(func(auth IAuthorisation) error {
+			return auth.Rm(app, path)
+		}); err != nil {
+			return input, err
+		}
+		return input, app.Backend.Rm(path)
+	}
+
+	days, err := strconv.Atoi(params["older_than"])
+	if err != nil || days < 0 {
+		return input, NewError("Invalid number of days", 400)
+	}
+	limit := time.Now().Add(-time.Duration(days) * 24 * time.Hour)
+	entries, err := app.Backend.Ls(path)
+	if err != nil {
+		return input, err
+	}
+	for _, entry := range entries {
+		if entry.ModTime().IsZero() || entry.ModTime().After(limit) {
+			continue
+		}
+		p := path + entry.Name()
+		if entry.IsDir() {
+			p += "/"
+		}
+		if err = authorise(func(auth IAuthorisation) error {
+			return auth.Rm(app, p)
+		}); err != nil {
+			return input, err
+		} else if err = app.Backend.Rm(p); err != nil {
+			return input, err
+		}
+		Log.Debug("[workflow] action=files/delete path=%s", p)
+	}
+	return input, nil
+}
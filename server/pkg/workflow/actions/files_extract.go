@@ -0,0 +1,79 @@
+package actions
+
+import (
+	"path/filepath"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+	. "github.com/mickael-kerjean/filestash/server/ctrl"
+)
+
+func init() {
+	Hooks.Register.WorkflowAction(&FilesExtract{})
+}
+
+type FilesExtract struct{}
+
+func (this *FilesExtract) Manifest() WorkflowSpecs {
+	return WorkflowSpecs{
+		Name:  "files/extract",
+		Title: "Extract Archive",
+		Icon:  fileIcon,
+		Specs: Form{
+			Elmnts: []FormElement{
+				{
+					Name: "token",
+					Type: "password",
+				},
+				{
+					Name:        "path",
+					Type:        "text",
+					Placeholder: "eg: {{ .path }}",
+				},
+				{
+					Name:        "destination",
+					Type:        "text",
+					Placeholder: "default: folder of the archive",
+				},
+			},
+		},
+	}
+}
+
+func (this *FilesExtract) Execute(params map[string]string, input map[string]string) (map[string]string, error) {
+	app, err := createApp(params["token"])
+	if err != nil {
+		return input, err
+	}
+	archive, err := resolvePath(app, Render(params["path"], input))
+	if err != nil {
+		return input, err
+	} else if IsDirectory(archive) {
+		return input, NewError("Path isn't an archive", 400)
+	}
+	destination := Render(params["destination"], input)
+	if destination == "" {
+		destination = EnforceDirectory(filepath.Dir(archive))
+	} else if destination, err = resolvePath(app, EnforceDirectory(destination)); err != nil {
+		return input, err
+	}
+	if err = authorise(func(auth IAuthorisation) error {
+		if err := auth.Cat(app, archive); err != nil {
+			return err
+		} else if err := auth.Mkdir(app, destination); err != nil {
+			return err
+		}
+		return auth.Save(app, destination)
+	}); err != nil {
+		return input, err
+	}
+
+	if params["destination"] != "" {
+		if err = app.Backend.Mkdir(destination); err != nil {
+			Log.Debug("[workflow] action=files/extract step=mkdir path=%s err=%s", destination, err.Error())
+		}
+	}
+	if err = ExtractArchive(app.Context, app, archive, destination); err != nil {
+		return input, err
+	}
+	return withOutput(input, "path", userPath(app, destination)), nil
+}
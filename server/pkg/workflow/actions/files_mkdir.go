@@ -0,0 +1,64 @@
+package actions
+
+import (
+	"strings"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+func init() {
+	Hooks.Register.WorkflowAction(&FilesMkdir{})
+}
+
+type FilesMkdir struct{}
+
+func (this *FilesMkdir) Manifest() WorkflowSpecs {
+	return WorkflowSpecs{
+		Name:  "files/mkdir",
+		Title: "Create Folder",
+		Icon:  fileIcon,
+		Specs: Form{
+			Elmnts: []FormElement{
+				{
+					Name: "token",
+					Type: "password",
+				},
+				{
+					Name:        "path",
+					Type:        "text",
+					Placeholder: "eg: /reports/{{ .date }}/",
+				},
+			},
+		},
+	}
+}
+
+func (this *FilesMkdir) Execute(params map[string]string, input map[string]string) (map[string]string, error) {
+	app, err := createApp(params["token"])
+	if err != nil {
+		return input, err
+	}
+	path := Render(params["path"], withDate(input))
+	if path == "" {
+		return input, ErrNotValid
+	}
+	path, err = resolvePath(app, EnforceDirectory(path))
+	if err != nil {
+		return input, err
+	}
+	if err = authorise(func(auth IAuthorisation) error {
+		return auth.Mkdir(app, path)
+	}); err != nil {
+		return input, err
+	}
+	// create the missing parents, those which already exists would error out
+	root := EnforceDirectory(app.Session["path"])
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(path, root), "/"), "/")
+	for i := 1; i < len(parts); i++ {
+		app.Backend.Mkdir(root + strings.Join(parts[:i], "/") + "/")
+	}
+	if err = app.Backend.Mkdir(path); err != nil {
+		return input, err
+	}
+	return withOutput(input, "path", userPath(app, path)), nil
+}
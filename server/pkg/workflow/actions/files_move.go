@@ -0,0 +1,67 @@
+package actions
+
+import (
+	"path/filepath"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+func init() {
+	Hooks.Register.WorkflowAction(&FilesMove{})
+}
+
+type FilesMove struct{}
+
+func (this *FilesMove) Manifest() WorkflowSpecs {
+	return WorkflowSpecs{
+		Name:  "files/move",
+		Title: "Move",
+		Icon:  fileIcon,
+		Specs: Form{
+			Elmnts: []FormElement{
+				{
+					Name: "token",
+					Type: "password",
+				},
+				{
+					Name:        "path",
+					Type:        "text",
+					Placeholder: "eg: {{ .path }}",
+				},
+				{
+					Name:        "destination",
+					Type:        "text",
+					Placeholder: "eg: /archive/",
+				},
+			},
+		},
+	}
+}
+
+func (this *FilesMove) Execute(params map[string]string, input map[string]string) (map[string]string, error) {
+	app, err := createApp(params["token"])
+	if err != nil {
+		return input, err
+	}
+	from, err := resolvePath(app, Render(params["path"], input))
+	if err != nil {
+		return input, err
+	}
+	to, err := resolvePath(app, Render(params["destination"], input))
+	if err != nil {
+		return input, err
+	}
+	if IsDirectory(from) == false && IsDirectory(to) {
+		to += filepath.Base(from)
+	} else if IsDirectory(from) != IsDirectory(to) {
+		return input, NewError("Source and destination must be of the same type", 400)
+	}
+	if err = authorise(func(auth IAuthorisation) error {
+		return auth.Mv(app, from, to)
+	}); err != nil {
+		return input, err
+	} else if err = app.Backend.Mv(from, to); err != nil {
+		return input, err
+	}
+	return withOutput(input, "path", userPath(app, to)), nil
+}
@@ -0,0 +1,81 @@
+package actions
+
+import (
+	"path/filepath"
+	"strings"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+func init() {
+	Hooks.Register.WorkflowAction(&FilesRename{})
+}
+
+type FilesRename struct{}
+
+func (this *FilesRename) Manifest() WorkflowSpecs {
+	return WorkflowSpecs{
+		Name:  "files/rename",
+		Title: "Rename",
+		Icon:  fileIcon,
+		Specs: Form{
+			Elmnts: []FormElement{
+				{
+					Name: "token",
+					Type: "password",
+				},
+				{
+					Name:        "path",
+					Type:        "text",
+					Placeholder: "eg: {{ .path }}",
+				},
+				{
+					Name:        "name",
+					Type:        "text",
+					Placeholder: "eg: {{ .date }}_{{ .basename }}{{ .ext }}",
+					Description: "Template for the new name. Available variables on top of the workflow input: name, basename, ext, date and time",
+				},
+			},
+		},
+	}
+}
+
+func (this *FilesRename) Execute(params map[string]string, input map[string]string) (map[string]string, error) {
+	app, err := createApp(params["token"])
+	if err != nil {
+		return input, err
+	}
+	from, err := resolvePath(app, Render(params["path"], input))
+	if err != nil {
+		return input, err
+	}
+	name := filepath.Base(from)
+	ext := filepath.Ext(name)
+	if IsDirectory(from) {
+		ext = ""
+	}
+	newName := strings.TrimSpace(Render(params["name"], withOutput(
+		withDate(input),
+		"name", name,
+		"basename", strings.TrimSuffix(name, ext),
+		"ext", ext,
+	)))
+	if newName == "" || newName == "." || newName == ".." || strings.Contains(newName, "/") {
+		return input, NewError("Invalid name: '"+newName+"'", 400)
+	}
+	to := filepath.Join(filepath.Dir(strings.TrimSuffix(from, "/")), newName)
+	if IsDirectory(from) {
+		to += "/"
+	}
+	if from == to {
+		return input, nil
+	}
+	if err = authorise(func(auth IAuthorisation) error {
+		return auth.Mv(app, from, to)
+	}); err != nil {
+		return input, err
+	} else if err = app.Backend.Mv(from, to); err != nil {
+		return input, err
+	}
+	return withOutput(input, "path", userPath(app, to)), nil
+}
@@ -0,0 +1,125 @@
+package actions
+
+import (
+	"strings"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+func init() {
+	Hooks.Register.WorkflowAction(&FilesTag{})
+}
+
+type FilesTag struct{}
+
+func (this *FilesTag) Manifest() WorkflowSpecs {
+	return WorkflowSpecs{
+		Name:  "files/tag",
+		Title: "Tag",
+		Icon:  fileIcon,
+		Specs: Form{
+			Elmnts: []FormElement{
+				{
+					Name: "token",
+					Type: "password",
+				},
+				{
+					Name:        "path",
+					Type:        "text",
+					Placeholder: "eg: {{ .path }}",
+				},
+				{
+					Name:        "tags",
+					Type:        "text",
+					Placeholder: "eg: invoice, 2025",
+				},
+				{
+					Name:    "mode",
+					Type:    "select",
+					Opts:    []string{"add", "replace", "remove"},
+					Default: "add",
+				},
+			},
+		},
+	}
+}
+
+func (this *FilesTag) Execute(params map[string]string, input map[string]string) (map[string]string, error) {
+	meta := Hooks.Get.Metadata()
+	if meta == nil {
+		return input, ErrNotImplemented
+	}
+	app, err := createApp(params["token"])
+	if err != nil {
+		return input, err
+	}
+	path, err := resolvePath(app, Render(params["path"], input))
+	if err != nil {
+		return input, err
+	}
+	forms, err := meta.Get(app, path)
+	if err != nil {
+		return input, err
+	}
+
+	current := []string{}
+	rest := []FormElement{}
+	for _, form := range forms {
+		if form.Id != "tags" {
+			rest = append(rest, form)
+			continue
+		}
+		if value, ok := form.Value.(string); ok {
+			current = splitTags(value)
+		}
+	}
+	tags := splitTags(Render(params["tags"], input))
+	switch params["mode"] {
+	case "replace":
+		current = tags
+	case "remove":
+		kept := []string{}
+		for _, t := range current {
+			if containsTag(tags, t) == false {
+				kept = append(kept, t)
+			}
+		}
+		current = kept
+	default:
+		for _, t := range tags {
+			if containsTag(current, t) == false {
+				current = append(current, t)
+			}
+		}
+	}
+	if len(current) > 0 {
+		rest = append(rest, FormElement{
+			Id:    "tags",
+			Type:  "hidden",
+			Value: strings.Join(current, ", "),
+		})
+	}
+	if err = meta.Set(app, path, rest); err != nil {
+		return input, err
+	}
+	return withOutput(input, "tags", strings.Join(current, ", ")), nil
+}
+
+func splitTags(value string) []string {
+	tags := []string{}
+	for _, t := range strings.Split(value, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+func containsTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+package actions
+
+import (
+	"archive/zip"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+func init() {
+	Hooks.Register.WorkflowAction(&FilesZip{})
+}
+
+type FilesZip struct{}
+
+func (this *FilesZip) Manifest() WorkflowSpecs {
+	return WorkflowSpecs{
+		Name:  "files/zip",
+		Title: "Create Zip",
+		Icon:  fileIcon,
+		Specs: Form{
+			Elmnts: []FormElement{
+				{
+					Name: "token",
+					Type: "password",
+				},
+				{
+					Name:        "path",
+					Type:        "text",
+					Placeholder: "eg: {{ .path }}",
+				},
+				{
+					Name:        "destination",
+					Type:        "text",
+					Placeholder: "default: next to the source, eg: /reports.zip",
+				},
+			},
+		},
+	}
+}
+
+func (this *FilesZip) Execute(params map[string]string, input map[string]string) (map[string]string, error) {
+	app, err := createApp(params["token"])
+	if err != nil {
+		return input, err
+	}
+	from, err := resolvePath(app, Render(params["path"], input))
+	if err != nil {
+		return input, err
+	}
+	to := Render(params["destination"], input)
+	if to == "" && userPath(app, from) == "/" {
+		return input, NewError("A destination is needed to archive everything", 400)
+	} else if to == "" {
+		to = strings.TrimSuffix(from, "/") + ".zip"
+	} else if to, err = resolvePath(app, to); err != nil {
+		return input, err
+	}
+	if IsDirectory(to) {
+		to += filepath.Base(from) + ".zip"
+	}
+	if IsDirectory(from) && strings.HasPrefix(to, from) {
+		return input, NewError("Cannot create an archive inside the folder being archived", 400)
+	}
+	if err = authorise(func(auth IAuthorisation) error {
+		if IsDirectory(from) {
+			if err := auth.Ls(app, from); err != nil {
+				return err
+			}
+		}
+		return auth.Save(app, to)
+	}); err != nil {
+		return input, err
+	}
+
+	// entries are named relative to the parent of what's being archived
+	root := "/"
+	if from != "/" {
+		root = EnforceDirectory(filepath.Dir(strings.TrimSuffix(from, "/")))
+	}
+	var addToZip func(zw *zip.Writer, path string, modTime time.Time) error
+	addToZip = func(zw *zip.Writer, path string, modTime time.Time) error {
+		header := &zip.FileHeader{
+			Name:     strings.TrimPrefix(path, root),
+			Method:   zip.Deflate,
+			Modified: modTime,
+		}
+		if IsDirectory(path) == false {
+			if err := authorise(func(auth IAuthorisation) error {
+				return auth.Cat(app, path)
+			}); err != nil {
+				return err
+			}
+			file, err := app.Backend.Cat(path)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			w, err := zw.CreateHeader(header)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(w, file)
+			return err
+		}
+		header.Method = zip.Store
+		if header.Name == "" {
+			// the root itself has no entry of its own
+		} else if _, err := zw.CreateHeader(header); err != nil {
+			return err
+		}
+		entries, err := app.Backend.Ls(path)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			p := path + entry.Name()
+			if entry.IsDir() {
+				p += "/"
+			}
+			if err = addToZip(zw, p, entry.ModTime()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	r, w := io.Pipe()
+	go func() {
+		zw := zip.NewWriter(w)
+		err := addToZip(zw, from, time.Now())
+		if err == nil {
+			err = zw.Close()
+		}
+		w.CloseWithError(err)
+	}()
+	err = app.Backend.Save(to, r)
+	r.CloseWithError(err)
+	if err != nil {
+		return input, err
+	}
+	return withOutput(input, "path", userPath(app, to)), nil
+}
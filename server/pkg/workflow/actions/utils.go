@@ -1,9 +1,17 @@
 package actions
 
 import (
+	"context"
+	"strings"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
 	. "github.com/mickael-kerjean/filestash/server/ctrl"
+	"github.com/mickael-kerjean/filestash/server/model"
 )
 
+const fileIcon = `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 640 640"><path d="M192 64C156.7 64 128 92.7 128 128L128 512C128 547.3 156.7 576 192 576L448 576C483.3 576 512 547.3 512 512L512 234.5C512 217.5 505.3 201.2 493.3 189.2L386.7 82.7C374.7 70.7 358.5 64 341.5 64L192 64zM453.5 240L360 240C346.7 240 336 229.3 336 216L336 122.5L453.5 240z"/></svg>`
+
 func Render(templateText string, variables map[string]string) string {
 	rendered, err := TmplExec(templateText, TmplParams(variables))
 	if err != nil {
@@ -11,3 +19,63 @@ func Render(templateText string, variables map[string]string) string {
 	}
 	return rendered
 }
+
+// createApp gives the same session the filewatch trigger uses: the token is the encrypted
+// session of the user the workflow act on behalf of. Operations made by a workflow aren't
+// reported to the event trigger to avoid a workflow retriggering itself
+func createApp(token string) (*App, error) {
+	return model.NewAppFromToken(context.WithValue(context.Background(), "AUDIT", false), token)
+}
+
+// resolvePath maps a path from the workflow onto the backend. Paths coming from the event
+// trigger are already absolute to the backend while the one typed in by a user are relative
+// to the session chroot
+func resolvePath(app *App, path string) (string, error) {
+	chroot := EnforceDirectory(app.Session["path"])
+	if chroot == "/" || strings.HasPrefix(EnforceDirectory(path), chroot) == false {
+		return PathBuilder(app, path)
+	}
+	cleaned := JoinPath("/", path)
+	if IsDirectory(path) {
+		cleaned = EnforceDirectory(cleaned)
+	}
+	if strings.HasPrefix(EnforceDirectory(cleaned), chroot) == false {
+		return "", ErrFilesystemError
+	}
+	return cleaned, nil
+}
+
+func authorise(fn func(auth IAuthorisation) error) error {
+	for _, auth := range Hooks.Get.AuthorisationMiddleware() {
+		if err := fn(auth); err != nil {
+			Log.Info("[workflow] action=authorise err=%s", err.Error())
+			return ErrNotAuthorized
+		}
+	}
+	return nil
+}
+
+// withDate gives templates the current date to make things like "/reports/{{ .date }}/" possible
+func withDate(input map[string]string) map[string]string {
+	now := time.Now()
+	return withOutput(
+		input,
+		"date", now.Format("2006-01-02"),
+		"time", now.Format("150405"),
+	)
+}
+
+func userPath(app *App, path string) string {
+	return "/" + strings.TrimLeft(strings.TrimPrefix(path, app.Session["path"]), "/")
+}
+
+func withOutput(input map[string]string, kv ...string) map[string]string {
+	output := make(map[string]string)
+	for k, v := range input {
+		output[k] = v
+	}
+	for i := 0; i+1 < len(kv); i += 2 {
+		output[kv[i]] = kv[i+1]
+	}
+	return output
+}
@@ -0,0 +1,114 @@
+package workflow
+
+import (
+	"strconv"
+	"strings"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+	"github.com/mickael-kerjean/filestash/server/ctrl"
+)
+
+// EvaluateCondition tells if a step should run given the input accumulated by the previous
+// steps. A condition is made of clauses joined with "&&" and "||" ("&&" binds tighter), each
+// clause being one of:
+//   - key                  => the key exists and is truthy
+//   - !key                 => the key is missing or falsy
+//   - key == value         => also: !=, >, >=, <, <=. Numbers are compared as numbers
+//   - key contains value
+//   - key matches *.pdf    => glob pattern
+//   - {{ template }}       => truthy once rendered
+//
+// An empty condition is always true
+func EvaluateCondition(condition string, input map[string]string) (bool, error) {
+	if strings.TrimSpace(condition) == "" {
+		return true, nil
+	}
+	for _, or := range strings.Split(condition, "||") {
+		ok := true
+		for _, and := range strings.Split(or, "&&") {
+			res, err := evaluateClause(strings.TrimSpace(and), input)
+			if err != nil {
+				return false, err
+			} else if res == false {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func evaluateClause(clause string, input map[string]string) (bool, error) {
+	if clause == "" {
+		return false, NewError("empty clause in condition", 400)
+	} else if strings.Contains(clause, "{{") {
+		out, err := ctrl.TmplExec(clause, ctrl.TmplParams(input))
+		if err != nil {
+			return false, err
+		}
+		return isTruthy(out), nil
+	} else if strings.HasPrefix(clause, "!") {
+		res, err := evaluateClause(strings.TrimSpace(clause[1:]), input)
+		return !res, err
+	}
+
+	for _, op := range []string{" contains ", " matches ", "==", "!=", ">=", "<=", ">", "<"} {
+		idx := strings.Index(clause, op)
+		if idx == -1 {
+			continue
+		}
+		left := input[strings.TrimSpace(clause[:idx])]
+		right := unquote(strings.TrimSpace(clause[idx+len(op):]))
+		switch strings.TrimSpace(op) {
+		case "contains":
+			return strings.Contains(left, right), nil
+		case "matches":
+			return GlobMatch(right, left), nil
+		case "==":
+			return compare(left, right) == 0, nil
+		case "!=":
+			return compare(left, right) != 0, nil
+		case ">=":
+			return compare(left, right) >= 0, nil
+		case "<=":
+			return compare(left, right) <= 0, nil
+		case ">":
+			return compare(left, right) > 0, nil
+		case "<":
+			return compare(left, right) < 0, nil
+		}
+	}
+	return isTruthy(input[clause]), nil
+}
+
+func compare(a string, b string) int {
+	fa, errA := strconv.ParseFloat(a, 64)
+	fb, errB := strconv.ParseFloat(b, 64)
+	if errA == nil && errB == nil {
+		if fa < fb {
+			return -1
+		} else if fa > fb {
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(a, b)
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+func isTruthy(s string) bool {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "0", "false", "no", "<no value>":
+		return false
+	}
+	return true
+}
@@ -69,5 +69,6 @@ func WorkflowDelete(ctx *App, res http.ResponseWriter, req *http.Request) {
 		SendErrorResult(res, err)
 		return
 	}
+	abortWorkflow(id)
 	SendSuccessResult(res, nil)
 }
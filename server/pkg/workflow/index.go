@@ -1,13 +1,23 @@
 package workflow
 
 import (
+	"context"
+	"sync"
 	"time"
 
 	. "github.com/mickael-kerjean/filestash/server/common"
 	. "github.com/mickael-kerjean/filestash/server/pkg/workflow/model"
 )
 
-var job_event = make(chan interface{}, 100)
+var (
+	job_event   = make(chan interface{}, 100)
+	job_running sync.Map // map[string]runningJob
+)
+
+type runningJob struct {
+	workflowID string
+	cancel     context.CancelFunc
+}
 
 func Init() error {
 	if err := InitState(); err != nil {
@@ -53,9 +63,23 @@ func Init() error {
 					time.Sleep(10 * time.Second)
 					continue
 				}
-				ExecuteJob(jobID, workflow, input)
+				ctx, cancel := context.WithCancel(context.Background())
+				job_running.Store(jobID, runningJob{workflow.ID, cancel})
+				ExecuteJob(ctx, jobID, workflow, input)
+				job_running.Delete(jobID)
+				cancel()
 			}
 		}(i)
 	}
 	return nil
 }
+
+// abortWorkflow stops the jobs of a workflow that are running
+func abortWorkflow(workflowID string) {
+	job_running.Range(func(key, value any) bool {
+		if job := value.(runningJob); job.workflowID == workflowID {
+			job.cancel()
+		}
+		return true
+	})
+}
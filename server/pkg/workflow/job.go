@@ -1,21 +1,28 @@
 package workflow
 
 import (
+	"context"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
 	. "github.com/mickael-kerjean/filestash/server/pkg/workflow/model"
 )
 
-func ExecuteJob(jobID string, workflow Workflow, input map[string]string) {
+func ExecuteJob(ctx context.Context, jobID string, workflow Workflow, input map[string]string) {
 	var err error
 	UpdateJob(jobID, "RUNNING", workflow.Actions, input)
 	for i := 0; i < len(workflow.Actions); i++ {
 		if workflow.Actions[i].Done {
 			continue
+		} else if ctx.Err() != nil {
+			UpdateJob(jobID, "FAILURE", workflow.Actions, input)
+			return
 		}
-		input, err = ExecuteAction(workflow.Actions[i], input)
-		workflow.Actions[i].Done = true
+		input, err = executeStep(ctx, &workflow.Actions[i], input, func() {
+			UpdateJob(jobID, "RUNNING", workflow.Actions, input)
+		})
 		if err != nil {
 			status := "FAILURE"
-			workflow.Actions[i].Done = false
 			if input["status"] == "PENDING" {
 				status = "PENDING"
 			}
@@ -27,3 +34,136 @@ func ExecuteJob(jobID string, workflow Workflow, input map[string]string) {
 	UpdateJob(jobID, "SUCCESS", workflow.Actions, map[string]string{})
 	return
 }
+
+// executeStep runs a step according to its policy: a step whose condition doesn't hold is
+// skipped, a failing step is retried with an exponential backoff and once it fails for good
+// its on error branch gets to run. Every attempt is recorded on the step for the job history but
+// only the one of the current run count toward the retries, a job resumed after a restart
+// gets the retries it was configured with
+func executeStep(ctx context.Context, step *Step, input map[string]string, persist func()) (map[string]string, error) {
+	ok, err := EvaluateCondition(step.Condition, input)
+	if err != nil {
+		step.Attempts = append(step.Attempts, StepAttempt{
+			StartedAt: time.Now().Format(time.RFC3339),
+			Status:    "FAILURE",
+			Error:     "condition: " + err.Error(),
+		})
+		return input, err
+	} else if ok == false {
+		step.Skipped = true
+		step.Done = true
+		return input, nil
+	}
+
+	previousAttempts := len(step.Attempts)
+	for {
+		start := time.Now()
+		output, done, err := executeAttempt(*step, input)
+		if output == nil {
+			output = input
+		}
+		attempt := StepAttempt{
+			StartedAt: start.Format(time.RFC3339),
+			Duration:  time.Since(start).Milliseconds(),
+			Status:    "SUCCESS",
+		}
+		if err == nil {
+			step.Attempts = append(step.Attempts, attempt)
+			step.Done = true
+			return output, nil
+		}
+		attempt.Status = "FAILURE"
+		attempt.Error = err.Error()
+		if output["status"] == "PENDING" {
+			attempt.Status = "PENDING"
+			step.Attempts = append(step.Attempts, attempt)
+			return output, err
+		}
+		step.Attempts = append(step.Attempts, attempt)
+		attempts := len(step.Attempts) - previousAttempts
+		if attempts > step.Retry {
+			Log.Debug("[workflow] action=executeStep step=%s attempts=%d err=%s", step.Name, attempts, err.Error())
+			executeOnError(ctx, step, input, err, persist)
+			return output, err
+		}
+		persist()
+		select {
+		case <-time.After(backoff(step.Backoff, attempts)):
+		case <-ctx.Done():
+			Log.Debug("[workflow] action=executeStep step=%s err=cancelled", step.Name)
+			return output, ctx.Err()
+		}
+		// an attempt that timed out is still running, running the action again before it is
+		// done would make its side effects happen twice
+		<-done
+	}
+}
+
+// executeAttempt runs the action once. The done channel gets closed once the action has
+// returned, which on a timeout happens after executeAttempt itself has returned
+func executeAttempt(step Step, input map[string]string) (map[string]string, <-chan struct{}, error) {
+	done := make(chan struct{})
+	if step.Timeout <= 0 {
+		output, err := ExecuteAction(step, input)
+		close(done)
+		return output, done, err
+	}
+	type result struct {
+		output map[string]string
+		err    error
+	}
+	res := make(chan result, 1)
+	go func() {
+		defer close(done)
+		in := make(map[string]string, len(input))
+		for k, v := range input {
+			in[k] = v
+		}
+		output, err := ExecuteAction(step, in)
+		res <- result{output, err}
+	}()
+	select {
+	case r := <-res:
+		return r.output, done, r.err
+	case <-time.After(time.Duration(step.Timeout) * time.Second):
+		return input, done, ErrTimeout
+	}
+}
+
+func executeOnError(ctx context.Context, step *Step, input map[string]string, stepErr error, persist func()) {
+	if len(step.OnError) == 0 {
+		return
+	}
+	branchInput := make(map[string]string, len(input)+2)
+	for k, v := range input {
+		branchInput[k] = v
+	}
+	branchInput["error"] = stepErr.Error()
+	branchInput["error::step"] = step.Name
+	persist()
+	for i := 0; i < len(step.OnError); i++ {
+		if step.OnError[i].Done {
+			continue
+		}
+		var err error
+		if branchInput, err = executeStep(ctx, &step.OnError[i], branchInput, persist); err != nil {
+			Log.Warning("[workflow] action=onError step=%s err=%s", step.OnError[i].Name, err.Error())
+			return
+		}
+		persist()
+	}
+}
+
+func backoff(seconds int, attempt int) time.Duration {
+	if seconds <= 0 {
+		return 0
+	}
+	d := time.Duration(seconds) * time.Second
+	for i := 1; i < attempt && d < time.Hour; i++ {
+		d *= 2
+	}
+	if d > time.Hour {
+		d = time.Hour
+	}
+	return d
+}
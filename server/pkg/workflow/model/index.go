@@ -40,6 +40,30 @@ func InitState() (err error) {
 	CREATE INDEX IF NOT EXISTS idx_jobs_status ON jobs(status);
 	CREATE INDEX IF NOT EXISTS idx_jobs_workflow ON jobs(related_workflow, created_at DESC);`)
 
+	db.Exec(`
+	CREATE TABLE IF NOT EXISTS transfers (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		owner TEXT NOT NULL,
+		status TEXT CHECK(status IN ('READY', 'CLAIMED', 'RUNNING', 'CANCELLED', 'SUCCESS', 'FAILURE')) DEFAULT 'READY',
+		source_token TEXT NOT NULL,
+		source_backend TEXT NOT NULL,
+		source_path TEXT NOT NULL,
+		destination_token TEXT NOT NULL,
+		destination_backend TEXT NOT NULL,
+		destination_path TEXT NOT NULL,
+		progress TEXT NOT NULL DEFAULT '{}', -- JSON encoded TransferProgress
+		error TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_transfers_status ON transfers(status);
+	CREATE INDEX IF NOT EXISTS idx_transfers_owner ON transfers(owner, created_at DESC);`)
+
+	db.Exec(`
+	UPDATE transfers
+		SET status = 'READY', updated_at = CURRENT_TIMESTAMP
+		WHERE status IN ('RUNNING', 'CLAIMED')`)
+
 	db.Exec(`
 	UPDATE jobs
 		SET status = 'READY', updated_at = CURRENT_TIMESTAMP
@@ -0,0 +1,197 @@
+package model
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+type Transfer struct {
+	ID          int              `json:"id"`
+	Owner       string           `json:"-"`
+	Status      string           `json:"status"`
+	Source      TransferTarget   `json:"source"`
+	Destination TransferTarget   `json:"destination"`
+	Progress    TransferProgress `json:"progress"`
+	Error       string           `json:"error,omitempty"`
+	CreatedAt   string           `json:"created_at"`
+	UpdatedAt   string           `json:"updated_at"`
+}
+
+type TransferTarget struct {
+	Token   string `json:"-"`
+	Backend string `json:"backend"`
+	Path    string `json:"path"`
+}
+
+type TransferProgress struct {
+	FilesTotal int    `json:"files_total"`
+	FilesDone  int    `json:"files_done"`
+	BytesTotal int64  `json:"bytes_total"`
+	BytesDone  int64  `json:"bytes_done"`
+	Current    string `json:"current,omitempty"`
+}
+
+const transferColumns = `
+	id, owner, status,
+	source_token, source_backend, source_path,
+	destination_token, destination_backend, destination_path,
+	progress, error, created_at, updated_at`
+
+func CreateTransfer(owner string, src TransferTarget, dst TransferTarget) (Transfer, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return Transfer{}, err
+	}
+	defer tx.Rollback()
+	r, err := tx.Exec(`
+		INSERT INTO transfers (
+			owner, status,
+			source_token, source_backend, source_path,
+			destination_token, destination_backend, destination_path
+		) VALUES (?, 'READY', ?, ?, ?, ?, ?, ?)
+	`, owner, src.Token, src.Backend, src.Path, dst.Token, dst.Backend, dst.Path)
+	if err != nil {
+		return Transfer{}, err
+	}
+	id, err := r.LastInsertId()
+	if err != nil {
+		return Transfer{}, err
+	}
+	if _, err = tx.Exec(`DELETE FROM transfers WHERE owner = ? AND status IN ('SUCCESS', 'CANCELLED', 'FAILURE') AND id NOT IN (
+		SELECT id FROM transfers
+			WHERE owner = ?
+			ORDER BY created_at DESC
+			LIMIT 1000
+	)`, owner, owner); err != nil {
+		return Transfer{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return Transfer{}, err
+	}
+	return GetTransfer(int(id))
+}
+
+func GetTransfer(id int) (Transfer, error) {
+	t, err := scanTransfer(db.QueryRow(`SELECT `+transferColumns+` FROM transfers WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return Transfer{}, ErrNotFound
+	}
+	return t, err
+}
+
+func ListTransfers(owner string) ([]Transfer, error) {
+	rows, err := db.Query(`
+		SELECT `+transferColumns+`
+			FROM transfers
+			WHERE owner = ?
+			ORDER BY created_at DESC, id DESC
+			LIMIT 100
+	`, owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transfers := []Transfer{}
+	for rows.Next() {
+		t, err := scanTransfer(rows)
+		if err != nil {
+			return nil, err
+		}
+		transfers = append(transfers, t)
+	}
+	return transfers, rows.Err()
+}
+
+func NextTransfer() (Transfer, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return Transfer{}, err
+	}
+	defer tx.Rollback()
+	var id int
+	if err = tx.QueryRow(`
+	SELECT id
+		FROM transfers
+		WHERE status = 'READY'
+		ORDER BY updated_at ASC
+		LIMIT 1`).Scan(&id); err != nil {
+		if err == sql.ErrNoRows {
+			return Transfer{}, ErrNotFound
+		}
+		return Transfer{}, err
+	}
+	if _, err = tx.Exec(`UPDATE transfers SET status = 'CLAIMED', updated_at = CURRENT_TIMESTAMP WHERE id = ?`, id); err != nil {
+		return Transfer{}, err
+	} else if err = tx.Commit(); err != nil {
+		return Transfer{}, err
+	}
+	return GetTransfer(id)
+}
+
+// UpdateTransfer persists the progress of a transfer. A transfer that was cancelled in the
+// meantime keeps its status so a worker can't override a decision made by the user
+func UpdateTransfer(id int, status string, progress TransferProgress, errMsg string) {
+	progressJSON, err := json.Marshal(progress)
+	if err != nil {
+		Log.Error("[transfer] from=model on=updateTransfer step=marshal err=%s", err.Error())
+		return
+	}
+	if _, err = db.Exec(`
+	UPDATE transfers
+		SET status = ?, progress = ?, error = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND status IN ('CLAIMED', 'RUNNING')
+	`, status, string(progressJSON), errMsg, id); err != nil {
+		Log.Error("[transfer] from=model on=updateTransfer err=%s", err.Error())
+	}
+}
+
+func CancelTransfer(id int) error {
+	return setTransferStatus(id, "CANCELLED", "'READY', 'CLAIMED', 'RUNNING'", "Transfer is not running")
+}
+
+func ResumeTransfer(id int) error {
+	return setTransferStatus(id, "READY", "'CANCELLED', 'FAILURE'", "Transfer can't be resumed")
+}
+
+func setTransferStatus(id int, status string, allowedFrom string, errMsg string) error {
+	r, err := db.Exec(`
+	UPDATE transfers
+		SET status = ?, error = '', updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND status IN (`+allowedFrom+`)
+	`, status, id)
+	if err != nil {
+		return err
+	}
+	if n, err := r.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return NewError(errMsg, 409)
+	}
+	return nil
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanTransfer(row scanner) (Transfer, error) {
+	var (
+		t            Transfer
+		progressJSON string
+	)
+	if err := row.Scan(
+		&t.ID, &t.Owner, &t.Status,
+		&t.Source.Token, &t.Source.Backend, &t.Source.Path,
+		&t.Destination.Token, &t.Destination.Backend, &t.Destination.Path,
+		&progressJSON, &t.Error, &t.CreatedAt, &t.UpdatedAt,
+	); err != nil {
+		return t, err
+	}
+	if err := json.Unmarshal([]byte(progressJSON), &t.Progress); err != nil {
+		return t, err
+	}
+	return t, nil
+}
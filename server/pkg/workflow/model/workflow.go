@@ -22,6 +22,24 @@ type Step struct {
 	Name   string            `json:"name"`
 	Params map[string]string `json:"params",omitzero`
 	Done   bool              `json:"done,omitempty"`
+
+	// execution policy
+	Condition string `json:"condition,omitempty"` // eg: "path matches *.pdf && size > 0"
+	Retry     int    `json:"retry,omitempty"`     // number of retries after the first attempt
+	Backoff   int    `json:"backoff,omitempty"`   // seconds to wait before the first retry, doubled each time
+	Timeout   int    `json:"timeout,omitempty"`   // seconds before an attempt is considered failed
+	OnError   []Step `json:"on_error,omitempty"`  // steps to run when the step is failing for good
+
+	// execution history
+	Skipped  bool          `json:"skipped,omitempty"`
+	Attempts []StepAttempt `json:"attempts,omitempty"`
+}
+
+type StepAttempt struct {
+	StartedAt string `json:"started_at"`
+	Duration  int64  `json:"duration"` // milliseconds
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
 }
 
 func FindWorkflows(triggerName string) ([]Workflow, error) {
@@ -43,6 +43,11 @@ func (this hookAuthorisation) Mv(ctx *App, from string, to string) error {
 	return nil
 }
 
+func (this hookAuthorisation) Cp(ctx *App, from string, to string) error {
+	processFileAction(ctx, map[string]string{"event": "cp", "path": from + ", " + to})
+	return nil
+}
+
 func (this hookAuthorisation) Save(ctx *App, path string) error {
 	processFileAction(ctx, map[string]string{"event": "stat", "path": path})
 	return nil
@@ -70,7 +75,7 @@ func (this *FileEventTrigger) Manifest() WorkflowSpecs {
 				{
 					Name:       "event",
 					Type:       "text",
-					Datalist:   []string{"ls", "cat", "mkdir", "mv", "rm", "touch", "save", "stat"},
+					Datalist:   []string{"ls", "cat", "mkdir", "mv", "cp", "rm", "touch", "save", "stat"},
 					MultiValue: true,
 				},
 				{
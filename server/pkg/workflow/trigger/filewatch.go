@@ -2,14 +2,13 @@ package trigger
 
 import (
 	"context"
-	"encoding/json"
 	"os"
 	"sync"
 	"time"
 
 	. "github.com/mickael-kerjean/filestash/server/common"
-	. "github.com/mickael-kerjean/filestash/server/pkg/workflow/model"
 	"github.com/mickael-kerjean/filestash/server/model"
+	. "github.com/mickael-kerjean/filestash/server/pkg/workflow/model"
 )
 
 var (
@@ -60,17 +59,17 @@ func (this *WatchTrigger) Init() (chan ITriggerEvent, error) {
 func filewatchCallback(workflow Workflow) (map[string]string, bool) {
 	path := workflow.Trigger.Params["path"]
 	out := map[string]string{"path": path}
-	backend, session, err := createBackend(workflow.Trigger.Params["token"])
+	app, err := model.NewAppFromToken(context.Background(), workflow.Trigger.Params["token"])
 	if err != nil {
 		Log.Error("[workflow] trigger=filewatch step=callback::init err=%s", err.Error())
 		return out, false
 	}
-	files, err := backend.Ls(path)
+	files, err := app.Backend.Ls(path)
 	if err != nil {
 		Log.Error("[workflow] trigger=filewatch step=callback::ls err=%s", err.Error())
 		return out, false
 	}
-	key := GenerateID(session) + path
+	key := GenerateID(app.Session) + path
 	fincache, exists := filewatch_state.Load(key)
 	if !exists {
 		filewatch_state.Store(key, files)
@@ -105,19 +104,3 @@ func filewatchCallback(workflow Workflow) (map[string]string, bool) {
 	}
 	return out, false
 }
-
-func createBackend(token string) (IBackend, map[string]string, error) {
-	session := map[string]string{}
-	str, err := DecryptString(SECRET_KEY_DERIVATE_FOR_USER, token)
-	if err != nil {
-		return nil, session, err
-	}
-	if err = json.Unmarshal([]byte(str), &session); err != nil {
-		return nil, session, err
-	}
-	backend, err := model.NewBackend(
-		&App{Context: context.Background()},
-		session,
-	)
-	return backend, session, err
-}
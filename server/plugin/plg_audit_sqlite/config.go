@@ -0,0 +1,41 @@
+package plg_audit_sqlite
+
+import (
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+func init() {
+	Hooks.Register.Onload(func() {
+		PluginEnable()
+		PluginPageSize()
+	})
+}
+
+var PluginEnable = func() bool {
+	return Config.Get("features.audit.enable").Schema(func(f *FormElement) *FormElement {
+		if f == nil {
+			f = &FormElement{}
+		}
+		f.Name = "enable"
+		f.Type = "enable"
+		f.Target = []string{"audit_page_size"}
+		f.Description = "Record every file operation in an append only audit log"
+		f.Default = true
+		return f
+	}).Bool()
+}
+
+var PluginPageSize = func() int {
+	return Config.Get("features.audit.page_size").Schema(func(f *FormElement) *FormElement {
+		if f == nil {
+			f = &FormElement{}
+		}
+		f.Id = "audit_page_size"
+		f.Name = "page_size"
+		f.Type = "number"
+		f.Description = "Number of entries shown per page in the audit log"
+		f.Placeholder = "Default: 100"
+		f.Default = 100
+		return f
+	}).Int()
+}
@@ -0,0 +1,59 @@
+package plg_audit_sqlite
+
+import (
+	"database/sql"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+var db *sql.DB
+
+func init() {
+	Hooks.Register.Onload(func() {
+		if err := initDB(); err != nil {
+			// the rest of the application keeps working, only the audit log is disabled
+			Log.Error("plg_audit_sqlite::db err=cannot_init msg=%s", err.Error())
+			if db != nil {
+				db.Close()
+			}
+			db = nil
+		}
+	})
+}
+
+func initDB() (err error) {
+	db, err = sql.Open("sqlite3", GetAbsolutePath(DB_PATH, "audit.db"))
+	if err != nil {
+		return err
+	}
+	db.SetMaxOpenConns(1)
+	// the audit log is append only: once an entry is written, nobody should be able to
+	// alter or remove it, not even through a bug in our own code
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS audit (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			time INTEGER NOT NULL,
+			action TEXT NOT NULL,
+			path TEXT NOT NULL,
+			target TEXT NOT NULL DEFAULT '',
+			backend TEXT NOT NULL DEFAULT '',
+			session TEXT NOT NULL DEFAULT '',
+			share TEXT NOT NULL DEFAULT '',
+			user TEXT NOT NULL DEFAULT '',
+			ip TEXT NOT NULL DEFAULT '',
+			status INTEGER NOT NULL DEFAULT 0
+		);
+		CREATE INDEX IF NOT EXISTS idx_audit_time ON audit(time DESC);
+		CREATE INDEX IF NOT EXISTS idx_audit_user ON audit(user, time DESC);
+		CREATE INDEX IF NOT EXISTS idx_audit_path ON audit(path);
+		CREATE TRIGGER IF NOT EXISTS audit_no_update BEFORE UPDATE ON audit
+		BEGIN
+			SELECT RAISE(ABORT, 'audit log is append only');
+		END;
+		CREATE TRIGGER IF NOT EXISTS audit_no_delete BEFORE DELETE ON audit
+		BEGIN
+			SELECT RAISE(ABORT, 'audit log is append only');
+		END;
+	`)
+	return err
+}
@@ -0,0 +1,192 @@
+package plg_audit_sqlite
+
+import (
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+/*
+ * The audit log is populated from a middleware rather than an authorisation hook. This way we know
+ * who made the request, from where and whether the operation was successful, which is exactly what
+ * we need to answer questions like: "who downloaded this file last week?"
+ */
+func init() {
+	Hooks.Register.AuditEngine(AuditImpl{})
+	Hooks.Register.Middleware(func(next HandlerFunc) HandlerFunc {
+		return HandlerFunc(func(ctx *App, res http.ResponseWriter, req *http.Request) {
+			next(ctx, res, req)
+			if !PluginEnable() || db == nil || ctx.Session == nil {
+				return
+			}
+			entries := auditEntries(ctx, req)
+			if len(entries) == 0 {
+				return
+			}
+			status := http.StatusOK
+			if obj, ok := res.(interface{ Status() int }); ok && obj.Status() != 0 {
+				status = obj.Status()
+			}
+			for i := range entries {
+				entries[i].Time = time.Now()
+				entries[i].Backend = ctx.Session["type"]
+				entries[i].Session = GenerateID(ctx.Session)
+				entries[i].Share = ctx.Share.Id
+				entries[i].User = getUser(ctx.Session)
+				entries[i].IP = ClientIP(req)
+				entries[i].Status = status
+				go StoreAudit(entries[i])
+			}
+		})
+	})
+}
+
+type AuditEntry struct {
+	Time    time.Time `json:"time"`
+	Action  string    `json:"action"`
+	Path    string    `json:"path"`
+	Target  string    `json:"target,omitempty"`
+	Backend string    `json:"backend"`
+	Session string    `json:"session"`
+	Share   string    `json:"share,omitempty"`
+	User    string    `json:"user"`
+	IP      string    `json:"ip"`
+	Status  int       `json:"status"`
+}
+
+func auditEntries(ctx *App, req *http.Request) []AuditEntry {
+	query := req.URL.Query()
+	urlPath := TrimBase(req.URL.Path)
+	entry := func(action string, path string) []AuditEntry {
+		if path == "" {
+			return nil
+		}
+		return []AuditEntry{{Action: action, Path: fullpath(ctx, path)}}
+	}
+
+	// regular file operations made from the web interface or the API
+	if strings.HasPrefix(urlPath, "/api/files/") {
+		switch strings.TrimPrefix(urlPath, "/api/files/") {
+		case "ls":
+			return entry("list", query.Get("path"))
+		case "cat":
+			if req.Method == http.MethodPost {
+				return entry("save_file", query.Get("path"))
+			} else if req.Method != http.MethodGet || query.Get("thumbnail") == "true" {
+				return nil
+			}
+			return entry("download", query.Get("path"))
+		case "save":
+			if req.Method != http.MethodPost {
+				return nil
+			}
+			return entry("save_file", query.Get("path"))
+		case "zip":
+			if req.Method != http.MethodGet {
+				return nil
+			}
+			out := []AuditEntry{}
+			for _, p := range query["path"] {
+				out = append(out, entry("download", p)...)
+			}
+			return out
+		case "unzip":
+			out := []AuditEntry{}
+			for _, p := range query["path"] {
+				out = append(out, entry("extract", p)...)
+			}
+			return out
+		case "mv":
+			return moveEntry(ctx, query.Get("from"), query.Get("to"))
+		case "cp":
+			if query.Get("from") == "" || query.Get("to") == "" {
+				return nil
+			}
+			return []AuditEntry{{
+				Action: "copy",
+				Path:   fullpath(ctx, query.Get("from")),
+				Target: fullpath(ctx, query.Get("to")),
+			}}
+		case "rm":
+			return entry("remove", query.Get("path"))
+		case "mkdir":
+			return entry("create_folder", query.Get("path"))
+		case "touch":
+			return entry("create_file", query.Get("path"))
+		}
+		return nil
+	}
+
+	// webdav access from a shared link or from the authenticated endpoint
+	prefix := ""
+	if urlPath == "/dav" || strings.HasPrefix(urlPath, "/dav/") {
+		prefix = "/dav"
+	} else if ctx.Share.Id != "" && strings.HasPrefix(urlPath, "/s/"+ctx.Share.Id) {
+		prefix = "/s/" + ctx.Share.Id
+	} else {
+		return nil
+	}
+	path := "/" + strings.TrimPrefix(strings.TrimPrefix(urlPath, prefix), "/")
+	switch req.Method {
+	case "GET":
+		return entry("download", path)
+	case "PUT":
+		return entry("save_file", path)
+	case "MKCOL":
+		return entry("create_folder", path)
+	case "DELETE":
+		return entry("remove", path)
+	case "PROPFIND":
+		if req.Header.Get("Depth") == "0" {
+			return nil
+		}
+		return entry("list", path)
+	case "MOVE", "COPY":
+		u, err := url.Parse(req.Header.Get("Destination"))
+		if err != nil {
+			return nil
+		}
+		to := "/" + strings.TrimPrefix(strings.TrimPrefix(TrimBase(u.Path), prefix), "/")
+		if req.Method == "COPY" {
+			return []AuditEntry{{Action: "copy", Path: fullpath(ctx, path), Target: fullpath(ctx, to)}}
+		}
+		return moveEntry(ctx, path, to)
+	}
+	return nil
+}
+
+func moveEntry(ctx *App, from string, to string) []AuditEntry {
+	if from == "" || to == "" {
+		return nil
+	}
+	action := "move"
+	if filepath.Dir(strings.TrimSuffix(from, "/")) == filepath.Dir(strings.TrimSuffix(to, "/")) {
+		action = "rename"
+	}
+	return []AuditEntry{{
+		Action: action,
+		Path:   fullpath(ctx, from),
+		Target: fullpath(ctx, to),
+	}}
+}
+
+func fullpath(ctx *App, path string) string {
+	p := JoinPath(ctx.Session["path"], path)
+	if strings.HasSuffix(path, "/") && strings.HasSuffix(p, "/") == false {
+		p += "/"
+	}
+	return p
+}
+
+func getUser(session map[string]string) string {
+	if session["user"] != "" {
+		return session["user"]
+	} else if session["username"] != "" {
+		return session["username"]
+	}
+	return ""
+}
@@ -0,0 +1,173 @@
+package plg_audit_sqlite
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+	"github.com/mickael-kerjean/filestash/server/model"
+)
+
+type AuditImpl struct{}
+
+func (this AuditImpl) Query(ctx *App, searchParams map[string]string) (AuditQueryResult, error) {
+	if db == nil {
+		return AuditQueryResult{}, ErrMissingDependency
+	}
+	limit := PluginPageSize()
+	if limit <= 0 {
+		limit = 100
+	}
+	page, err := strconv.Atoi(searchParams["page"])
+	if err != nil || page < 1 {
+		page = 1
+	}
+	total, err := CountAudit(ctx.Context, searchParams)
+	if err != nil {
+		return AuditQueryResult{}, err
+	}
+	entries := []AuditEntry{}
+	if err = SearchAudit(ctx.Context, searchParams, limit, (page-1)*limit, func(entry AuditEntry) error {
+		entries = append(entries, entry)
+		return nil
+	}); err != nil {
+		return AuditQueryResult{}, err
+	}
+	return AuditQueryResult{
+		Form:       auditForm(searchParams),
+		RenderHTML: renderHTML(searchParams, entries, total, page, limit),
+	}, nil
+}
+
+func (this AuditImpl) Export(ctx *App, searchParams map[string]string, res http.ResponseWriter) error {
+	if db == nil {
+		return ErrMissingDependency
+	}
+	filename := "audit_" + time.Now().Format("20060102_150405")
+	header := res.Header()
+	switch searchParams["format"] {
+	case "csv":
+		header.Set("Content-Type", "text/csv")
+		header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.csv\"", filename))
+		w := csv.NewWriter(res)
+		w.Write([]string{"time", "action", "path", "target", "backend", "session", "share", "user", "ip", "status"})
+		err := SearchAudit(ctx.Context, searchParams, 0, 0, func(entry AuditEntry) error {
+			return w.Write([]string{
+				entry.Time.Format(time.RFC3339), entry.Action, entry.Path, entry.Target, entry.Backend,
+				entry.Session, entry.Share, entry.User, entry.IP, strconv.Itoa(entry.Status),
+			})
+		})
+		w.Flush()
+		return err
+	case "json":
+		header.Set("Content-Type", "application/json")
+		header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.json\"", filename))
+		res.Write([]byte("["))
+		i := 0
+		err := SearchAudit(ctx.Context, searchParams, 0, 0, func(entry AuditEntry) error {
+			b, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+			if i > 0 {
+				res.Write([]byte(",\n"))
+			}
+			i += 1
+			_, err = res.Write(b)
+			return err
+		})
+		res.Write([]byte("]"))
+		return err
+	}
+	return ErrNotSupported
+}
+
+func auditForm(searchParams map[string]string) *Form {
+	form := Form{}
+	for _, f := range model.AuditForm.Form {
+		elmnts := make([]FormElement, 0, len(f.Elmnts)+1)
+		for _, el := range f.Elmnts {
+			if v := searchParam(searchParams, el.Name); v != "" {
+				el.Value = v
+			}
+			elmnts = append(elmnts, el)
+		}
+		elmnts = append(elmnts, FormElement{
+			Name:        "page",
+			Type:        "number",
+			Placeholder: "1",
+			Value: func() any {
+				if v := searchParams["page"]; v != "" {
+					return v
+				}
+				return nil
+			}(),
+		})
+		form.Form = append(form.Form, Form{Title: f.Title, Elmnts: elmnts})
+	}
+	return &form
+}
+
+func renderHTML(searchParams map[string]string, entries []AuditEntry, total int, page int, limit int) string {
+	exportURL := func(format string) string {
+		p := url.Values{}
+		for k, v := range searchParams {
+			if k == "page" || k == "format" {
+				continue
+			}
+			p.Set(k, v)
+		}
+		p.Set("format", format)
+		return html.EscapeString(WithBase("/admin/api/audit") + "?" + p.Encode())
+	}
+	pages := (total + limit - 1) / limit
+	if pages == 0 {
+		pages = 1
+	}
+
+	var b strings.Builder
+	b.WriteString(`<style>
+            #audit-log { margin-top: 15px; }
+            #audit-log .audit-summary { display: flex; justify-content: space-between; margin-bottom: 10px; }
+            #audit-log .audit-summary a { margin-left: 10px; }
+            #audit-log table { width: 100%; border-collapse: collapse; font-size: 0.9em; }
+            #audit-log th { text-align: left; }
+            #audit-log th, #audit-log td { padding: 5px; border-bottom: 1px solid var(--bg-color); white-space: nowrap; }
+            #audit-log td.audit-path { white-space: normal; word-break: break-all; }
+            #audit-log tr.audit-error td { color: var(--error); }
+        </style>
+        <div id="audit-log">`)
+	fmt.Fprintf(
+		&b, `<div class="audit-summary"><span>%d entries - page %d of %d</span><span>export: <a href="%s" download>csv</a><a href="%s" download>json</a></span></div>`,
+		total, page, pages, exportURL("csv"), exportURL("json"),
+	)
+	b.WriteString(`<table><thead><tr><th>date</th><th>action</th><th>user</th><th>path</th><th>target</th><th>backend</th><th>share</th><th>ip</th><th>status</th></tr></thead><tbody>`)
+	for _, entry := range entries {
+		class := ""
+		if entry.Status >= 400 {
+			class = ` class="audit-error"`
+		}
+		fmt.Fprintf(
+			&b, `<tr%s><td>%s</td><td>%s</td><td>%s</td><td class="audit-path">%s</td><td class="audit-path">%s</td><td>%s</td><td>%s</td><td>%s</td><td>%d</td></tr>`,
+			class,
+			entry.Time.Format("2006-01-02 15:04:05"),
+			html.EscapeString(entry.Action),
+			html.EscapeString(entry.User),
+			html.EscapeString(entry.Path),
+			html.EscapeString(entry.Target),
+			html.EscapeString(entry.Backend),
+			html.EscapeString(entry.Share),
+			html.EscapeString(entry.IP),
+			entry.Status,
+		)
+	}
+	b.WriteString(`</tbody></table></div>`)
+	return b.String()
+}
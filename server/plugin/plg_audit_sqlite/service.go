@@ -0,0 +1,131 @@
+package plg_audit_sqlite
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+)
+
+func StoreAudit(entry AuditEntry) error {
+	_, err := db.Exec(`
+		INSERT INTO audit(time, action, path, target, backend, session, share, user, ip, status)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, entry.Time.UnixMilli(), entry.Action, entry.Path, entry.Target, entry.Backend, entry.Session, entry.Share, entry.User, entry.IP, entry.Status)
+	if err != nil {
+		Log.Warning("plg_audit_sqlite::store action=%s path=%s err=%s", entry.Action, entry.Path, err.Error())
+	}
+	return err
+}
+
+func CountAudit(ctx context.Context, searchParams map[string]string) (int, error) {
+	where, args, err := auditFilter(searchParams)
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	err = db.QueryRowContext(ctx, "SELECT COUNT(*) FROM audit "+where, args...).Scan(&count)
+	return count, err
+}
+
+func SearchAudit(ctx context.Context, searchParams map[string]string, limit int, offset int, fn func(AuditEntry) error) error {
+	where, args, err := auditFilter(searchParams)
+	if err != nil {
+		return err
+	}
+	query := "SELECT time, action, path, target, backend, session, share, user, ip, status FROM audit " + where + " ORDER BY time DESC, id DESC"
+	if limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, limit, offset)
+	}
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			entry AuditEntry
+			t     int64
+		)
+		if err = rows.Scan(&t, &entry.Action, &entry.Path, &entry.Target, &entry.Backend, &entry.Session, &entry.Share, &entry.User, &entry.IP, &entry.Status); err != nil {
+			return err
+		}
+		entry.Time = time.UnixMilli(t)
+		if err = fn(entry); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func auditFilter(searchParams map[string]string) (string, []any, error) {
+	conditions := []string{}
+	args := []any{}
+	if v := searchParam(searchParams, "date from"); v != "" {
+		t, _, err := parseDate(v)
+		if err != nil {
+			return "", nil, err
+		}
+		conditions = append(conditions, "time >= ?")
+		args = append(args, t.UnixMilli())
+	}
+	if v := searchParam(searchParams, "date to"); v != "" {
+		t, wholeDay, err := parseDate(v)
+		if err != nil {
+			return "", nil, err
+		}
+		if wholeDay { // the day itself is part of the range
+			conditions = append(conditions, "time < ?")
+			args = append(args, t.AddDate(0, 0, 1).UnixMilli())
+		} else {
+			conditions = append(conditions, "time <= ?")
+			args = append(args, t.UnixMilli())
+		}
+	}
+	for _, key := range []string{"action", "backend", "session", "share", "user"} {
+		if v := searchParams[key]; v != "" {
+			conditions = append(conditions, key+" = ?")
+			args = append(args, v)
+		}
+	}
+	for _, key := range []string{"path", "target"} {
+		if v := searchParams[key]; v != "" {
+			conditions = append(conditions, key+" LIKE ? ESCAPE '\\'")
+			args = append(args, "%"+escapeLike(v)+"%")
+		}
+	}
+	if len(conditions) == 0 {
+		return "", args, nil
+	}
+	return "WHERE " + strings.Join(conditions, " AND "), args, nil
+}
+
+// the admin console sends the form keys with underscores instead of spaces, eg: "date_from"
+func searchParam(searchParams map[string]string, name string) string {
+	if v := searchParams[name]; v != "" {
+		return v
+	}
+	return searchParams[strings.ReplaceAll(name, " ", "_")]
+}
+
+// parseDate also tells if the date is a whole day rather than a point in time
+func parseDate(value string) (time.Time, bool, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, layout == "2006-01-02", nil
+		}
+	}
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.UnixMilli(ms), false, nil
+	}
+	return time.Time{}, false, NewError("Invalid date '"+value+"'", 400)
+}
+
+func escapeLike(value string) string {
+	value = strings.ReplaceAll(value, "\\", "\\\\")
+	value = strings.ReplaceAll(value, "%", "\\%")
+	return strings.ReplaceAll(value, "_", "\\_")
+}
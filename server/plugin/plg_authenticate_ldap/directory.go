@@ -0,0 +1,73 @@
+package plg_authenticate_ldap
+
+import (
+	"encoding/json"
+	"strings"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+const DIRECTORY_SIZE_LIMIT = 20
+
+// LdapDirectory makes the users of the LDAP server available to the rest of the application,
+// eg: to mention people in the chat widget. It is only active when ldap is the identity provider
+type LdapDirectory struct{}
+
+func (this LdapDirectory) Search(query string) ([]DirectoryUser, error) {
+	users := []DirectoryUser{}
+	if Config.Get("middleware.identity_provider.type").String() != "ldap" {
+		return users, nil
+	}
+	params := map[string]string{}
+	if err := json.Unmarshal(
+		[]byte(Config.Get("middleware.identity_provider.params").String()),
+		&params,
+	); err != nil {
+		return users, ErrNotValid
+	}
+	conn, err := dial(params)
+	if err != nil {
+		return users, err
+	}
+	defer conn.Close()
+
+	filter := "(objectClass=person)"
+	if query = strings.TrimSpace(query); query != "" {
+		q := ldap.EscapeFilter(query)
+		filter = "(&" + filter + "(|(cn=*" + q + "*)(uid=*" + q + "*)(mail=*" + q + "*)(displayName=*" + q + "*)))"
+	}
+	sr, err := conn.Search(ldap.NewSearchRequest(
+		params["Base DN"], ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		DIRECTORY_SIZE_LIMIT, int(LDAP_TIMEOUT.Seconds()), false,
+		filter, []string{"uid", "sAMAccountName", "cn", "displayName", "mail"}, nil,
+	))
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		Log.Warning("plg_authenticate_ldap::directory err=%s", err.Error())
+		return users, ErrNotReachable
+	} else if sr == nil {
+		return users, nil
+	}
+	for _, entry := range sr.Entries {
+		user := DirectoryUser{
+			Id:    firstOf(entry.GetAttributeValue("uid"), entry.GetAttributeValue("sAMAccountName"), entry.DN),
+			Name:  firstOf(entry.GetAttributeValue("displayName"), entry.GetAttributeValue("cn")),
+			Email: entry.GetAttributeValue("mail"),
+		}
+		if user.Name == "" {
+			user.Name = user.Id
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+func firstOf(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
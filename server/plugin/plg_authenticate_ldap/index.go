@@ -1,12 +1,16 @@
 package plg_authenticate_ldap
 
 import (
-	. "github.com/mickael-kerjean/filestash/server/common"
+	"fmt"
+	"html"
 	"net/http"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
 )
 
 func init() {
 	Hooks.Register.AuthenticationMiddleware("ldap", Ldap{})
+	Hooks.Register.DirectoryService(LdapDirectory{})
 }
 
 type Ldap struct{}
@@ -63,19 +67,63 @@ The plugin exposes the LDAP attribute of the authenticated users which can be us
 				Value:       "",
 				Placeholder: "default: (&(objectclass=person)(|(uid={{.username}})(mail={{.username}})(sAMAccountName={{.username}})))",
 			},
+			{
+				Name:        "Group DN",
+				Type:        "text",
+				Value:       "",
+				Placeholder: "eg: cn=filestash,ou=groups,dc=example,dc=com",
+				Description: "Only let in the members of this group. Membership is checked against the memberOf attribute of the user and the member, uniqueMember and memberUid attributes of the group",
+			},
 		},
 	}
 }
 
 func (this Ldap) EntryPoint(idpParams map[string]string, req *http.Request, res http.ResponseWriter) error {
-	http.Redirect(
-		res, req,
-		"https://www.filestash.app/purchase-enterprise-selfhosted.html",
-		http.StatusTemporaryRedirect,
-	)
+	getFlash := func() string {
+		c, err := req.Cookie("flash")
+		if err != nil {
+			return ""
+		}
+		http.SetCookie(res, &http.Cookie{
+			Name:   "flash",
+			MaxAge: -1,
+			Path:   "/",
+		})
+		return fmt.Sprintf(`<p class="flash">%s</p>`, html.EscapeString(c.Value))
+	}
+	res.Header().Set("Content-Type", "text/html; charset=utf-8")
+	res.WriteHeader(http.StatusOK)
+	res.Write([]byte(Page(`
+      <form method="post">
+        <label>
+          <input type="text" name="user" value="" placeholder="Username" autocomplete="username" />
+        </label>
+        <label>
+          <input type="password" name="password" value="" placeholder="Password" autocomplete="current-password" />
+        </label>
+        <button>CONNECT</button>
+        ` + getFlash() + `
+        <style>
+          .flash{ color: #f26d6d; font-weight: bold; }
+          form { padding-top: 10vh; }
+        </style>
+      </form>`)))
 	return nil
 }
 
 func (this Ldap) Callback(formData map[string]string, idpParams map[string]string, res http.ResponseWriter) (map[string]string, error) {
-	return nil, ErrNotImplemented
+	out, err := authenticate(idpParams, formData["user"], formData["password"])
+	if err == ErrAuthenticationFailed {
+		http.SetCookie(res, &http.Cookie{
+			Name:   "flash",
+			Value:  "Invalid username or password",
+			MaxAge: 1,
+			Path:   "/",
+		})
+		return nil, err
+	} else if err != nil {
+		return nil, err
+	}
+	out["password"] = formData["password"]
+	return out, nil
 }
@@ -0,0 +1,193 @@
+package plg_authenticate_ldap
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"text/template"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	LDAP_TIMEOUT          = 10 * time.Second
+	DEFAULT_SEARCH_FILTER = "(&(objectclass=person)(|(uid={{.username}})(mail={{.username}})(sAMAccountName={{.username}})))"
+)
+
+var userAttributes = []string{
+	"uid", "mail", "memberOf", "cn", "displayName", "sAMAccountName", "userPrincipalName",
+}
+
+// authenticate does a search then bind: the service account finds the user in the directory
+// and its password is then verified by binding as that user
+func authenticate(params map[string]string, username string, password string) (map[string]string, error) {
+	if username == "" || password == "" {
+		return nil, ErrAuthenticationFailed
+	}
+	conn, err := dial(params)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	filter, err := searchFilter(params["Search Filter"], username)
+	if err != nil {
+		return nil, err
+	}
+	sr, err := conn.Search(ldap.NewSearchRequest(
+		params["Base DN"], ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, int(LDAP_TIMEOUT.Seconds()), false,
+		filter, userAttributes, nil,
+	))
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		Log.Warning("plg_authenticate_ldap::search err=%s", err.Error())
+		return nil, ErrNotReachable
+	} else if sr == nil || len(sr.Entries) != 1 {
+		Log.Debug("plg_authenticate_ldap::search username=%s err=no_unique_match", username)
+		return nil, ErrAuthenticationFailed
+	}
+	entry := sr.Entries[0]
+	if err = conn.Bind(entry.DN, password); err != nil {
+		Log.Debug("plg_authenticate_ldap::bind dn=%s err=%s", entry.DN, err.Error())
+		return nil, ErrAuthenticationFailed
+	}
+
+	out := map[string]string{
+		"dn":       entry.DN,
+		"username": username,
+		"user":     username,
+	}
+	for _, attr := range userAttributes {
+		if values := entry.GetAttributeValues(attr); len(values) > 0 {
+			out[attr] = strings.Join(values, ", ")
+		}
+	}
+	groups := entry.GetAttributeValues("memberOf")
+	if groupDN := strings.TrimSpace(params["Group DN"]); groupDN != "" {
+		ok, err := isMember(conn, params, entry, groupDN)
+		if err != nil {
+			return nil, err
+		} else if ok == false {
+			Log.Debug("plg_authenticate_ldap::group dn=%s err=not_a_member", entry.DN)
+			return nil, ErrAuthenticationFailed
+		}
+		if containsDN(groups, groupDN) == false {
+			groups = append(groups, groupDN)
+		}
+	}
+	names := make([]string, 0, len(groups))
+	for _, g := range groups {
+		names = append(names, groupName(g))
+	}
+	out["groups"] = strings.Join(names, ", ")
+	return out, nil
+}
+
+// isMember checks the memberOf attribute of the user first as it's cheap and fallback onto the
+// group entry itself for the directories which don't maintain memberOf
+func isMember(conn *ldap.Conn, params map[string]string, entry *ldap.Entry, groupDN string) (bool, error) {
+	if containsDN(entry.GetAttributeValues("memberOf"), groupDN) {
+		return true, nil
+	}
+	if err := serviceBind(conn, params); err != nil {
+		return false, err
+	}
+	filter := "(|(member=" + ldap.EscapeFilter(entry.DN) + ")(uniqueMember=" + ldap.EscapeFilter(entry.DN) + ")"
+	if uid := entry.GetAttributeValue("uid"); uid != "" {
+		filter += "(memberUid=" + ldap.EscapeFilter(uid) + ")"
+	}
+	filter += ")"
+	sr, err := conn.Search(ldap.NewSearchRequest(
+		groupDN, ldap.ScopeBaseObject, ldap.NeverDerefAliases,
+		1, int(LDAP_TIMEOUT.Seconds()), false,
+		filter, []string{"dn"}, nil,
+	))
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		Log.Warning("plg_authenticate_ldap::group dn=%s err=group_not_found", groupDN)
+		return false, nil
+	} else if err != nil {
+		Log.Warning("plg_authenticate_ldap::group err=%s", err.Error())
+		return false, ErrNotReachable
+	}
+	return len(sr.Entries) > 0, nil
+}
+
+func dial(params map[string]string) (*ldap.Conn, error) {
+	if params["Hostname"] == "" {
+		return nil, NewError("Missing LDAP Hostname", 500)
+	}
+	addr := params["Hostname"]
+	if strings.Contains(addr, "://") == false {
+		addr = "ldap://" + addr
+	}
+	if params["Port"] != "" {
+		addr = strings.TrimRight(addr, "/") + ":" + params["Port"]
+	}
+	conn, err := ldap.DialURL(addr, ldap.DialWithDialer(&net.Dialer{Timeout: LDAP_TIMEOUT}))
+	if err != nil {
+		Log.Warning("plg_authenticate_ldap::dial addr=%s err=%s", addr, err.Error())
+		return nil, ErrNotReachable
+	}
+	conn.SetTimeout(LDAP_TIMEOUT)
+	if err = serviceBind(conn, params); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func serviceBind(conn *ldap.Conn, params map[string]string) error {
+	var err error
+	if params["Bind DN"] == "" {
+		err = conn.UnauthenticatedBind("")
+	} else {
+		err = conn.Bind(params["Bind DN"], params["Bind DN Password"])
+	}
+	if err != nil {
+		Log.Warning("plg_authenticate_ldap::bind dn=%s err=%s", params["Bind DN"], err.Error())
+		return NewError("Invalid LDAP Bind DN", 500)
+	}
+	return nil
+}
+
+func searchFilter(tmpl string, username string) (string, error) {
+	if tmpl == "" {
+		tmpl = DEFAULT_SEARCH_FILTER
+	}
+	t, err := template.New("plg_authenticate_ldap::filter").Parse(tmpl)
+	if err != nil {
+		Log.Warning("plg_authenticate_ldap::filter err=%s", err.Error())
+		return "", NewError("Invalid LDAP Search Filter", 500)
+	}
+	var b bytes.Buffer
+	if err = t.Execute(&b, map[string]string{"username": ldap.EscapeFilter(username)}); err != nil {
+		return "", NewError("Invalid LDAP Search Filter", 500)
+	}
+	return b.String(), nil
+}
+
+func containsDN(list []string, dn string) bool {
+	target, err := ldap.ParseDN(dn)
+	for _, item := range list {
+		if strings.EqualFold(item, dn) {
+			return true
+		} else if err != nil {
+			continue
+		} else if d, err := ldap.ParseDN(item); err == nil && d.EqualFold(target) {
+			return true
+		}
+	}
+	return false
+}
+
+// groupName is the cn of a group, eg: cn=admin,ou=groups,dc=example,dc=com => admin
+func groupName(dn string) string {
+	d, err := ldap.ParseDN(dn)
+	if err != nil || len(d.RDNs) == 0 || len(d.RDNs[0].Attributes) == 0 {
+		return dn
+	}
+	return d.RDNs[0].Attributes[0].Value
+}
@@ -0,0 +1,58 @@
+package plg_authenticate_openid
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// flattenClaims turns the claims onto something the attribute mapping can use: lists become
+// comma separated values and nested objects are exposed both as json and with dotted keys,
+// eg: keycloak roles are available as {{ index . "realm_access.roles" }}
+func flattenClaims(claims map[string]any) map[string]string {
+	out := map[string]string{}
+	var walk func(prefix string, value any)
+	walk = func(prefix string, value any) {
+		switch v := value.(type) {
+		case map[string]any:
+			if b, err := json.Marshal(v); err == nil {
+				out[prefix] = string(b)
+			}
+			for key, child := range v {
+				walk(prefix+"."+key, child)
+			}
+		default:
+			out[prefix] = claimString(v)
+		}
+	}
+	for key, value := range claims {
+		walk(key, value)
+	}
+	return out
+}
+
+func claimString(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			switch item.(type) {
+			case map[string]any, []any:
+				b, _ := json.Marshal(v)
+				return string(b)
+			}
+			values = append(values, claimString(item))
+		}
+		return strings.Join(values, ", ")
+	}
+	return fmt.Sprintf("%v", value)
+}
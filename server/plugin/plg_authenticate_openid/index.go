@@ -1,10 +1,23 @@
 package plg_authenticate_openid
 
 import (
-	. "github.com/mickael-kerjean/filestash/server/common"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"net/http"
+	"strings"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+
+	"golang.org/x/oauth2"
 )
 
+// pendingRedirect remembers the redirect uri of the flows in progress as the token exchange
+// needs to give the exact same one
+var pendingRedirect = NewAppCache(10, 20)
+
 func init() {
 	Hooks.Register.AuthenticationMiddleware("openid", OpenID{})
 }
@@ -18,6 +31,8 @@ func (this OpenID) Setup() Form {
 				Name: "banner",
 				Type: "hidden",
 				Description: `This enterprise SSO plugin delegates authentication to an OIDC compliant Identity Provider (IDP). It exposes the attributes of the authenticated user, which can then be used in the attribute mapping section to create rules tailored to your specific use case. See the [full documentation](https://www.filestash.app/setup-oidc.html).
+
+The redirect URI to register in your IDP is: https://your.filestash.host/api/session/auth/
 `,
 			},
 			{
@@ -56,14 +71,144 @@ func (this OpenID) Setup() Form {
 }
 
 func (this OpenID) EntryPoint(idpParams map[string]string, req *http.Request, res http.ResponseWriter) error {
+	if idpParams["ssoref"] == "" {
+		return ErrNotValid
+	}
+	provider, err := getProvider(req.Context(), idpParams["OpenID Config URL"])
+	if err != nil {
+		return err
+	}
+	redirectURI := redirectURL(req)
+	pendingRedirect.Set(map[string]string{"state": deriveSecret(idpParams["ssoref"], "state")}, redirectURI)
 	http.Redirect(
 		res, req,
-		"https://www.filestash.app/purchase-enterprise-selfhosted.html",
-		http.StatusTemporaryRedirect,
+		oauthConfig(provider, idpParams, redirectURI).AuthCodeURL(
+			deriveSecret(idpParams["ssoref"], "state"),
+			oauth2.SetAuthURLParam("nonce", deriveSecret(idpParams["ssoref"], "nonce")),
+			oauth2.S256ChallengeOption(deriveSecret(idpParams["ssoref"], "verifier")),
+		),
+		http.StatusSeeOther,
 	)
 	return nil
 }
 
 func (this OpenID) Callback(formData map[string]string, idpParams map[string]string, res http.ResponseWriter) (map[string]string, error) {
-	return nil, ErrNotImplemented
+	if formData["error"] != "" {
+		Log.Warning("plg_authenticate_openid::callback error=%s description=%s", formData["error"], formData["error_description"])
+		return nil, NewError("Identity provider error: "+formData["error"], 401)
+	} else if formData["code"] == "" {
+		return nil, ErrNotValid
+	} else if idpParams["ssoref"] == "" {
+		Log.Warning("plg_authenticate_openid::callback err=missing_ssoref")
+		return nil, ErrNotAllowed
+	}
+	state := deriveSecret(idpParams["ssoref"], "state")
+	if hmac.Equal([]byte(formData["state"]), []byte(state)) == false {
+		Log.Warning("plg_authenticate_openid::callback err=invalid_state")
+		return nil, ErrNotAllowed
+	}
+	redirectURI, _ := pendingRedirect.Get(map[string]string{"state": state}).(string)
+	if redirectURI == "" {
+		if redirectURI = redirectURL(nil); redirectURI == "" {
+			Log.Warning("plg_authenticate_openid::callback err=unknown_redirect_uri")
+			return nil, ErrNotAllowed
+		}
+	}
+	pendingRedirect.Del(map[string]string{"state": state})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, &HTTPClient)
+	provider, err := getProvider(ctx, idpParams["OpenID Config URL"])
+	if err != nil {
+		return nil, err
+	}
+	token, err := oauthConfig(provider, idpParams, redirectURI).Exchange(
+		ctx, formData["code"],
+		oauth2.VerifierOption(deriveSecret(idpParams["ssoref"], "verifier")),
+	)
+	if err != nil {
+		Log.Warning("plg_authenticate_openid::callback action=exchange err=%s", err.Error())
+		return nil, ErrNotAllowed
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		Log.Warning("plg_authenticate_openid::callback err=missing_id_token")
+		return nil, ErrNotAllowed
+	}
+	claims, err := provider.verifyIDToken(
+		ctx, rawIDToken,
+		idpParams["Client ID"],
+		deriveSecret(idpParams["ssoref"], "nonce"),
+	)
+	if err != nil {
+		Log.Warning("plg_authenticate_openid::callback action=verify err=%s", err.Error())
+		return nil, ErrNotAllowed
+	}
+	if provider.UserinfoEndpoint != "" {
+		if userinfo, err := provider.userinfo(ctx, token); err != nil {
+			Log.Debug("plg_authenticate_openid::callback action=userinfo err=%s", err.Error())
+		} else if userinfo["sub"] == claims["sub"] {
+			for key, value := range userinfo {
+				if _, ok := claims[key]; !ok {
+					claims[key] = value
+				}
+			}
+		}
+	}
+	return flattenClaims(claims), nil
+}
+
+func oauthConfig(provider *Provider, idpParams map[string]string, redirectURI string) *oauth2.Config {
+	scopes := strings.Fields(strings.ReplaceAll(idpParams["Scope"], ",", " "))
+	hasOpenID := false
+	for _, scope := range scopes {
+		if scope == "openid" {
+			hasOpenID = true
+		}
+	}
+	if !hasOpenID {
+		scopes = append([]string{"openid"}, scopes...)
+	}
+	return &oauth2.Config{
+		ClientID:     idpParams["Client ID"],
+		ClientSecret: idpParams["Client Secret"],
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  provider.AuthorizationEndpoint,
+			TokenURL: provider.TokenEndpoint,
+		},
+		RedirectURL: redirectURI,
+		Scopes:      scopes,
+	}
+}
+
+// deriveSecret gives the values of the flow that are bound to the ssoref cookie: the state and
+// nonce are checked on the way back and the pkce verifier is never seen by the browser
+func deriveSecret(ssoref string, purpose string) string {
+	mac := hmac.New(sha256.New, []byte(SECRET_KEY))
+	mac.Write([]byte(purpose + "::" + ssoref))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// redirectURL is the address the IDP sends the user back to. It comes from the configured host
+// when there is one, from the request otherwise
+func redirectURL(req *http.Request) string {
+	origin := ""
+	if host := Config.Get("general.host").String(); host != "" {
+		origin = host
+		if !strings.HasPrefix(host, "http://") && !strings.HasPrefix(host, "https://") {
+			origin = "http://" + host
+			if Config.Get("general.force_ssl").Bool() {
+				origin = "https://" + host
+			}
+		}
+	} else if req != nil {
+		origin = "http://" + req.Host
+		if req.TLS != nil || req.Header.Get("X-Forwarded-Proto") == "https" {
+			origin = "https://" + req.Host
+		}
+	} else {
+		return ""
+	}
+	return strings.TrimRight(origin, "/") + WithBase("/api/session/auth/")
 }
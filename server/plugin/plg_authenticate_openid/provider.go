@@ -0,0 +1,259 @@
+package plg_authenticate_openid
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+const JWKS_REFRESH_INTERVAL = time.Minute
+
+var providerCache = NewAppCache(60, 120)
+
+// Provider is what we need from the discovery document of the IDP
+type Provider struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	UserinfoEndpoint      string   `json:"userinfo_endpoint"`
+	JwksURI               string   `json:"jwks_uri"`
+	SigningAlgs           []string `json:"id_token_signing_alg_values_supported"`
+
+	mu          sync.Mutex
+	keys        map[string]crypto.PublicKey
+	keysFetched time.Time
+}
+
+func getProvider(ctx context.Context, configURL string) (*Provider, error) {
+	if configURL == "" {
+		return nil, NewError("Missing OpenID Config URL", 500)
+	} else if !strings.Contains(configURL, "/.well-known/") {
+		configURL = strings.TrimRight(configURL, "/") + "/.well-known/openid-configuration"
+	}
+	cacheKey := map[string]string{"url": configURL}
+	if p, ok := providerCache.Get(cacheKey).(*Provider); ok {
+		return p, nil
+	}
+	p := &Provider{}
+	if err := fetchJSON(ctx, configURL, nil, p); err != nil {
+		Log.Warning("plg_authenticate_openid::discovery url=%s err=%s", configURL, err.Error())
+		return nil, ErrNotReachable
+	}
+	if p.Issuer == "" || p.AuthorizationEndpoint == "" || p.TokenEndpoint == "" || p.JwksURI == "" {
+		Log.Warning("plg_authenticate_openid::discovery url=%s err=incomplete_configuration", configURL)
+		return nil, ErrNotValid
+	}
+	providerCache.Set(cacheKey, p)
+	return p, nil
+}
+
+// verifyIDToken checks the signature and claims of an ID token as per section 3.1.3.7 of the
+// openid connect core spec and returns its claims
+func (this *Provider) verifyIDToken(ctx context.Context, raw string, clientID string, nonce string) (map[string]any, error) {
+	claims, err := this.parseIDToken(ctx, raw, clientID)
+	if errors.Is(err, jwt.ErrTokenSignatureInvalid) && this.expireKeys() {
+		// the IDP might have rotated its keys without changing their id
+		claims, err = this.parseIDToken(ctx, raw, clientID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, fmt.Errorf("invalid nonce")
+	}
+	if aud, err := claims.GetAudience(); err == nil && len(aud) > 1 {
+		if azp, _ := claims["azp"].(string); azp != clientID {
+			return nil, fmt.Errorf("invalid authorized party")
+		}
+	}
+	return claims, nil
+}
+
+func (this *Provider) parseIDToken(ctx context.Context, raw string, clientID string) (jwt.MapClaims, error) {
+	methods := this.SigningAlgs
+	if len(methods) == 0 {
+		methods = []string{"RS256"}
+	}
+	claims := jwt.MapClaims{}
+	_, err := jwt.NewParser(
+		jwt.WithValidMethods(methods),
+		jwt.WithIssuer(this.Issuer),
+		jwt.WithAudience(clientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Minute),
+	).ParseWithClaims(raw, claims, func(token *jwt.Token) (any, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+			return []byte(""), fmt.Errorf("unsupported signing method %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return this.publicKey(ctx, kid)
+	})
+	return claims, err
+}
+
+func (this *Provider) userinfo(ctx context.Context, token *oauth2.Token) (map[string]any, error) {
+	out := map[string]any{}
+	err := fetchJSON(ctx, this.UserinfoEndpoint, token, &out)
+	return out, err
+}
+
+// publicKey finds the key a token was signed with. Keys get refreshed when an unknown one shows
+// up as that is what happens when the IDP rotates its keys
+func (this *Provider) publicKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if key := this.findKey(kid); key != nil {
+		return key, nil
+	} else if time.Since(this.keysFetched) < JWKS_REFRESH_INTERVAL {
+		return nil, fmt.Errorf("unknown key '%s'", kid)
+	}
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := fetchJSON(ctx, this.JwksURI, nil, &jwks); err != nil {
+		return nil, err
+	}
+	this.keys = map[string]crypto.PublicKey{}
+	this.keysFetched = time.Now()
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			Log.Debug("plg_authenticate_openid::jwks kid=%s err=%s", k.Kid, err.Error())
+			continue
+		}
+		this.keys[k.Kid] = key
+	}
+	if key := this.findKey(kid); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key '%s'", kid)
+}
+
+func (this *Provider) expireKeys() bool {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if time.Since(this.keysFetched) < JWKS_REFRESH_INTERVAL {
+		return false
+	}
+	this.keys = nil
+	return true
+}
+
+func (this *Provider) findKey(kid string) crypto.PublicKey {
+	if key, ok := this.keys[kid]; ok {
+		return key
+	} else if kid == "" && len(this.keys) == 1 {
+		for _, key := range this.keys {
+			return key
+		}
+	}
+	return nil
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (this jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch this.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(this.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(this.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch this.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve '%s'", this.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(this.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(this.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	case "OKP":
+		if this.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve '%s'", this.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(this.X)
+		if err != nil {
+			return nil, err
+		} else if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid key size")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type '%s'", this.Kty)
+}
+
+func fetchJSON(ctx context.Context, url string, token *oauth2.Token, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if token != nil {
+		token.SetAuthHeader(req)
+	}
+	resp, err := HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, url)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out)
+}
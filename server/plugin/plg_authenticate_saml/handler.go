@@ -0,0 +1,283 @@
+package plg_authenticate_saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+	"github.com/mickael-kerjean/filestash/server/model"
+
+	"github.com/beevik/etree"
+	"github.com/mickael-kerjean/saml"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+func MetadataHandler(res http.ResponseWriter, req *http.Request) {
+	params, err := getParams()
+	if err != nil {
+		SendErrorResult(res, err)
+		return
+	}
+	sp, err := newServiceProvider(params["IDP Metadata"], requestOrigin(req))
+	if err != nil {
+		SendErrorResult(res, err)
+		return
+	}
+	b, err := xml.MarshalIndent(sp.Metadata(), "", "  ")
+	if err != nil {
+		SendErrorResult(res, err)
+		return
+	}
+	res.Header().Set("Content-Type", "application/samlmetadata+xml")
+	res.Write(b)
+}
+
+func AcsHandler(res http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		SendErrorResult(res, ErrNotValid)
+		return
+	}
+	res.Header().Set("Content-Type", "text/html; charset=utf-8")
+	res.WriteHeader(http.StatusOK)
+	res.Write([]byte(relayPage(req.PostForm.Get("SAMLResponse"), req.PostForm.Get("RelayState"))))
+}
+
+// SloHandler takes care of single logout, it can be:
+// 1. a logout request from the IDP
+// 2. the response of the IDP to a logout we initiated
+// 3. the user wanting to logout from both Filestash and the IDP
+func SloHandler(res http.ResponseWriter, req *http.Request) {
+	params, err := getParams()
+	if err != nil {
+		SendErrorResult(res, err)
+		return
+	}
+	sp, err := newServiceProvider(params["IDP Metadata"], requestOrigin(req))
+	if err != nil {
+		SendErrorResult(res, err)
+		return
+	} else if sp.IDPMetadata == nil {
+		SendErrorResult(res, ErrNotFound)
+		return
+	}
+	req.ParseForm()
+
+	if samlRequest := req.Form.Get("SAMLRequest"); samlRequest != "" {
+		logoutRequest, err := parseLogoutRequest(sp, req, samlRequest)
+		if err != nil {
+			Log.Warning("plg_authenticate_saml::slo action=parse_request err=%s", err.Error())
+			SendErrorResult(res, ErrNotValid)
+			return
+		} else if logoutRequest.Issuer == nil || logoutRequest.Issuer.Value != sp.IDPMetadata.EntityID {
+			Log.Warning("plg_authenticate_saml::slo err=invalid_issuer")
+			SendErrorResult(res, ErrNotAllowed)
+			return
+		}
+		revokeSession(req)
+		clearSession(res)
+		if sp.GetSLOBindingLocation(saml.HTTPRedirectBinding) == "" {
+			http.Redirect(res, req, WithBase("/"), http.StatusSeeOther)
+			return
+		}
+		u, err := sp.MakeRedirectLogoutResponse(logoutRequest.ID, req.Form.Get("RelayState"))
+		if err != nil {
+			SendErrorResult(res, err)
+			return
+		}
+		http.Redirect(res, req, u.String(), http.StatusSeeOther)
+		return
+	} else if req.Form.Get("SAMLResponse") != "" {
+		if err := sp.ValidateLogoutResponseRequest(req); err != nil {
+			Log.Debug("plg_authenticate_saml::slo action=logout_response err=%s", err.Error())
+		}
+		http.Redirect(res, req, WithBase("/"), http.StatusSeeOther)
+		return
+	}
+
+	nameID := ""
+	if c, err := req.Cookie(COOKIE_NAME_SAML); err == nil {
+		nameID, _ = DecryptString(SECRET_KEY_DERIVATE_FOR_USER, c.Value)
+	}
+	revokeSession(req)
+	clearSession(res)
+	if params["Single Logout"] != "enabled" || nameID == "" || sp.GetSLOBindingLocation(saml.HTTPRedirectBinding) == "" {
+		http.Redirect(res, req, WithBase("/"), http.StatusSeeOther)
+		return
+	}
+	u, err := sp.MakeRedirectLogoutRequest(nameID, "")
+	if err != nil {
+		SendErrorResult(res, err)
+		return
+	}
+	http.Redirect(res, req, u.String(), http.StatusSeeOther)
+}
+
+func getParams() (map[string]string, error) {
+	if Config.Get("middleware.identity_provider.type").String() != "saml" {
+		return nil, ErrNotFound
+	}
+	params := map[string]string{}
+	if err := json.Unmarshal(
+		[]byte(Config.Get("middleware.identity_provider.params").String()),
+		&params,
+	); err != nil {
+		return nil, ErrNotValid
+	}
+	return params, nil
+}
+
+// parseLogoutRequest gives the logout request sent by the IDP once its signature has been checked
+// against the certificates of the IDP metadata. Without it anyone could end the session of anyone
+// else, hence unsigned requests are rejected. With the redirect binding the signature comes in the
+// query string, with the POST binding it is part of the XML document
+func parseLogoutRequest(sp *saml.ServiceProvider, req *http.Request, data string) (*saml.LogoutRequest, error) {
+	certs, err := idpSigningCerts(sp)
+	if err != nil {
+		return nil, err
+	}
+	b, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, err
+	}
+	if req.Method == http.MethodGet {
+		if err = verifyRedirectSignature(req, certs); err != nil {
+			return nil, err
+		}
+		if b, err = io.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(b)), 1<<20)); err != nil {
+			return nil, err
+		}
+	} else if b, err = verifyPostSignature(b, certs); err != nil {
+		return nil, err
+	}
+	logoutRequest := &saml.LogoutRequest{}
+	if err = xml.Unmarshal(b, logoutRequest); err != nil {
+		return nil, err
+	}
+	return logoutRequest, nil
+}
+
+// verifyRedirectSignature checks the signature of the redirect binding, computed over the query
+// parameters as they were sent: "SAMLRequest=...&RelayState=...&SigAlg=..."
+func verifyRedirectSignature(req *http.Request, certs []*x509.Certificate) error {
+	raw := map[string]string{}
+	for _, part := range strings.Split(req.URL.RawQuery, "&") {
+		if k, v, ok := strings.Cut(part, "="); ok {
+			raw[k] = v
+		}
+	}
+	query := req.URL.Query()
+	signature, err := base64.StdEncoding.DecodeString(query.Get("Signature"))
+	if err != nil {
+		return err
+	} else if len(signature) == 0 || raw["SigAlg"] == "" {
+		return fmt.Errorf("unsigned logout request")
+	}
+	signed := "SAMLRequest=" + raw["SAMLRequest"]
+	if _, ok := raw["RelayState"]; ok {
+		signed += "&RelayState=" + raw["RelayState"]
+	}
+	signed += "&SigAlg=" + raw["SigAlg"]
+
+	var hash crypto.Hash
+	switch query.Get("SigAlg") {
+	case "http://www.w3.org/2000/09/xmldsig#rsa-sha1", "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha1":
+		hash = crypto.SHA1
+	case "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256", "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha256":
+		hash = crypto.SHA256
+	case "http://www.w3.org/2001/04/xmldsig-more#rsa-sha512", "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported signature algorithm")
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+	for _, cert := range certs {
+		switch key := cert.PublicKey.(type) {
+		case *rsa.PublicKey:
+			if rsa.VerifyPKCS1v15(key, hash, digest, signature) == nil {
+				return nil
+			}
+		case *ecdsa.PublicKey:
+			if ecdsa.VerifyASN1(key, digest, signature) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("invalid signature")
+}
+
+// verifyPostSignature checks the enveloped signature of the POST binding and gives back the part
+// of the document the signature covers, which is the only one that can be trusted
+func verifyPostSignature(b []byte, certs []*x509.Certificate) ([]byte, error) {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(b); err != nil {
+		return nil, err
+	} else if doc.Root() == nil {
+		return nil, fmt.Errorf("empty logout request")
+	} else if doc.Root().FindElement("./Signature") == nil {
+		return nil, fmt.Errorf("unsigned logout request")
+	}
+	ctx := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{Roots: certs})
+	ctx.IdAttribute = "ID"
+	el, err := ctx.Validate(doc.Root())
+	if err != nil {
+		return nil, err
+	}
+	signed := etree.NewDocument()
+	signed.SetRoot(el)
+	return signed.WriteToBytes()
+}
+
+// revokeSession takes the session out of the registry, removing the cookie isn't enough to stop
+// what is still running in the background on behalf of the user
+func revokeSession(req *http.Request) {
+	if model.SessionRegistryEnabled() == false {
+		return
+	}
+	token := ""
+	for i := 0; ; i++ {
+		c, err := req.Cookie(CookieName(i))
+		if err != nil {
+			break
+		}
+		token += c.Value
+	}
+	if token == "" {
+		return
+	}
+	session, err := model.SessionDecrypt(token)
+	if err != nil || session["sid"] == "" {
+		return
+	} else if err = model.SessionRegistryRevoke(session["sid"]); err != nil {
+		Log.Debug("plg_authenticate_saml::slo action=revoke err=%s", err.Error())
+	}
+}
+
+func clearSession(res http.ResponseWriter) {
+	for i := 0; i < 10; i++ {
+		http.SetCookie(res, &http.Cookie{
+			Name:   CookieName(i),
+			Value:  "",
+			MaxAge: -1,
+			Path:   COOKIE_PATH,
+		})
+	}
+	http.SetCookie(res, &http.Cookie{
+		Name:   COOKIE_NAME_SAML,
+		Value:  "",
+		MaxAge: -1,
+		Path:   WithBase("/saml/"),
+	})
+}
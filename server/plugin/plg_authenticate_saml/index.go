@@ -1,12 +1,26 @@
 package plg_authenticate_saml
 
 import (
-	. "github.com/mickael-kerjean/filestash/server/common"
+	"encoding/base64"
+	"fmt"
+	"html"
 	"net/http"
+	"strings"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+
+	"github.com/gorilla/mux"
+	"github.com/mickael-kerjean/saml"
 )
 
 func init() {
 	Hooks.Register.AuthenticationMiddleware("saml", Saml{})
+	Hooks.Register.HttpEndpoint(func(r *mux.Router) error {
+		r.HandleFunc(WithBase("/saml/metadata"), MetadataHandler).Methods("GET")
+		r.HandleFunc(WithBase("/saml/acs"), AcsHandler).Methods("POST")
+		r.HandleFunc(WithBase("/saml/slo"), SloHandler).Methods("GET", "POST")
+		return nil
+	})
 }
 
 type Saml struct{}
@@ -29,7 +43,7 @@ func (this Saml) Setup() Form {
 				Name:        "IDP Metadata",
 				Type:        "long_text",
 				Value:       "",
-				Placeholder: "Paste the metadata from your IDP",
+				Placeholder: "Paste the metadata from your IDP or the URL where to find it",
 				Description: `if your IDP asks for some information before giving the metadata file, use these:
 - entityID: http://localhost:8334/saml/metadata
 - assertionConsumerService (acs): http://localhost:8334/saml/acs
@@ -43,19 +57,151 @@ func (this Saml) Setup() Form {
 				Placeholder: "visit: /saml/metadata",
 				Description: "The metadata file will be available under /saml/metadata once you've entered a valid IDP metadata which should come from your IDP",
 			},
+			{
+				Name:        "Binding",
+				Type:        "select",
+				Value:       "redirect",
+				Opts:        []string{"redirect", "post"},
+				Description: "How the authentication request is sent to the IDP: 'redirect' uses the HTTP-Redirect binding and 'post' the HTTP-POST binding",
+			},
+			{
+				Name:        "Single Logout",
+				Type:        "select",
+				Value:       "disabled",
+				Opts:        []string{"disabled", "enabled"},
+				Description: "When enabled, visiting /saml/slo ends both the Filestash session and the session with the IDP. Logout requests coming from the IDP are honored either way",
+			},
 		},
 	}
 }
 
 func (this Saml) EntryPoint(idpParams map[string]string, req *http.Request, res http.ResponseWriter) error {
-	http.Redirect(
-		res, req,
-		"https://www.filestash.app/purchase-enterprise-selfhosted.html",
-		http.StatusTemporaryRedirect,
-	)
+	if idpParams["ssoref"] == "" {
+		return ErrNotValid
+	}
+	origin := requestOrigin(req)
+	sp, err := newServiceProvider(idpParams["IDP Metadata"], origin)
+	if err != nil {
+		return err
+	}
+	binding := saml.HTTPRedirectBinding
+	if idpParams["Binding"] == "post" {
+		binding = saml.HTTPPostBinding
+	}
+	location := sp.GetSSOBindingLocation(binding)
+	if location == "" {
+		return NewError("The IDP metadata doesn't support the "+idpParams["Binding"]+" binding", 400)
+	}
+	// the request is always created as if it was a redirect so it only gets signed once its ID
+	// is bound to the ssoref cookie
+	authnRequest, err := sp.MakeAuthenticationRequest(location, saml.HTTPRedirectBinding, saml.HTTPPostBinding)
+	if err != nil {
+		return err
+	}
+	authnRequest.ID = requestID(idpParams["ssoref"])
+	pendingOrigin.Set(map[string]string{"id": authnRequest.ID}, origin)
+
+	if binding == saml.HTTPRedirectBinding {
+		u, err := authnRequest.Redirect("", sp)
+		if err != nil {
+			return err
+		}
+		http.Redirect(res, req, u.String(), http.StatusSeeOther)
+		return nil
+	}
+	if err = sp.SignAuthnRequest(authnRequest); err != nil {
+		return err
+	}
+	res.Header().Set("Content-Type", "text/html; charset=utf-8")
+	res.WriteHeader(http.StatusOK)
+	res.Write([]byte(Page(string(authnRequest.Post("")))))
 	return nil
 }
 
 func (this Saml) Callback(formData map[string]string, idpParams map[string]string, res http.ResponseWriter) (map[string]string, error) {
-	return nil, ErrNotImplemented
+	if formData["SAMLResponse"] == "" {
+		return nil, ErrNotValid
+	} else if idpParams["ssoref"] == "" {
+		Log.Warning("plg_authenticate_saml::callback err=missing_ssoref")
+		return nil, ErrNotAllowed
+	}
+	id := requestID(idpParams["ssoref"])
+	origin, _ := pendingOrigin.Get(map[string]string{"id": id}).(string)
+	if origin == "" {
+		if origin = requestOrigin(nil); origin == "" {
+			Log.Warning("plg_authenticate_saml::callback err=unknown_origin")
+			return nil, ErrNotAllowed
+		}
+	}
+	pendingOrigin.Del(map[string]string{"id": id})
+	sp, err := newServiceProvider(idpParams["IDP Metadata"], origin)
+	if err != nil {
+		return nil, err
+	}
+	rawResponse, err := base64.StdEncoding.DecodeString(formData["SAMLResponse"])
+	if err != nil {
+		return nil, ErrNotValid
+	}
+	assertion, err := sp.ParseXMLResponse(rawResponse, []string{id})
+	if err != nil {
+		if e, ok := err.(*saml.InvalidResponseError); ok {
+			err = e.PrivateErr
+		}
+		Log.Warning("plg_authenticate_saml::callback action=parse err=%s", err.Error())
+		return nil, ErrNotAllowed
+	} else if assertion.Issuer.Value != sp.IDPMetadata.EntityID {
+		Log.Warning("plg_authenticate_saml::callback err=invalid_issuer issuer=%s", assertion.Issuer.Value)
+		return nil, ErrNotAllowed
+	}
+
+	out := map[string]string{}
+	if assertion.Subject != nil && assertion.Subject.NameID != nil {
+		out["nameid"] = assertion.Subject.NameID.Value
+	}
+	for _, statement := range assertion.AuthnStatements {
+		if statement.SessionIndex != "" {
+			out["session_index"] = statement.SessionIndex
+		}
+	}
+	for _, statement := range assertion.AttributeStatements {
+		for _, attribute := range statement.Attributes {
+			values := make([]string, 0, len(attribute.Values))
+			for _, v := range attribute.Values {
+				values = append(values, v.Value)
+			}
+			out[attribute.Name] = strings.Join(values, ", ")
+			if attribute.FriendlyName != "" {
+				out[attribute.FriendlyName] = out[attribute.Name]
+			}
+		}
+	}
+	if out["nameid"] != "" {
+		if v, err := EncryptString(SECRET_KEY_DERIVATE_FOR_USER, out["nameid"]); err == nil {
+			http.SetCookie(res, &http.Cookie{
+				Name:     COOKIE_NAME_SAML,
+				Value:    v,
+				MaxAge:   60 * Config.Get("general.cookie_timeout").Int(),
+				Path:     WithBase("/saml/"),
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+	}
+	return out, nil
+}
+
+// relayPage sends the response of the IDP onto the authentication middleware. As the IDP posts
+// from another site, the browser would not send the ssoref cookie if it was to target it directly
+func relayPage(samlResponse string, relayState string) string {
+	return Page(fmt.Sprintf(`
+        <form action="%s" method="post">
+            <input type="hidden" name="SAMLResponse" value="%s" />
+            <input type="hidden" name="RelayState" value="%s" />
+            <noscript><button>CONTINUE</button></noscript>
+        </form>
+        <script>document.querySelector("form").submit();</script>`,
+		WithBase("/api/session/auth/"),
+		html.EscapeString(samlResponse),
+		html.EscapeString(relayState),
+	))
 }
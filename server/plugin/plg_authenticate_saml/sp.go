@@ -0,0 +1,163 @@
+package plg_authenticate_saml
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+	"github.com/mickael-kerjean/filestash/server/common/ssl"
+
+	"github.com/mickael-kerjean/saml"
+)
+
+const (
+	COOKIE_NAME_SAML = "saml"
+	SIGNATURE_METHOD = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+)
+
+var (
+	// pendingOrigin remembers the origin of the flows in progress as the SP has to be the
+	// exact same one when checking the response of the IDP
+	pendingOrigin = NewAppCache(10, 20)
+	metadataCache = NewAppCache(60, 120)
+)
+
+func newServiceProvider(idpMetadata string, origin string) (*saml.ServiceProvider, error) {
+	key, _, err := ssl.GetPrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	root, err := ssl.GetRoot()
+	if err != nil {
+		return nil, err
+	}
+	cert, _, err := ssl.GetCertificate(key, root)
+	if err != nil {
+		return nil, err
+	}
+	metadataURL, _ := url.Parse(origin + WithBase("/saml/metadata"))
+	acsURL, _ := url.Parse(origin + WithBase("/saml/acs"))
+	sloURL, _ := url.Parse(origin + WithBase("/saml/slo"))
+	sp := &saml.ServiceProvider{
+		EntityID:          metadataURL.String(),
+		Key:               key,
+		Certificate:       cert,
+		HTTPClient:        &HTTPClient,
+		MetadataURL:       *metadataURL,
+		AcsURL:            *acsURL,
+		SloURL:            *sloURL,
+		AllowIDPInitiated: false,
+		SignatureMethod:   SIGNATURE_METHOD,
+		LogoutBindings:    []string{saml.HTTPRedirectBinding, saml.HTTPPostBinding},
+	}
+	if idpMetadata == "" {
+		return sp, nil
+	}
+	if sp.IDPMetadata, err = parseIDPMetadata(idpMetadata); err != nil {
+		Log.Warning("plg_authenticate_saml::metadata err=%s", err.Error())
+		return nil, NewError("Invalid IDP Metadata", 500)
+	}
+	return sp, nil
+}
+
+// parseIDPMetadata accepts either the metadata of the IDP or the URL where to find it
+func parseIDPMetadata(metadata string) (*saml.EntityDescriptor, error) {
+	metadata = strings.TrimSpace(metadata)
+	if strings.HasPrefix(metadata, "http://") || strings.HasPrefix(metadata, "https://") {
+		cacheKey := map[string]string{"url": metadata}
+		if cached, ok := metadataCache.Get(cacheKey).(string); ok {
+			metadata = cached
+		} else {
+			resp, err := HTTPClient.Get(metadata)
+			if err != nil {
+				return nil, err
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+			}
+			b, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+			if err != nil {
+				return nil, err
+			}
+			metadataCache.Set(cacheKey, string(b))
+			metadata = string(b)
+		}
+	}
+	entity := &saml.EntityDescriptor{}
+	if err := xml.Unmarshal([]byte(metadata), entity); err == nil && len(entity.IDPSSODescriptors) > 0 {
+		return entity, nil
+	}
+	entities := &saml.EntitiesDescriptor{}
+	if err := xml.Unmarshal([]byte(metadata), entities); err != nil {
+		return nil, err
+	}
+	for i := range entities.EntityDescriptors {
+		if len(entities.EntityDescriptors[i].IDPSSODescriptors) > 0 {
+			return &entities.EntityDescriptors[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no IDPSSODescriptor found")
+}
+
+// idpSigningCerts are the certificates the IDP signs its messages with, as found in its metadata
+func idpSigningCerts(sp *saml.ServiceProvider) ([]*x509.Certificate, error) {
+	certs := []*x509.Certificate{}
+	for _, descriptor := range sp.IDPMetadata.IDPSSODescriptors {
+		for _, key := range descriptor.KeyDescriptors {
+			if key.Use != "" && key.Use != "signing" {
+				continue
+			}
+			for _, c := range key.KeyInfo.X509Data.X509Certificates {
+				b, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(c.Data), ""))
+				if err != nil {
+					return nil, err
+				}
+				cert, err := x509.ParseCertificate(b)
+				if err != nil {
+					return nil, err
+				}
+				certs = append(certs, cert)
+			}
+		}
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no signing certificate in the IDP metadata")
+	}
+	return certs, nil
+}
+
+// requestID is the ID of the authentication request, derived from the ssoref cookie so the
+// response of the IDP can only be used by the browser which initiated the flow
+func requestID(ssoref string) string {
+	mac := hmac.New(sha256.New, []byte(SECRET_KEY))
+	mac.Write([]byte("saml::" + ssoref))
+	return "id-" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// requestOrigin is where the IDP can reach Filestash. It comes from the configured host when
+// there is one, from the request otherwise
+func requestOrigin(req *http.Request) string {
+	if host := Config.Get("general.host").String(); host != "" {
+		if strings.HasPrefix(host, "http://") || strings.HasPrefix(host, "https://") {
+			return strings.TrimRight(host, "/")
+		} else if Config.Get("general.force_ssl").Bool() {
+			return "https://" + host
+		}
+		return "http://" + host
+	} else if req == nil {
+		return ""
+	} else if req.TLS != nil || req.Header.Get("X-Forwarded-Proto") == "https" {
+		return "https://" + req.Host
+	}
+	return "http://" + req.Host
+}
@@ -35,6 +35,11 @@ func (this AuthM) Mv(ctx *App, from string, to string) error {
 	return ErrNotAllowed
 }
 
+func (this AuthM) Cp(ctx *App, from string, to string) error {
+	Log.Stdout("CP %+v", ctx.Session)
+	return ErrNotAllowed
+}
+
 func (this AuthM) Save(ctx *App, path string) error {
 	Log.Stdout("SAVE %+v", ctx.Session)
 	return ErrNotAllowed
@@ -374,6 +374,7 @@ func (this S3Backend) Rm(path string) error {
 	jobChan := make(chan S3Path, this.threadSize)
 	errChan := make(chan error, this.threadSize)
 	ctx, cancel := context.WithCancel(this.Context)
+	defer cancel()
 	var wg sync.WaitGroup
 	for i := 1; i <= this.threadSize; i++ {
 		wg.Add(1)
@@ -431,6 +432,17 @@ func (this S3Backend) Mv(from string, to string) error {
 	if from == to {
 		return nil
 	}
+	return this.copyObjects(from, to, true)
+}
+
+func (this S3Backend) Cp(from string, to string) error {
+	if from == to {
+		return nil
+	}
+	return this.copyObjects(from, to, false)
+}
+
+func (this S3Backend) copyObjects(from string, to string, removeSource bool) error {
 	f := this.path(from)
 	t := this.path(to)
 	client := s3.New(this.createSession(f.bucket))
@@ -444,12 +456,11 @@ func (this S3Backend) Mv(from string, to string) error {
 	if err != nil {
 		return err
 	}
-	// CASE 2: Rename/Move a file
-	if finfo.IsDir() == false {
+	copyObject := func(src S3Path, dst S3Path) error {
 		input := &s3.CopyObjectInput{
-			CopySource: aws.String(fmt.Sprintf("%s/%s", f.bucket, f.path)),
-			Bucket:     aws.String(t.bucket),
-			Key:        aws.String(t.path),
+			CopySource: aws.String(fmt.Sprintf("%s/%s", src.bucket, src.path)),
+			Bucket:     aws.String(dst.bucket),
+			Key:        aws.String(dst.path),
 		}
 		if this.params["encryption_key"] != "" {
 			input.CopySourceSSECustomerAlgorithm = aws.String("AES256")
@@ -457,20 +468,26 @@ func (this S3Backend) Mv(from string, to string) error {
 			input.SSECustomerAlgorithm = aws.String("AES256")
 			input.SSECustomerKey = aws.String(this.params["encryption_key"])
 		}
-		_, err := client.CopyObject(input)
-		if err != nil {
+		if _, err := client.CopyObject(input); err != nil {
 			return err
+		} else if removeSource == false {
+			return nil
 		}
-		_, err = client.DeleteObject(&s3.DeleteObjectInput{
-			Bucket: aws.String(f.bucket),
-			Key:    aws.String(f.path),
+		_, err := client.DeleteObject(&s3.DeleteObjectInput{
+			Bucket: aws.String(src.bucket),
+			Key:    aws.String(src.path),
 		})
 		return err
 	}
-	// CASE 3: Rename/Move a folder
+	// CASE 2: Rename/Move/Copy a file
+	if finfo.IsDir() == false {
+		return copyObject(f, t)
+	}
+	// CASE 3: Rename/Move/Copy a folder
 	jobChan := make(chan []S3Path, this.threadSize)
 	errChan := make(chan error, this.threadSize)
 	ctx, cancel := context.WithCancel(this.Context)
+	defer cancel()
 	var wg sync.WaitGroup
 	for i := 1; i <= this.threadSize; i++ {
 		wg.Add(1)
@@ -479,28 +496,7 @@ func (this S3Backend) Mv(from string, to string) error {
 				if ctx.Err() != nil {
 					continue
 				}
-				input := &s3.CopyObjectInput{
-					CopySource: aws.String(fmt.Sprintf("%s/%s", spath[0].bucket, spath[0].path)),
-					Bucket:     aws.String(spath[1].bucket),
-					Key:        aws.String(spath[1].path),
-				}
-				if this.params["encryption_key"] != "" {
-					input.CopySourceSSECustomerAlgorithm = aws.String("AES256")
-					input.CopySourceSSECustomerKey = aws.String(this.params["encryption_key"])
-					input.SSECustomerAlgorithm = aws.String("AES256")
-					input.SSECustomerKey = aws.String(this.params["encryption_key"])
-				}
-				_, err := client.CopyObject(input)
-				if err != nil {
-					cancel()
-					errChan <- err
-					continue
-				}
-				_, err = client.DeleteObject(&s3.DeleteObjectInput{
-					Bucket: aws.String(spath[0].bucket),
-					Key:    aws.String(spath[0].path),
-				})
-				if err != nil {
+				if err := copyObject(spath[0], spath[1]); err != nil {
 					cancel()
 					errChan <- err
 					continue
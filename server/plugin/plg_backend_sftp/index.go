@@ -319,6 +319,39 @@ func (b Sftp) Mv(from string, to string) error {
 	return b.err(err)
 }
 
+func (b Sftp) Cp(from string, to string) error {
+	// sftp doesn't have a copy primitive, we rely on the remote shell when the server gives us
+	// a posix one. Anything else, eg: a server forcing internal-sftp, a windows server or a
+	// restricted shell, is reported as not supported so the caller streams the content instead
+	session, err := b.SSHClient.NewSession()
+	if err != nil {
+		return ErrNotSupported
+	}
+	defer session.Close()
+	quote := func(s string) string {
+		return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+	}
+	const marker = "filestash::cp::ok"
+	cmd := "cp -- " + quote(from) + " " + quote(to)
+	if IsDirectory(from) {
+		// copying the content of the folder makes for the same result whether the
+		// destination already exists or not
+		cmd = "cp -R -- " + quote(from+".") + " " + quote(strings.TrimSuffix(to, "/"))
+	}
+	out, err := session.CombinedOutput(cmd + " && echo " + marker)
+	if err != nil {
+		Log.Debug("plg_backend_sftp::cp action=exec err=%s out=%s", err.Error(), string(out))
+		return ErrNotSupported
+	} else if strings.Contains(string(out), marker) == false {
+		Log.Debug("plg_backend_sftp::cp action=exec err=no+shell")
+		return ErrNotSupported
+	} else if _, err = b.SFTPClient.Stat(to); err != nil {
+		Log.Debug("plg_backend_sftp::cp action=stat err=%s", err.Error())
+		return ErrNotSupported
+	}
+	return nil
+}
+
 func (b Sftp) Touch(path string) error {
 	file, err := b.SFTPClient.OpenFile(path, os.O_WRONLY|os.O_CREATE)
 	if err != nil {
@@ -4,12 +4,15 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"encoding/xml"
+	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"text/template"
+	"time"
 
 	. "github.com/mickael-kerjean/filestash/server/common"
 	"github.com/mickael-kerjean/filestash/server/ctrl"
@@ -28,6 +31,7 @@ var WOPIRoutes = func(r *mux.Router) error {
 		),
 	).Methods("GET")
 	r.HandleFunc("/api/wopi/files/{path64}", WOPIHandler_CheckFileInfo).Methods("GET")
+	r.HandleFunc("/api/wopi/files/{path64}", WOPIHandler_Files).Methods("POST")
 	r.HandleFunc("/api/wopi/files/{path64}/contents", WOPIHandler_GetFile).Methods("GET")
 	r.HandleFunc("/api/wopi/files/{path64}/contents", WOPIHandler_PutFile).Methods("POST")
 	return nil
@@ -48,14 +52,31 @@ func WOPIHandler_CheckFileInfo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	WOPIExecute(w, r)(func(ctx *App, fullpath string, w http.ResponseWriter) {
+		userID, userName, isAnonymous := userInfo(ctx)
+		info := map[string]any{
+			"BaseFileName":            filepath.Base(fullpath),
+			"OwnerId":                 GenerateID(ctx.Session),
+			"UserId":                  userID,
+			"UserFriendlyName":        userName,
+			"UserCanWrite":            model.CanEdit(ctx),
+			"UserCanRename":           model.CanEdit(ctx),
+			"UserCanNotWriteRelative": model.CanUpload(ctx) == false,
+			"SupportsLocks":           true,
+			"SupportsGetLock":         true,
+			"SupportsUpdate":          true,
+			"SupportsRename":          true,
+			"IsAdminUser":             false,
+			"IsAnonymousUser":         isAnonymous,
+		}
+		if f, err := ctx.Backend.Stat(fullpath); err == nil {
+			info["Size"] = f.Size()
+			info["Version"] = strconv.FormatInt(f.ModTime().UnixNano(), 10)
+			info["LastModifiedTime"] = f.ModTime().UTC().Format(time.RFC3339)
+		} else {
+			Log.Debug("plg_editor_wopi::checkfileinfo action=stat err=%s", err.Error())
+		}
 		w.Header().Set("Content-Type", "application/json")
-		if err := json.NewEncoder(w).Encode(map[string]any{
-			"BaseFileName":     filepath.Base(fullpath),
-			"UserFriendlyName": "Unknown",
-			"UserCanWrite":     model.CanEdit(ctx),
-			"IsAdminUser":      false,
-			"IsAnonymousUser":  true,
-		}); err != nil {
+		if err := json.NewEncoder(w).Encode(info); err != nil {
 			SendErrorResult(w, err)
 			return
 		}
@@ -69,6 +90,7 @@ func WOPIHandler_GetFile(w http.ResponseWriter, r *http.Request) {
 			SendErrorResult(w, err)
 			return
 		}
+		defer f.Close()
 		io.Copy(w, f)
 	})
 }
@@ -76,15 +98,248 @@ func WOPIHandler_GetFile(w http.ResponseWriter, r *http.Request) {
 func WOPIHandler_PutFile(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 	WOPIExecute(w, r)(func(ctx *App, fullpath string, w http.ResponseWriter) {
+		if model.CanEdit(ctx) == false {
+			SendErrorResult(w, ErrPermissionDenied)
+			return
+		}
+		// saving a file which isn't locked is tolerated, not every office server uses locks
+		if current, ok := locks.Allows(lockKey(ctx, fullpath), r.Header.Get("X-WOPI-Lock")); !ok {
+			lockConflict(w, current, "the file is locked by somebody else")
+			return
+		}
+		if err := authorise(ctx, "save", fullpath, ""); err != nil {
+			SendErrorResult(w, err)
+			return
+		}
 		err := ctx.Backend.Save(fullpath, r.Body)
 		if err != nil {
 			SendErrorResult(w, err)
 			return
 		}
+		if f, err := ctx.Backend.Stat(fullpath); err == nil {
+			w.Header().Set("X-WOPI-ItemVersion", strconv.FormatInt(f.ModTime().UnixNano(), 10))
+		}
 		SendSuccessResult(w, nil)
 	})
 }
 
+// WOPIHandler_Files takes care of the operations sent onto the file endpoint which are
+// identified by the X-WOPI-Override header
+func WOPIHandler_Files(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	WOPIExecute(w, r)(func(ctx *App, fullpath string, w http.ResponseWriter) {
+		key := lockKey(ctx, fullpath)
+		lock := r.Header.Get("X-WOPI-Lock")
+		if len(lock) > WOPI_LOCK_MAX_LENGTH {
+			SendErrorResult(w, NewError("Lock is too long", 400))
+			return
+		}
+		switch r.Header.Get("X-WOPI-Override") {
+		case "LOCK":
+			if model.CanEdit(ctx) == false {
+				SendErrorResult(w, ErrPermissionDenied)
+				return
+			} else if lock == "" {
+				SendErrorResult(w, NewError("Missing lock", 400))
+				return
+			}
+			if current, ok := locks.Lock(key, lock, r.Header.Get("X-WOPI-OldLock")); !ok {
+				lockConflict(w, current, "the file is locked by somebody else")
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		case "REFRESH_LOCK":
+			if current, ok := locks.Refresh(key, lock); !ok {
+				lockConflict(w, current, "lock mismatch")
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		case "UNLOCK":
+			if current, ok := locks.Unlock(key, lock); !ok {
+				lockConflict(w, current, "lock mismatch")
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		case "GET_LOCK":
+			current, _ := locks.Get(key)
+			w.Header().Set("X-WOPI-Lock", current)
+			w.WriteHeader(http.StatusOK)
+		case "PUT_RELATIVE":
+			putRelativeFile(ctx, fullpath, w, r)
+		case "RENAME_FILE":
+			renameFile(ctx, fullpath, w, r)
+		default:
+			w.WriteHeader(http.StatusNotImplemented)
+		}
+	})
+}
+
+// putRelativeFile implements the "Save As" of the office suite. The new file is created next
+// to the current one and the office server receives a WOPI url to continue working from it
+func putRelativeFile(ctx *App, fullpath string, w http.ResponseWriter, r *http.Request) {
+	if model.CanUpload(ctx) == false {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+	suggested := decodeUTF7(r.Header.Get("X-WOPI-SuggestedTarget"))
+	relative := decodeUTF7(r.Header.Get("X-WOPI-RelativeTarget"))
+	if (suggested == "") == (relative == "") {
+		SendErrorResult(w, NewError("Either X-WOPI-SuggestedTarget or X-WOPI-RelativeTarget is required", 400))
+		return
+	}
+	dir := filepath.Dir(fullpath) + "/"
+	var name string
+	if suggested != "" {
+		// the suggested target can be adjusted to avoid overwriting an existing file
+		name = suggested
+		if strings.HasPrefix(suggested, ".") {
+			name = strings.TrimSuffix(filepath.Base(fullpath), filepath.Ext(fullpath)) + suggested
+		}
+		if isValidName(name) == false {
+			SendErrorResult(w, NewError("Invalid file name", 400))
+			return
+		}
+		name = availableName(ctx, dir, name)
+	} else {
+		// the relative target must be used as is
+		name = relative
+		if isValidName(name) == false {
+			SendErrorResult(w, NewError("Invalid file name", 400))
+			return
+		}
+		if _, err := ctx.Backend.Stat(dir + name); err == nil {
+			if current, locked := locks.Get(lockKey(ctx, dir+name)); locked {
+				lockConflict(w, current, "the target file is locked")
+				return
+			} else if r.Header.Get("X-WOPI-OverwriteRelativeTarget") != "true" || model.CanEdit(ctx) == false {
+				w.Header().Set("X-WOPI-ValidRelativeTarget", encodeUTF7(availableName(ctx, dir, name)))
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+		}
+	}
+	if err := authorise(ctx, "save", dir+name, ""); err != nil {
+		SendErrorResult(w, err)
+		return
+	}
+	if err := ctx.Backend.Save(dir+name, r.Body); err != nil {
+		SendErrorResult(w, err)
+		return
+	}
+
+	u, err := url.Parse(wopiSrc(ctx, filepath.Dir(r.URL.Query().Get("path"))+"/"+name))
+	if err != nil {
+		SendErrorResult(w, err)
+		return
+	}
+	q := u.Query()
+	q.Set("access_token", r.URL.Query().Get("access_token"))
+	u.RawQuery = q.Encode()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"Name": name,
+		"Url":  u.String(),
+	})
+}
+
+func renameFile(ctx *App, fullpath string, w http.ResponseWriter, r *http.Request) {
+	if model.CanEdit(ctx) == false {
+		SendErrorResult(w, ErrPermissionDenied)
+		return
+	}
+	requested := decodeUTF7(r.Header.Get("X-WOPI-RequestedName"))
+	ext := filepath.Ext(fullpath)
+	to := filepath.Dir(fullpath) + "/" + requested + ext
+	if isValidName(requested) == false {
+		w.Header().Set("X-WOPI-InvalidFileNameError", "Invalid file name")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	} else if current, ok := locks.Allows(lockKey(ctx, fullpath), r.Header.Get("X-WOPI-Lock")); !ok {
+		lockConflict(w, current, "the file is locked by somebody else")
+		return
+	} else if _, err := ctx.Backend.Stat(to); err == nil {
+		w.Header().Set("X-WOPI-InvalidFileNameError", "A file with this name already exists")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err := authorise(ctx, "mv", fullpath, to); err != nil {
+		SendErrorResult(w, err)
+		return
+	}
+	if err := ctx.Backend.Mv(fullpath, to); err != nil {
+		SendErrorResult(w, err)
+		return
+	}
+	locks.Move(lockKey(ctx, fullpath), lockKey(ctx, to))
+	renamedFiles.Set(map[string]string{"id": GenerateID(ctx.Session), "path": fullpath}, to)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"Name": requested,
+	})
+}
+
+func lockConflict(w http.ResponseWriter, current string, reason string) {
+	w.Header().Set("X-WOPI-Lock", current)
+	w.Header().Set("X-WOPI-LockFailureReason", reason)
+	w.WriteHeader(http.StatusConflict)
+}
+
+func authorise(ctx *App, action string, from string, to string) error {
+	for _, auth := range Hooks.Get.AuthorisationMiddleware() {
+		var err error
+		switch action {
+		case "save":
+			err = auth.Save(ctx, from)
+		case "mv":
+			err = auth.Mv(ctx, from, to)
+		}
+		if err != nil {
+			Log.Info("plg_editor_wopi::auth action=%s err=%s", action, err.Error())
+			return ErrNotAuthorized
+		}
+	}
+	return nil
+}
+
+// userInfo is what the office server shows to the other people working on the same document
+func userInfo(ctx *App) (id string, name string, isAnonymous bool) {
+	if ctx.Share.Id != "" {
+		return "share::" + ctx.Share.Id, "Guest", true
+	}
+	id = GenerateID(ctx.Session)
+	attributes := map[string]string{}
+	if ctx.Session["session"] != "" { // extended session coming from the identity provider
+		json.Unmarshal([]byte(ctx.Session["session"]), &attributes)
+	}
+	for _, key := range []string{"username", "user", "name", "email", "uid", "nameid"} {
+		if ctx.Session[key] != "" {
+			return id, ctx.Session[key], false
+		} else if attributes[key] != "" {
+			return id, attributes[key], false
+		}
+	}
+	return id, "Unknown", false
+}
+
+func isValidName(name string) bool {
+	if name == "" || name == "." || name == ".." || len(name) > 255 {
+		return false
+	}
+	return strings.ContainsAny(name, "/\\\x00") == false
+}
+
+func availableName(ctx *App, dir string, name string) string {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 1; i < 100; i++ {
+		if _, err := ctx.Backend.Stat(dir + name); err != nil {
+			break
+		}
+		name = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+	return name
+}
+
 func WOPIExecute(w http.ResponseWriter, r *http.Request) func(func(*App, string, http.ResponseWriter)) {
 	return func(fn func(*App, string, http.ResponseWriter)) {
 		middleware.NewMiddlewareChain(
@@ -94,7 +349,7 @@ func WOPIExecute(w http.ResponseWriter, r *http.Request) func(func(*App, string,
 					SendErrorResult(w, err)
 					return
 				}
-				fn(ctx, fullpath, w)
+				fn(ctx, resolveRenamed(ctx, fullpath), w)
 			},
 			[]Middleware{wopiToCommonAPI, middleware.SessionStart},
 		).ServeHTTP(w, r)
@@ -260,23 +515,7 @@ func wopiDiscovery(ctx *App, fullpath string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	wopiSRC := origin()
-	if wopiSRC == "" {
-		wopiSRC := "http://"
-		if Config.Get("general.force_ssl").Bool() {
-			wopiSRC = "https://"
-		}
-		wopiSRC += Config.Get("general.host").String()
-	}
-	wopiSRC += "/api/wopi/files/"
-	wopiSRC += GenerateID(map[string]string{
-		"id":   GenerateID(ctx.Session),
-		"path": fullpath,
-	})
-	wopiSRC += "::" + base64.StdEncoding.EncodeToString([]byte(fullpath))
-	if ctx.Share.Id != "" {
-		wopiSRC += "::" + ctx.Share.Id
-	}
+	wopiSRC := wopiSrc(ctx, fullpath)
 	p := u.Query()
 	p.Set("WOPISrc", wopiSRC)
 	p.Set("access_token", ctx.Authorization)
@@ -292,3 +531,25 @@ func wopiDiscovery(ctx *App, fullpath string) (string, error) {
 	}
 	return u.String(), nil
 }
+
+// wopiSrc is the url the office server uses to reach a file
+func wopiSrc(ctx *App, path string) string {
+	src := origin()
+	if src == "" {
+		src = "http://"
+		if Config.Get("general.force_ssl").Bool() {
+			src = "https://"
+		}
+		src += Config.Get("general.host").String()
+	}
+	src += "/api/wopi/files/"
+	src += GenerateID(map[string]string{
+		"id":   GenerateID(ctx.Session),
+		"path": path,
+	})
+	src += "::" + base64.StdEncoding.EncodeToString([]byte(path))
+	if ctx.Share.Id != "" {
+		src += "::" + ctx.Share.Id
+	}
+	return src
+}
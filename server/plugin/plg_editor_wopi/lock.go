@@ -0,0 +1,154 @@
+package plg_editor_wopi
+
+import (
+	"sync"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+	"github.com/mickael-kerjean/filestash/server/model"
+)
+
+const (
+	WOPI_LOCK_DURATION   = 30 * time.Minute
+	WOPI_LOCK_MAX_LENGTH = 1024
+)
+
+// locks are shared with the rest of the application so a file opened in an office editor can't be
+// changed through webdav or the files API. As per the WOPI spec they expire after 30 minutes
+// unless refreshed
+var locks = &lockStore{}
+
+// renamedFiles keeps track of where a file went after being renamed as the file id we give to
+// the office server is made of its path and is not supposed to change
+var renamedFiles = NewAppCache(60*12, 60*24)
+
+type fileKey struct {
+	backend string
+	path    string
+	owner   string
+}
+
+type lockStore struct {
+	mu sync.Mutex
+}
+
+// Get returns the current lock of a file and whether the file is locked at all. A lock which
+// wasn't taken by the office server is locked with an empty value as its token is a secret of
+// the one holding it
+func (this *lockStore) Get(key fileKey) (string, bool) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	l, ok := this.get(key)
+	return this.value(l), ok
+}
+
+// Allows tells if the file can be changed by the holder of a lock, which is always the case when
+// nobody has locked it
+func (this *lockStore) Allows(key fileKey, value string) (string, bool) {
+	current, locked := this.Get(key)
+	return current, locked == false || (current != "" && current == value)
+}
+
+// Lock acquires or refreshes a lock. When oldLock is set, it implements UnlockAndRelock. It
+// returns the current lock when it fails
+func (this *lockStore) Lock(key fileKey, value string, oldLock string) (string, bool) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	current, locked := this.get(key)
+	if oldLock != "" {
+		if locked == false || this.value(current) != oldLock {
+			return this.value(current), false
+		} else if _, err := model.LockUpdate(key.backend, oldLock, value, WOPI_LOCK_DURATION); err != nil {
+			return this.value(current), false
+		}
+		return value, true
+	} else if locked && this.value(current) != value {
+		return this.value(current), false
+	} else if locked {
+		if _, err := model.LockRefresh(key.backend, value, WOPI_LOCK_DURATION); err != nil {
+			return this.value(current), false
+		}
+		return value, true
+	}
+	_, err := model.LockCreate(model.Lock{
+		Backend: key.backend,
+		Path:    key.path,
+		Token:   value,
+		Owner:   key.owner,
+		Source:  "wopi",
+	}, WOPI_LOCK_DURATION)
+	if err != nil {
+		current, _ = this.get(key)
+		return this.value(current), false
+	}
+	return value, true
+}
+
+func (this *lockStore) Refresh(key fileKey, value string) (string, bool) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	current, locked := this.get(key)
+	if locked == false || this.value(current) != value {
+		return this.value(current), false
+	} else if _, err := model.LockRefresh(key.backend, value, WOPI_LOCK_DURATION); err != nil {
+		return this.value(current), false
+	}
+	return value, true
+}
+
+func (this *lockStore) Unlock(key fileKey, value string) (string, bool) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	current, locked := this.get(key)
+	if locked == false || this.value(current) != value {
+		return this.value(current), false
+	} else if err := model.LockRelease(key.backend, value); err != nil {
+		return this.value(current), false
+	}
+	return "", true
+}
+
+func (this *lockStore) Move(from fileKey, to fileKey) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if err := model.LockMove(from.backend, from.path, to.path); err != nil {
+		Log.Warning("plg_editor_wopi::lock action=move err=%s", err.Error())
+	}
+}
+
+func (this *lockStore) get(key fileKey) (model.Lock, bool) {
+	current, err := model.LockFind(key.backend, key.path)
+	if err != nil {
+		Log.Warning("plg_editor_wopi::lock action=get err=%s", err.Error())
+		return model.Lock{}, false
+	} else if len(current) == 0 {
+		return model.Lock{}, false
+	}
+	return current[0], true
+}
+
+func (this *lockStore) value(l model.Lock) string {
+	if l.Source != "wopi" {
+		return ""
+	}
+	return l.Token
+}
+
+func lockKey(ctx *App, fullpath string) fileKey {
+	return fileKey{
+		backend: GenerateStorageID(ctx.Session),
+		path:    fullpath,
+		owner:   ctx.Session["user"],
+	}
+}
+
+func resolveRenamed(ctx *App, fullpath string) string {
+	for i := 0; i < 10; i++ {
+		to, ok := renamedFiles.Get(map[string]string{"id": GenerateID(ctx.Session), "path": fullpath}).(string)
+		if !ok {
+			break
+		}
+		fullpath = to
+	}
+	return fullpath
+}
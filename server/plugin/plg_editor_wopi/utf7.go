@@ -0,0 +1,80 @@
+package plg_editor_wopi
+
+import (
+	"encoding/base64"
+	"strings"
+	"unicode/utf16"
+)
+
+// the WOPI headers carrying file names are UTF-7 encoded (RFC 2152)
+
+func decodeUTF7(s string) string {
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '+' {
+			out.WriteByte(s[i])
+			continue
+		}
+		j := i + 1
+		for j < len(s) && isBase64Char(s[j]) {
+			j++
+		}
+		if j == i+1 {
+			out.WriteByte('+')
+			if j < len(s) && s[j] == '-' {
+				i = j
+			}
+			continue
+		}
+		b, err := base64.RawStdEncoding.DecodeString(s[i+1 : j])
+		if err != nil {
+			out.WriteString(s[i:j])
+		} else {
+			units := make([]uint16, 0, len(b)/2)
+			for k := 0; k+1 < len(b); k += 2 {
+				units = append(units, uint16(b[k])<<8|uint16(b[k+1]))
+			}
+			out.WriteString(string(utf16.Decode(units)))
+		}
+		if j < len(s) && s[j] == '-' {
+			i = j
+		} else {
+			i = j - 1
+		}
+	}
+	return out.String()
+}
+
+func encodeUTF7(s string) string {
+	var out strings.Builder
+	var pending []rune
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		units := utf16.Encode(pending)
+		b := make([]byte, 0, len(units)*2)
+		for _, u := range units {
+			b = append(b, byte(u>>8), byte(u))
+		}
+		out.WriteString("+" + base64.RawStdEncoding.EncodeToString(b) + "-")
+		pending = pending[:0]
+	}
+	for _, r := range s {
+		if r == '+' {
+			flush()
+			out.WriteString("+-")
+		} else if r >= 0x20 && r < 0x7f && r != '\\' && r != '~' {
+			flush()
+			out.WriteRune(r)
+		} else {
+			pending = append(pending, r)
+		}
+	}
+	flush()
+	return out.String()
+}
+
+func isBase64Char(c byte) bool {
+	return (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '+' || c == '/'
+}
@@ -38,17 +38,7 @@ func (this *Server) messageHandler(_ *App, w http.ResponseWriter, r *http.Reques
 func (this *Server) sseHandler(_ *App, w http.ResponseWriter, r *http.Request) {
 	token := ExtractToken(r)
 	if token == "" {
-		Log.Debug("plg_handler_mcp::sse msg=invalid_token")
-		w.Header().Add("Content-Type", "application/json")
-		w.Header().Add("WWW-Authenticate", "Bearer resource_metadata=\""+this.baseURL(r)+"/.well-known/oauth-protected-resource\"")
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(JSONRPCResponse{
-			JSONRPC: "2.0",
-			Error: &JSONRPCError{
-				Code:    http.StatusUnauthorized,
-				Message: "Missing or invalid access token",
-			},
-		})
+		this.sendUnauthorized(w, r)
 		return
 	}
 
@@ -65,139 +55,23 @@ func (this *Server) sseHandler(_ *App, w http.ResponseWriter, r *http.Request) {
 
 	fmt.Fprintf(w, "event: endpoint\ndata: %s?sessionId=%s\n\n", "/messages", userSession.Id)
 	w.(http.Flusher).Flush()
+	notifications := this.notifications(userSession.Id)
 
 	for {
 		select {
 		case request := <-userSession.Chan:
-			b, err := getBackend(userSession.Token)
-			if err != nil {
-				if err == ErrNotAuthorized {
-					err = JSONRPCError{
-						Code:    ErrNotAuthorized.Status(),
-						Message: "You aren't authenticated",
-					}
-				}
-				SendError(w, request.ID, err)
+			if request.Method == "" && userSession.Ping.ID == request.ID { // response to ping
+				userSession.Ping.LastResponse = time.Now()
+				userSession.Ping.ID += 1
 				break
 			}
-			userSession.Backend = b
-
-			switch request.Method {
-			case "initialize":
-				SendMessage(w, request.ID, InitializeResponse{
-					ProtocolVersion: "2024-11-05",
-					ServerInfo: ServerInfo{
-						Name:    "Universal Storage Server",
-						Version: "1.0.0",
-					},
-					Capabilities: Capabilities{
-						Tools:     map[string]interface{}{},
-						Resources: map[string]interface{}{},
-						Prompts:   map[string]interface{}{},
-					},
-				})
-			case "resources/list":
-				SendMessage(w, request.ID, &ResourcesListResponse{
-					Resources: AllResources(),
-				})
-			case "resources/templates/list":
-				SendMessage(w, request.ID, &ResourceTemplatesListResponse{
-					ResourceTemplates: AllResourceTemplates(),
-				})
-			case "resources/read":
-				if uri, ok := request.Params["uri"].(string); ok {
-					if resource, err := FindResource(uri); err != nil {
-						SendError(w, request.ID, JSONRPCError{
-							Code:    http.StatusBadRequest,
-							Message: fmt.Sprintf("Unknown tool: %s", request.Params["name"]),
-						})
-					} else {
-						SendMessage(w, request.ID, &ResourceReadResponse{
-							Contents: []ResourceContent{
-								{
-									URI:      uri,
-									MimeType: resource.MimeType,
-									Text:     resource.Content,
-									Meta:     resource.Meta,
-								},
-							},
-						})
-					}
-				} else {
-					SendError(w, request.ID, JSONRPCError{
-						Code:    http.StatusBadRequest,
-						Message: fmt.Sprintf("Unexpected parameters: %v", request.Params),
-					})
-				}
-				SendMessage(w, request.ID, &ResourceReadResponse{
-					Contents: ExecResourceRead(request.Params),
-				})
-			case "prompts/list":
-				SendMessage(w, request.ID, &PromptsListResponse{
-					Prompts: AllPrompts(),
-				})
-			case "prompts/get":
-				if m, ok := request.Params["name"].(string); ok {
-					res, err := ExecPromptGet(m, request.Params, &userSession)
-					if err == nil {
-						SendMessage(w, request.ID, PromptGetResponse{
-							Messages:    res,
-							Description: ExecPromptDescription(request.Params),
-						})
-					} else {
-						SendError(w, request.ID, err)
-					}
-				} else {
-					SendError(w, request.ID, JSONRPCError{
-						Code:    http.StatusBadRequest,
-						Message: fmt.Sprintf("Unexpected parameters: %v", request.Params),
-					})
-				}
-			case "tools/list":
-				SendMessage(w, request.ID, &ListToolsResponse{
-					Tools: AllTools(),
-				})
-			case "tools/call":
-				if tname, ok := request.Params["name"].(string); ok {
-					if tool, err := FindTool(tname); err != nil {
-						SendError(w, request.ID, JSONRPCError{
-							Code:    http.StatusBadRequest,
-							Message: fmt.Sprintf("Unknown tool: %s", request.Params["name"]),
-						})
-					} else if res, err := tool.Run(request.Params, &userSession); err != nil {
-						SendMessage(w, request.ID, ToolResponse{
-							Content: []TextContent{{"text", err.Error()}},
-							IsError: true,
-						})
-					} else {
-						SendMessage(w, request.ID, res)
-					}
-				} else {
-					SendError(w, request.ID, JSONRPCError{
-						Code:    http.StatusBadRequest,
-						Message: fmt.Sprintf("Unexpected parameters: %v", request.Params),
-					})
-				}
-			case "notifications/initialized":
-				SendMessage(w, request.ID, map[string]string{})
-			case "completion/complete":
-				SendMessage(w, request.ID, CompletionResponse{
-					Completion: ExecCompletion(request.Params, &userSession),
-				})
-			case "ping":
-				SendMessage(w, request.ID, map[string]string{})
-			default:
-				if request.Method == "" && userSession.Ping.ID == request.ID { // response to ping
-					userSession.Ping.LastResponse = time.Now()
-					userSession.Ping.ID += 1
-				} else {
-					Log.Warning("plg_handler_mcp::sse message=unknown_method method=%s requestID=%d", request.Method, request.ID)
-					SendError(w, request.ID, JSONRPCError{
-						Code:    http.StatusMethodNotAllowed,
-						Message: fmt.Sprintf("Unknown request: %s", request.Method),
-					})
-				}
+			if res, err := this.dispatch(request, &userSession); err != nil {
+				SendError(w, request.ID, err)
+			} else {
+				SendMessage(w, request.ID, res)
 			}
+		case notification := <-notifications:
+			SendNotification(w, notification)
 		case <-r.Context().Done():
 			this.RemoveSession(&userSession)
 			return
@@ -224,6 +98,146 @@ func (this *Server) sseHandler(_ *App, w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// dispatch executes a JSON-RPC request on behalf of a user session, whatever the transport
+func (this *Server) dispatch(request JSONRPCRequest, userSession *UserSession) (any, error) {
+	b, err := getBackend(userSession.Token)
+	if err != nil {
+		if err == ErrNotAuthorized {
+			err = JSONRPCError{
+				Code:    ErrNotAuthorized.Status(),
+				Message: "You aren't authenticated",
+			}
+		}
+		return nil, err
+	}
+	userSession.Backend = b
+
+	switch request.Method {
+	case "initialize":
+		return InitializeResponse{
+			ProtocolVersion: protocolVersion(request.Params),
+			ServerInfo: ServerInfo{
+				Name:    "Universal Storage Server",
+				Version: "1.0.0",
+			},
+			Capabilities: Capabilities{
+				Tools: map[string]interface{}{},
+				Resources: map[string]interface{}{
+					"subscribe":   true,
+					"listChanged": false,
+				},
+				Prompts: map[string]interface{}{},
+			},
+		}, nil
+	case "resources/list":
+		return &ResourcesListResponse{
+			Resources: ListResources(userSession),
+		}, nil
+	case "resources/templates/list":
+		return &ResourceTemplatesListResponse{
+			ResourceTemplates: AllResourceTemplates(),
+		}, nil
+	case "resources/read":
+		contents, err := ExecResourceRead(request.Params, userSession)
+		if err != nil {
+			return nil, err
+		}
+		return &ResourceReadResponse{
+			Contents: contents,
+		}, nil
+	case "resources/subscribe", "resources/unsubscribe":
+		uri, ok := request.Params["uri"].(string)
+		if !ok {
+			return nil, JSONRPCError{
+				Code:    http.StatusBadRequest,
+				Message: fmt.Sprintf("Unexpected parameters: %v", request.Params),
+			}
+		}
+		if request.Method == "resources/unsubscribe" {
+			this.unsubscribe(uri, userSession)
+			return map[string]string{}, nil
+		} else if err := this.subscribe(uri, userSession); err != nil {
+			return nil, err
+		}
+		return map[string]string{}, nil
+	case "prompts/list":
+		return &PromptsListResponse{
+			Prompts: AllPrompts(),
+		}, nil
+	case "prompts/get":
+		m, ok := request.Params["name"].(string)
+		if !ok {
+			return nil, JSONRPCError{
+				Code:    http.StatusBadRequest,
+				Message: fmt.Sprintf("Unexpected parameters: %v", request.Params),
+			}
+		}
+		res, err := ExecPromptGet(m, request.Params, userSession)
+		if err != nil {
+			return nil, err
+		}
+		return PromptGetResponse{
+			Messages:    res,
+			Description: ExecPromptDescription(request.Params),
+		}, nil
+	case "tools/list":
+		return &ListToolsResponse{
+			Tools: AllTools(),
+		}, nil
+	case "tools/call":
+		tname, ok := request.Params["name"].(string)
+		if !ok {
+			return nil, JSONRPCError{
+				Code:    http.StatusBadRequest,
+				Message: fmt.Sprintf("Unexpected parameters: %v", request.Params),
+			}
+		}
+		tool, err := FindTool(tname)
+		if err != nil {
+			return nil, JSONRPCError{
+				Code:    http.StatusBadRequest,
+				Message: fmt.Sprintf("Unknown tool: %s", request.Params["name"]),
+			}
+		}
+		res, err := tool.Run(request.Params, userSession)
+		if err != nil {
+			return ToolResponse{
+				Content: []TextContent{{"text", err.Error()}},
+				IsError: true,
+			}, nil
+		}
+		return res, nil
+	case "notifications/initialized":
+		return map[string]string{}, nil
+	case "completion/complete":
+		return CompletionResponse{
+			Completion: ExecCompletion(request.Params, userSession),
+		}, nil
+	case "ping":
+		return map[string]string{}, nil
+	}
+	Log.Warning("plg_handler_mcp::dispatch message=unknown_method method=%s requestID=%d", request.Method, request.ID)
+	return nil, JSONRPCError{
+		Code:    http.StatusMethodNotAllowed,
+		Message: fmt.Sprintf("Unknown request: %s", request.Method),
+	}
+}
+
+var supportedProtocolVersions = []string{"2025-06-18", "2025-03-26", "2024-11-05"}
+
+// protocolVersion agrees on the version requested by the client when we know about it and
+// fallback on the most recent one otherwise
+func protocolVersion(params map[string]any) string {
+	if v, ok := params["protocolVersion"].(string); ok {
+		for _, supported := range supportedProtocolVersions {
+			if v == supported {
+				return v
+			}
+		}
+	}
+	return supportedProtocolVersions[0]
+}
+
 func getBackend(token string) (IBackend, error) {
 	str, err := DecryptString(SECRET_KEY_DERIVATE_FOR_USER, token)
 	if err != nil {
@@ -233,6 +247,11 @@ func getBackend(token string) (IBackend, error) {
 	if err = json.Unmarshal([]byte(str), &session); err != nil {
 		return nil, err
 	}
+	if model.SessionRegistryEnabled() {
+		if err = model.SessionRegistryVerify(session["sid"], ""); err != nil {
+			return nil, ErrNotAuthorized
+		}
+	}
 	return model.NewBackend(&App{
 		Context: context.Background(),
 	}, session)
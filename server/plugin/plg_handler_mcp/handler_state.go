@@ -10,6 +10,7 @@ import (
 
 func (this *Server) RemoveSession(userSession *UserSession) {
 	this.sessions.Delete(userSession.Id)
+	this.unsubscribeAll(userSession.Id)
 }
 
 func ExtractToken(r *http.Request) string {
@@ -0,0 +1,259 @@
+package plg_handler_mcp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+	"github.com/mickael-kerjean/filestash/server/model"
+	. "github.com/mickael-kerjean/filestash/server/plugin/plg_handler_mcp/types"
+	. "github.com/mickael-kerjean/filestash/server/plugin/plg_handler_mcp/utils"
+
+	"github.com/google/uuid"
+)
+
+/*
+ * Streamable HTTP transport: https://modelcontextprotocol.io/specification/2025-06-18/basic/transports
+ * Everything goes through a single endpoint, the session is identified by the Mcp-Session-Id header
+ * that is given to the client as part of the initialize response:
+ * - POST: send one or a batch of JSON-RPC messages. Requests are answered either with plain JSON or
+ *         with an event stream depending on what the client accepts
+ * - GET: open an event stream for the messages initiated by the server
+ * - DELETE: terminate the session
+ */
+
+const (
+	MCP_SESSION_HEADER = "Mcp-Session-Id"
+	STREAM_SESSION_TTL = 30 * time.Minute
+)
+
+type streamSession struct {
+	mu       sync.Mutex
+	session  UserSession
+	lastSeen time.Time
+}
+
+func (this *Server) streamHandler(_ *App, w http.ResponseWriter, r *http.Request) {
+	token := ExtractToken(r)
+	if token == "" {
+		this.sendUnauthorized(w, r)
+		return
+	} else if _, err := DecryptString(SECRET_KEY_DERIVATE_FOR_USER, token); err != nil {
+		this.sendUnauthorized(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+		this.streamPost(token, w, r)
+	case http.MethodGet:
+		this.streamGet(token, w, r)
+	case http.MethodDelete:
+		if s := this.getStreamSession(r.Header.Get(MCP_SESSION_HEADER), token); s != nil {
+			this.removeStreamSession(s.session.Id)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (this *Server) streamPost(token string, w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 10*1024*1024))
+	if err != nil {
+		sendJSONError(w, http.StatusBadRequest, JSONRPCError{Code: -32700, Message: "Parse error"})
+		return
+	}
+	isBatch := len(bytes.TrimSpace(body)) > 0 && bytes.TrimSpace(body)[0] == '['
+	requests := []JSONRPCRequest{}
+	if isBatch {
+		err = json.Unmarshal(body, &requests)
+	} else {
+		request := JSONRPCRequest{}
+		err = json.Unmarshal(body, &request)
+		requests = append(requests, request)
+	}
+	if err != nil || len(requests) == 0 {
+		sendJSONError(w, http.StatusBadRequest, JSONRPCError{Code: -32700, Message: "Parse error"})
+		return
+	}
+
+	var s *streamSession
+	if sessionID := r.Header.Get(MCP_SESSION_HEADER); sessionID != "" {
+		if s = this.getStreamSession(sessionID, token); s == nil {
+			sendJSONError(w, http.StatusNotFound, JSONRPCError{Code: -32001, Message: "Session not found"})
+			return
+		}
+	} else if len(requests) == 1 && requests[0].Method == "initialize" {
+		s = this.createStreamSession(token)
+	} else {
+		sendJSONError(w, http.StatusBadRequest, JSONRPCError{Code: -32600, Message: "Missing " + MCP_SESSION_HEADER + " header"})
+		return
+	}
+	w.Header().Set(MCP_SESSION_HEADER, s.session.Id)
+
+	// notifications and responses don't expect anything in return
+	calls := []JSONRPCRequest{}
+	for _, request := range requests {
+		if request.Method == "" || strings.HasPrefix(request.Method, "notifications/") {
+			continue
+		}
+		calls = append(calls, request)
+	}
+	if len(calls) == 0 {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	s.mu.Lock()
+	responses := make([]JSONRPCResponse, 0, len(calls))
+	for _, request := range calls {
+		res, err := this.dispatch(request, &s.session)
+		if err != nil {
+			rpcErr := ToJSONRPCError(err)
+			responses = append(responses, JSONRPCResponse{JSONRPC: "2.0", ID: request.ID, Error: &rpcErr})
+			continue
+		}
+		responses = append(responses, JSONRPCResponse{JSONRPC: "2.0", ID: request.ID, Result: &res})
+	}
+	s.mu.Unlock()
+
+	if strings.Contains(r.Header.Get("Accept"), "application/json") == false &&
+		strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		for _, response := range responses {
+			if response.Error != nil {
+				SendError(w, response.ID, *response.Error)
+			} else {
+				SendMessage(w, response.ID, *response.Result)
+			}
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if isBatch {
+		json.NewEncoder(w).Encode(responses)
+		return
+	}
+	json.NewEncoder(w).Encode(responses[0])
+}
+
+func (this *Server) streamGet(token string, w http.ResponseWriter, r *http.Request) {
+	s := this.getStreamSession(r.Header.Get(MCP_SESSION_HEADER), token)
+	if s == nil {
+		sendJSONError(w, http.StatusNotFound, JSONRPCError{Code: -32001, Message: "Session not found"})
+		return
+	} else if strings.Contains(r.Header.Get("Accept"), "text/event-stream") == false {
+		w.WriteHeader(http.StatusNotAcceptable)
+		return
+	}
+	w.Header().Set(MCP_SESSION_HEADER, s.session.Id)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+	notifications := this.notifications(s.session.Id)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case notification := <-notifications:
+			SendNotification(w, notification)
+		case <-time.After(15 * time.Second):
+			if _, ok := this.streams.Load(s.session.Id); !ok {
+				return
+			}
+			s.mu.Lock()
+			s.lastSeen = time.Now()
+			s.mu.Unlock()
+			fmt.Fprintf(w, ": keepalive\n\n")
+			w.(http.Flusher).Flush()
+		}
+	}
+}
+
+func (this *Server) createStreamSession(token string) *streamSession {
+	s := &streamSession{
+		session: UserSession{
+			Id:      uuid.New().String(),
+			Token:   token,
+			CurrDir: "/",
+			HomeDir: "/",
+			Ping:    Ping{LastResponse: time.Now()},
+		},
+		lastSeen: time.Now(),
+	}
+	if b, err := getBackend(token); err == nil {
+		s.session.HomeDir, _ = model.GetHome(b, "/")
+		s.session.CurrDir = ToString(s.session.HomeDir, "/")
+	}
+	this.streams.Store(s.session.Id, s)
+	return s
+}
+
+func (this *Server) getStreamSession(sessionID string, token string) *streamSession {
+	if sessionID == "" {
+		return nil
+	}
+	v, ok := this.streams.Load(sessionID)
+	if !ok {
+		return nil
+	}
+	s := v.(*streamSession)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.session.Token != token {
+		return nil
+	} else if time.Since(s.lastSeen) > STREAM_SESSION_TTL {
+		this.removeStreamSession(sessionID)
+		return nil
+	}
+	s.lastSeen = time.Now()
+	return s
+}
+
+func (this *Server) removeStreamSession(sessionID string) {
+	this.streams.Delete(sessionID)
+	this.unsubscribeAll(sessionID)
+}
+
+func (this *Server) cleanupStreamSessions() {
+	for {
+		time.Sleep(time.Minute)
+		this.streams.Range(func(key, value any) bool {
+			s := value.(*streamSession)
+			s.mu.Lock()
+			expired := time.Since(s.lastSeen) > STREAM_SESSION_TTL
+			s.mu.Unlock()
+			if expired {
+				this.removeStreamSession(key.(string))
+			}
+			return true
+		})
+	}
+}
+
+func (this *Server) sendUnauthorized(w http.ResponseWriter, r *http.Request) {
+	Log.Debug("plg_handler_mcp::auth msg=invalid_token path=%s", r.URL.Path)
+	w.Header().Add("WWW-Authenticate", "Bearer resource_metadata=\""+this.baseURL(r)+"/.well-known/oauth-protected-resource\"")
+	sendJSONError(w, http.StatusUnauthorized, JSONRPCError{
+		Code:    http.StatusUnauthorized,
+		Message: "Missing or invalid access token",
+	})
+}
+
+func sendJSONError(w http.ResponseWriter, status int, rpcErr JSONRPCError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(JSONRPCResponse{
+		JSONRPC: "2.0",
+		Error:   &rpcErr,
+	})
+}
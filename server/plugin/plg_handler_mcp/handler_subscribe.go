@@ -0,0 +1,126 @@
+package plg_handler_mcp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+	. "github.com/mickael-kerjean/filestash/server/plugin/plg_handler_mcp/impl"
+	. "github.com/mickael-kerjean/filestash/server/plugin/plg_handler_mcp/types"
+)
+
+/*
+ * Resource subscriptions: https://modelcontextprotocol.io/specification/2025-06-18/server/resources#subscriptions
+ * Storage backends don't have a common way to watch for changes so we poll the subscribed files
+ * and send a notifications/resources/updated message through the session event stream when
+ * either their size or modification time changes
+ */
+
+const (
+	SUBSCRIPTION_POLL_INTERVAL = 10 * time.Second
+	SUBSCRIPTION_MAX           = 50
+)
+
+type subscriber struct {
+	mu            sync.Mutex
+	notifications chan JSONRPCMethod
+	watchers      map[string]context.CancelFunc
+}
+
+func (this *Server) subscribe(uri string, userSession *UserSession) error {
+	path, err := FilePath(uri)
+	if err != nil {
+		return err
+	}
+	f, err := userSession.Backend.Stat(path)
+	if err != nil {
+		return err
+	}
+	s := this.subscriber(userSession.Id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.watchers[uri]; ok {
+		return nil
+	} else if len(s.watchers) >= SUBSCRIPTION_MAX {
+		return JSONRPCError{
+			Code:    http.StatusTooManyRequests,
+			Message: fmt.Sprintf("Too many subscriptions, the limit is %d", SUBSCRIPTION_MAX),
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.watchers[uri] = cancel
+	go watch(ctx, userSession.Backend, uri, path, f.Size(), f.ModTime(), s.notifications)
+	return nil
+}
+
+func (this *Server) unsubscribe(uri string, userSession *UserSession) {
+	v, ok := this.subscriptions.Load(userSession.Id)
+	if !ok {
+		return
+	}
+	s := v.(*subscriber)
+	s.mu.Lock()
+	if cancel, ok := s.watchers[uri]; ok {
+		cancel()
+		delete(s.watchers, uri)
+	}
+	s.mu.Unlock()
+}
+
+func (this *Server) unsubscribeAll(sessionID string) {
+	v, ok := this.subscriptions.LoadAndDelete(sessionID)
+	if !ok {
+		return
+	}
+	s := v.(*subscriber)
+	s.mu.Lock()
+	for uri, cancel := range s.watchers {
+		cancel()
+		delete(s.watchers, uri)
+	}
+	s.mu.Unlock()
+}
+
+// notifications is where the transports pick up the messages to forward to the client
+func (this *Server) notifications(sessionID string) chan JSONRPCMethod {
+	return this.subscriber(sessionID).notifications
+}
+
+func (this *Server) subscriber(sessionID string) *subscriber {
+	v, _ := this.subscriptions.LoadOrStore(sessionID, &subscriber{
+		notifications: make(chan JSONRPCMethod, 16),
+		watchers:      map[string]context.CancelFunc{},
+	})
+	return v.(*subscriber)
+}
+
+func watch(ctx context.Context, backend IBackend, uri string, path string, size int64, modTime time.Time, notifications chan JSONRPCMethod) {
+	ticker := time.NewTicker(SUBSCRIPTION_POLL_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			f, err := backend.Stat(path)
+			if err != nil {
+				Log.Debug("plg_handler_mcp::subscribe action=stat uri=%s err=%s", uri, err.Error())
+				continue
+			} else if f.Size() == size && f.ModTime().Equal(modTime) {
+				continue
+			}
+			size, modTime = f.Size(), f.ModTime()
+			select {
+			case notifications <- JSONRPCMethod{
+				JSONRPC: "2.0",
+				Method:  "notifications/resources/updated",
+				Params:  map[string]any{"uri": uri},
+			}:
+			default: // nobody is listening, the client will catch up on its next read
+			}
+		}
+	}
+}
@@ -1,12 +1,23 @@
 package impl
 
 import (
+	"encoding/base64"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
 
+	. "github.com/mickael-kerjean/filestash/server/common"
 	. "github.com/mickael-kerjean/filestash/server/plugin/plg_handler_mcp/types"
 )
 
+const (
+	FILE_RESOURCE_SCHEME   = "file://"
+	FILE_RESOURCE_MAX_SIZE = 10 * 1024 * 1024
+)
+
 var listOfResources = map[string]Resource{}
 
 func RegisterResource(r Resource) {
@@ -21,8 +32,41 @@ func AllResources() []Resource {
 	return r
 }
 
+// ListResources gives the static resources as well as the files sitting in the current
+// working directory of the session so clients have something to attach out of the box
+func ListResources(userSession *UserSession) []Resource {
+	r := AllResources()
+	if userSession.Backend == nil {
+		return r
+	}
+	files, err := userSession.Backend.Ls(userSession.CurrDir)
+	if err != nil {
+		return r
+	}
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		path := JoinPath(userSession.CurrDir, file.Name())
+		r = append(r, Resource{
+			URI:         FileURI(path),
+			Name:        file.Name(),
+			Description: path,
+			MimeType:    GetMimeType(path),
+		})
+	}
+	return r
+}
+
 func AllResourceTemplates() []ResourceTemplate {
-	return []ResourceTemplate{}
+	return []ResourceTemplate{
+		{
+			URITemplate: FILE_RESOURCE_SCHEME + "{+path}",
+			Name:        "file",
+			Description: "A file from the storage, the path is absolute, eg: file:///home/documents/report.pdf",
+			MimeType:    "application/octet-stream",
+		},
+	}
 }
 
 func FindResource(uri string) (*Resource, error) {
@@ -36,6 +80,97 @@ func FindResource(uri string) (*Resource, error) {
 	return &r, nil
 }
 
-func ExecResourceRead(params map[string]any) []ResourceContent {
-	return []ResourceContent{}
+func ExecResourceRead(params map[string]any, userSession *UserSession) ([]ResourceContent, error) {
+	uri, ok := params["uri"].(string)
+	if !ok {
+		return nil, JSONRPCError{
+			Code:    http.StatusBadRequest,
+			Message: fmt.Sprintf("Unexpected parameters: %v", params),
+		}
+	}
+	if strings.HasPrefix(uri, FILE_RESOURCE_SCHEME) == false {
+		resource, err := FindResource(uri)
+		if err != nil {
+			return nil, err
+		}
+		return []ResourceContent{
+			{
+				URI:      uri,
+				MimeType: resource.MimeType,
+				Text:     resource.Content,
+				Meta:     resource.Meta,
+			},
+		}, nil
+	}
+
+	path, err := FilePath(uri)
+	if err != nil {
+		return nil, err
+	} else if IsDirectory(path) {
+		return nil, JSONRPCError{
+			Code:    http.StatusBadRequest,
+			Message: fmt.Sprintf("Not a file: %s", uri),
+		}
+	}
+	r, err := userSession.Backend.Cat(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	b, err := io.ReadAll(io.LimitReader(r, FILE_RESOURCE_MAX_SIZE+1))
+	if err != nil {
+		return nil, err
+	} else if len(b) > FILE_RESOURCE_MAX_SIZE {
+		return nil, JSONRPCError{
+			Code:    http.StatusRequestEntityTooLarge,
+			Message: fmt.Sprintf("File is too large: %s", uri),
+		}
+	}
+
+	mType := GetMimeType(path)
+	if isTextMimeType(mType) || (mType == "application/octet-stream" && isText(b)) {
+		if mType == "application/octet-stream" {
+			mType = "text/plain"
+		}
+		return []ResourceContent{{URI: uri, MimeType: mType, Text: string(b)}}, nil
+	}
+	return []ResourceContent{{URI: uri, MimeType: mType, Blob: base64.StdEncoding.EncodeToString(b)}}, nil
+}
+
+// FileURI and FilePath convert back and forth between a path on the storage and its
+// file:// resource uri
+func FileURI(path string) string {
+	u := url.URL{Scheme: "file", Path: path}
+	return u.String()
+}
+
+func FilePath(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" || strings.HasPrefix(u.Path, "/") == false {
+		return "", JSONRPCError{
+			Code:    http.StatusBadRequest,
+			Message: fmt.Sprintf("Invalid resource uri: %s", uri),
+		}
+	}
+	return u.Path, nil
+}
+
+func isTextMimeType(mType string) bool {
+	if strings.HasPrefix(mType, "text/") {
+		return true
+	}
+	switch mType {
+	case "application/json", "application/xml", "application/javascript",
+		"application/x-sh", "application/x-yaml", "application/yaml",
+		"image/svg+xml":
+		return true
+	}
+	return false
+}
+
+func isText(b []byte) bool {
+	if len(b) > 0 && strings.IndexByte(string(b[:min(len(b), 8000)]), 0) != -1 {
+		return false
+	}
+	return utf8.Valid(b)
 }
@@ -9,6 +9,7 @@ import (
 	"strings"
 
 	. "github.com/mickael-kerjean/filestash/server/common"
+	"github.com/mickael-kerjean/filestash/server/model"
 	. "github.com/mickael-kerjean/filestash/server/plugin/plg_handler_mcp/types"
 	. "github.com/mickael-kerjean/filestash/server/plugin/plg_handler_mcp/utils"
 )
@@ -144,6 +145,31 @@ func init() {
 			},
 		})
 
+		RegisterTool(Tool{
+			Name:        "cp",
+			Description: "Use this when you need to copy a file or directory from one path to another, based on the Unix command: `cp -R`. Directories must end with a trailing slash.",
+			InputSchema: JsonSchema(map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"from": map[string]string{
+						"type":        "string",
+						"description": "origin path",
+					},
+					"to": map[string]string{
+						"type":        "string",
+						"description": "destination path",
+					},
+				},
+				"required": []string{"from", "to"},
+			}),
+			Run: ToolFSCp,
+			Annotations: Meta{
+				"destructiveHint": true,
+				"openWorldHint":   true,
+				"readOnlyHint":    false,
+			},
+		})
+
 		RegisterTool(Tool{
 			Name:        "mkdir",
 			Description: "Use this when you need to create a new directory at a specified path, based on the Unix command: `mkdir`.",
@@ -344,6 +370,27 @@ func ToolFSMv(params map[string]any, userSession *UserSession) (*ToolResponse, e
 	}, nil
 }
 
+func ToolFSCp(params map[string]any, userSession *UserSession) (*ToolResponse, error) {
+	if isArgEmpty(params, "from") || isArgEmpty(params, "to") {
+		return nil, ErrNotValid
+	}
+	if err := model.Cp(
+		userSession.Backend,
+		getPath(params, userSession, "from"),
+		getPath(params, userSession, "to"),
+	); err != nil {
+		return nil, err
+	}
+	return &ToolResponse{
+		Content: []TextContent{
+			{
+				Type: "text",
+				Text: "done",
+			},
+		},
+	}, nil
+}
+
 func ToolFSMkdir(params map[string]any, userSession *UserSession) (*ToolResponse, error) {
 	if isArgEmpty(params, "path") {
 		return nil, ErrNotValid
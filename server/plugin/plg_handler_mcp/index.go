@@ -12,7 +12,10 @@ import (
 )
 
 type Server struct {
-	sessions sync.Map
+	sessions sync.Map // legacy SSE transport
+	streams  sync.Map // streamable HTTP transport
+
+	subscriptions sync.Map // resource subscriptions of a session
 }
 
 func init() {
@@ -25,12 +28,15 @@ func init() {
 			return nil
 		}
 		srv := Server{}
+		go srv.cleanupStreamSessions()
 		m := []Middleware{WithCORS}
+		r.HandleFunc("/mcp", NewMiddlewareChain(srv.streamHandler, m)).Methods("GET", "POST", "DELETE", "OPTIONS")
 		r.HandleFunc("/sse", NewMiddlewareChain(srv.sseHandler, m)).Methods("GET", "OPTIONS")
 		r.HandleFunc("/messages", NewMiddlewareChain(srv.messageHandler, m)).Methods("POST", "OPTIONS")
 		r.HandleFunc("/.well-known/oauth-authorization-server", NewMiddlewareChain(srv.WellKnownOAuthAuthorizationServerHandler, m)).Methods("GET", "OPTIONS")
 		r.HandleFunc("/.well-known/oauth-protected-resource", NewMiddlewareChain(srv.WellKnownOAuthProtectedResourceHandler, m)).Methods("GET", "OPTIONS")
 		r.HandleFunc("/.well-known/oauth-protected-resource/sse", NewMiddlewareChain(srv.WellKnownOAuthProtectedResourceHandler, m)).Methods("GET", "OPTIONS")
+		r.HandleFunc("/.well-known/oauth-protected-resource/mcp", NewMiddlewareChain(srv.WellKnownOAuthProtectedResourceHandler, m)).Methods("GET", "OPTIONS")
 
 		r.HandleFunc("/mcp/token", NewMiddlewareChain(srv.TokenHandler, m)).Methods("POST")
 		m = []Middleware{}
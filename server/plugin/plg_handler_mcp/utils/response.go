@@ -63,22 +63,17 @@ func SendMethod(w io.Writer, requestID uint64, method string, args ...map[string
 	w.(http.Flusher).Flush()
 }
 
-func SendError(w io.Writer, requestID uint64, d error) {
-	var rpcErr JSONRPCError
-	switch v := d.(type) {
-	case JSONRPCError:
-		rpcErr = v
-	case AppError:
-		rpcErr = JSONRPCError{
-			Code:    v.Status(),
-			Message: v.Error(),
-		}
-	default:
-		rpcErr = JSONRPCError{
-			Code:    http.StatusInternalServerError,
-			Message: d.Error(),
-		}
+func SendNotification(w io.Writer, notification JSONRPCMethod) {
+	b, err := json.Marshal(notification)
+	if err != nil {
+		return
 	}
+	fmt.Fprintf(w, "event: message\ndata: %s\n\n", string(b))
+	w.(http.Flusher).Flush()
+}
+
+func SendError(w io.Writer, requestID uint64, d error) {
+	rpcErr := ToJSONRPCError(d)
 	b, err := json.Marshal(JSONRPCResponse{
 		JSONRPC: "2.0",
 		ID:      requestID,
@@ -91,3 +86,19 @@ func SendError(w io.Writer, requestID uint64, d error) {
 	}
 	w.(http.Flusher).Flush()
 }
+
+func ToJSONRPCError(d error) JSONRPCError {
+	switch v := d.(type) {
+	case JSONRPCError:
+		return v
+	case AppError:
+		return JSONRPCError{
+			Code:    v.Status(),
+			Message: v.Error(),
+		}
+	}
+	return JSONRPCError{
+		Code:    http.StatusInternalServerError,
+		Message: d.Error(),
+	}
+}
@@ -8,6 +8,7 @@ func init() {
 	Hooks.Register.Onload(func() {
 		PluginEnable()
 		PluginParamAutoindex()
+		PluginParamSPA()
 		PluginParamCORSAllowOrigins()
 	})
 
@@ -20,7 +21,7 @@ var PluginEnable = func() bool {
 		}
 		f.Name = "enable"
 		f.Type = "enable"
-		f.Target = []string{"site_autoindex", "site_cors_allow_origins"}
+		f.Target = []string{"site_autoindex", "site_spa", "site_cors_allow_origins"}
 		f.Description = "Enable/Disable the creation of site via shared links. Sites will be made available under /public/{shareID}/"
 		f.Default = false
 		return f
@@ -41,6 +42,20 @@ var PluginParamAutoindex = func() bool {
 	}).Bool()
 }
 
+var PluginParamSPA = func() bool {
+	return Config.Get("features.site.spa").Schema(func(f *FormElement) *FormElement {
+		if f == nil {
+			f = &FormElement{}
+		}
+		f.Id = "site_spa"
+		f.Name = "spa"
+		f.Type = "boolean"
+		f.Description = "Serve the index.html at the root of the site for pages that don't exist so single page applications can do their own routing. A 404.html is used otherwise."
+		f.Default = false
+		return f
+	}).Bool()
+}
+
 var PluginParamCORSAllowOrigins = func() string {
 	return Config.Get("features.site.cors_allow_origins").Schema(func(f *FormElement) *FormElement {
 		if f == nil {
@@ -1,9 +1,9 @@
 package plg_handler_site
 
 import (
-	"io"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
 
 	. "github.com/mickael-kerjean/filestash/server/common"
@@ -44,41 +44,104 @@ func SiteHandler(app *App, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	path := strings.TrimPrefix(r.URL.Path, "/public/"+app.Share.Id)
-	if strings.HasSuffix(path, "/") {
-		path += "index.html"
+	rules := loadSiteRules(app)
+	for name, values := range rules.Headers(path) {
+		w.Header()[name] = values
 	}
-	path, err := ctrl.PathBuilder(app, path)
-	if err != nil {
-		SendErrorResult(w, err)
+	if isSiteRulesFile(path) {
+		notFound(app, w, r)
 		return
 	}
-	for _, auth := range Hooks.Get.AuthorisationMiddleware() {
-		if err = auth.Cat(app, path); err != nil {
-			SendErrorResult(w, ErrNotAuthorized)
-			return
-		}
+
+	// STEP1: forced redirects win over everything
+	if rule, to, ok := rules.Redirect(path, true); ok {
+		redirect(app, w, r, rule, to)
+		return
+	}
+
+	// STEP2: the file being asked for
+	p := path
+	if strings.HasSuffix(p, "/") {
+		p += "index.html"
 	}
-	if f, err := app.Backend.Cat(path); err == nil {
-		w.Header().Set("Content-Type", GetMimeType(path))
-		io.Copy(w, f)
-		f.Close()
+	f, err := openSiteFile(app, p)
+	if err == nil && f.info != nil && f.info.IsDir() {
+		http.Redirect(w, r, r.URL.Path+"/", http.StatusSeeOther)
+		return
+	} else if err == nil {
+		serveFile(w, r, f, http.StatusOK)
 		return
-	} else if err == ErrNotFound && PluginParamAutoindex() {
-		if files, err := app.Backend.Ls(strings.TrimSuffix(path, "index.html")); err == nil {
-			if strings.HasSuffix(r.URL.Path, "/") == false {
-				http.Redirect(w, r, r.URL.Path+"/", http.StatusSeeOther)
+	} else if isNotFound(err) == false {
+		SendErrorResult(w, err)
+		return
+	}
+
+	// STEP3: redirects only apply when nothing exists at that path
+	if rule, to, ok := rules.Redirect(path, false); ok {
+		redirect(app, w, r, rule, to)
+		return
+	}
+
+	// STEP4: folder without an index
+	if strings.HasSuffix(path, "/") && PluginParamAutoindex() {
+		if fullpath, err := ctrl.PathBuilder(app, path); err == nil {
+			if files, err := app.Backend.Ls(fullpath); err == nil {
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				if err := TmplAutoindex.Execute(w, map[string]any{
+					"Base":  r.URL.Path,
+					"Files": files,
+				}); err != nil {
+					SendErrorResult(w, err)
+				}
 				return
 			}
-			w.Header().Set("Content-Type", "text/html; charset=utf-8")
-			if err := TmplAutoindex.Execute(w, map[string]any{
-				"Base":  r.URL.Path,
-				"Files": files,
-			}); err != nil {
-				SendErrorResult(w, err)
-			}
+		}
+	}
+
+	// STEP5: single page applications do their own routing
+	if PluginParamSPA() && filepath.Ext(path) == "" {
+		if f, err := openSiteFile(app, "/index.html"); err == nil && f.ReadCloser != nil {
+			serveFile(w, r, f, http.StatusOK)
 			return
 		}
 	}
+	notFound(app, w, r)
+}
+
+func redirect(app *App, w http.ResponseWriter, r *http.Request, rule redirectRule, to string) {
+	if rule.status >= 300 && rule.status < 400 {
+		if strings.HasPrefix(to, "/") {
+			to = "/public/" + app.Share.Id + to
+		}
+		if r.URL.RawQuery != "" && strings.Contains(to, "?") == false {
+			to += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, to, rule.status)
+		return
+	} else if strings.HasPrefix(to, "/") == false {
+		// proxying to another server isn't something we do
+		Log.Debug("plg_handler_site::redirect unsupported rewrite to=%s", to)
+		notFound(app, w, r)
+		return
+	}
+	to, _, _ = strings.Cut(to, "?")
+	if strings.HasSuffix(to, "/") {
+		to += "index.html"
+	}
+	f, err := openSiteFile(app, to)
+	if err != nil || f.ReadCloser == nil {
+		notFound(app, w, r)
+		return
+	}
+	serveFile(w, r, f, rule.status)
+}
+
+// notFound uses the 404.html page of the site when there's one
+func notFound(app *App, w http.ResponseWriter, r *http.Request) {
+	if f, err := openSiteFile(app, "/404.html"); err == nil && f.ReadCloser != nil {
+		serveFile(w, r, f, http.StatusNotFound)
+		return
+	}
 	SendErrorResult(w, ErrNotFound)
 }
 
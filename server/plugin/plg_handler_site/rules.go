@@ -0,0 +1,249 @@
+package plg_handler_site
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+	"github.com/mickael-kerjean/filestash/server/ctrl"
+)
+
+/*
+ * A site can customise how it is served with a "_headers" and a "_redirects" file at its root,
+ * following the format made popular by Netlify:
+ *
+ * _headers:
+ *   /assets/*
+ *     Cache-Control: public, max-age=31536000
+ *
+ * _redirects:
+ *   /blog/:slug  /posts/:slug  301
+ *   /old/*       /new/:splat   302!
+ *   /*           /index.html   200
+ *
+ * The headers a site can't set are the one that would let its content act on the rest of the
+ * application or break the response: cookies, security policies, framing and the hop by hop
+ * headers. They are dropped when the file is parsed
+ */
+
+const (
+	SITE_HEADERS_FILE   = "_headers"
+	SITE_REDIRECTS_FILE = "_redirects"
+	SITE_RULES_MAX_SIZE = 512 * 1024
+)
+
+var (
+	// siteRulesCache avoids reading the rules of a site on every single request
+	siteRulesCache = NewQuickCache(10, 20)
+	placeholder    = regexp.MustCompile(`^:[a-zA-Z_][a-zA-Z0-9_]*$`)
+	blockedHeaders = map[string]bool{
+		"Set-Cookie":                          true,
+		"Set-Cookie2":                         true,
+		"Clear-Site-Data":                     true,
+		"Content-Length":                      true,
+		"Content-Encoding":                    true,
+		"Transfer-Encoding":                   true,
+		"Connection":                          true,
+		"Keep-Alive":                          true,
+		"Proxy-Connection":                    true,
+		"Proxy-Authenticate":                  true,
+		"Proxy-Authorization":                 true,
+		"Te":                                  true,
+		"Trailer":                             true,
+		"Upgrade":                             true,
+		"Content-Security-Policy":             true,
+		"Content-Security-Policy-Report-Only": true,
+		"Strict-Transport-Security":           true,
+		"X-Content-Type-Options":              true,
+		"X-Xss-Protection":                    true,
+		"X-Frame-Options":                     true,
+		"Cross-Origin-Opener-Policy":          true,
+		"Cross-Origin-Embedder-Policy":        true,
+		"Cross-Origin-Resource-Policy":        true,
+	}
+)
+
+type siteRules struct {
+	headers   []headerRule
+	redirects []redirectRule
+}
+
+type headerRule struct {
+	pattern *regexp.Regexp
+	headers http.Header
+}
+
+type redirectRule struct {
+	pattern *regexp.Regexp
+	to      string
+	status  int
+	force   bool
+}
+
+func loadSiteRules(app *App) *siteRules {
+	key := map[string]string{"share": app.Share.Id}
+	if rules, ok := siteRulesCache.Get(key).(*siteRules); ok {
+		return rules
+	}
+	rules := &siteRules{}
+	if r := readSiteFile(app, "/"+SITE_HEADERS_FILE); r != nil {
+		rules.headers = parseHeaders(r)
+	}
+	if r := readSiteFile(app, "/"+SITE_REDIRECTS_FILE); r != nil {
+		rules.redirects = parseRedirects(r)
+	}
+	siteRulesCache.Set(key, rules)
+	return rules
+}
+
+func readSiteFile(app *App, name string) io.Reader {
+	path, err := ctrl.PathBuilder(app, name)
+	if err != nil {
+		return nil
+	}
+	f, err := app.Backend.Cat(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	b, err := io.ReadAll(io.LimitReader(f, SITE_RULES_MAX_SIZE))
+	if err != nil {
+		Log.Debug("plg_handler_site::rules read path=%s err=%s", name, err.Error())
+		return nil
+	}
+	return bytes.NewReader(b)
+}
+
+func parseHeaders(r io.Reader) []headerRule {
+	rules := []headerRule{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		} else if line[0] != ' ' && line[0] != '\t' {
+			if pattern := compilePattern(trimmed); pattern != nil {
+				rules = append(rules, headerRule{pattern: pattern, headers: http.Header{}})
+			}
+			continue
+		} else if len(rules) == 0 {
+			continue
+		}
+		name, value, ok := strings.Cut(trimmed, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			continue
+		}
+		name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+		if blockedHeaders[name] || strings.HasPrefix(name, "Access-Control-") {
+			Log.Debug("plg_handler_site::headers blocked name=%s", name)
+			continue
+		}
+		rules[len(rules)-1].headers.Add(name, strings.TrimSpace(value))
+	}
+	return rules
+}
+
+func parseRedirects(r io.Reader) []redirectRule {
+	rules := []redirectRule{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		rule := redirectRule{to: fields[1], status: http.StatusMovedPermanently}
+		if len(fields) >= 3 {
+			status := fields[2]
+			if strings.HasSuffix(status, "!") {
+				rule.force = true
+				status = strings.TrimSuffix(status, "!")
+			}
+			code, err := strconv.Atoi(status)
+			if err != nil || code < 200 || code > 599 {
+				continue
+			}
+			rule.status = code
+		}
+		if rule.pattern = compilePattern(fields[0]); rule.pattern == nil {
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// compilePattern turns a path like "/blog/:slug/*" into a regexp where ":slug" matches a single
+// segment and "*" anything, the latter being available as ":splat"
+func compilePattern(pattern string) *regexp.Regexp {
+	if strings.HasPrefix(pattern, "/") == false {
+		return nil
+	}
+	var re strings.Builder
+	re.WriteString("^")
+	for i, segment := range strings.Split(strings.TrimSuffix(pattern, "/"), "/") {
+		if i > 0 {
+			re.WriteString("/")
+		}
+		switch {
+		case segment == "*":
+			re.WriteString("(?P<splat>.*)")
+		case placeholder.MatchString(segment):
+			re.WriteString("(?P<" + segment[1:] + ">[^/]+)")
+		default:
+			re.WriteString(regexp.QuoteMeta(segment))
+		}
+	}
+	re.WriteString("/?$")
+	compiled, err := regexp.Compile(re.String())
+	if err != nil {
+		return nil
+	}
+	return compiled
+}
+
+// Headers gives the custom headers of a path, merging every block matching it
+func (this *siteRules) Headers(path string) http.Header {
+	out := http.Header{}
+	for _, rule := range this.headers {
+		if rule.pattern.MatchString(path) == false {
+			continue
+		}
+		for name, values := range rule.headers {
+			for _, value := range values {
+				out.Add(name, value)
+			}
+		}
+	}
+	return out
+}
+
+// Redirect finds the first rule matching a path and returns where it points to
+func (this *siteRules) Redirect(path string, force bool) (redirectRule, string, bool) {
+	for _, rule := range this.redirects {
+		if rule.force != force {
+			continue
+		}
+		match := rule.pattern.FindStringSubmatch(path)
+		if match == nil {
+			continue
+		}
+		to := rule.to
+		for i, name := range rule.pattern.SubexpNames() {
+			if name != "" {
+				to = strings.ReplaceAll(to, ":"+name, match[i])
+			}
+		}
+		return rule, to, true
+	}
+	return redirectRule{}, "", false
+}
+
+func isSiteRulesFile(path string) bool {
+	return path == "/"+SITE_HEADERS_FILE || path == "/"+SITE_REDIRECTS_FILE
+}
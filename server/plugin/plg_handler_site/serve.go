@@ -0,0 +1,241 @@
+package plg_handler_site
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
+	"github.com/mickael-kerjean/filestash/server/ctrl"
+	"github.com/mickael-kerjean/filestash/server/pkg/compress"
+)
+
+const (
+	SITE_COMPRESS_MAX_SIZE       = 4 * 1024 * 1024
+	SITE_COMPRESS_CACHE_MAX_SIZE = 64 * 1024 * 1024
+)
+
+var (
+	// compressedCache saves us from compressing the same file over and over again. What it holds
+	// is capped to SITE_COMPRESS_CACHE_MAX_SIZE, past that point content is compressed on the fly
+	compressedCache     = NewQuickCache(300, 600)
+	compressedCacheSize int
+	compressedCacheLock sync.Mutex
+)
+
+func init() {
+	compressedCache.OnEvict(func(key string, value interface{}) {
+		if c, ok := value.([]byte); ok {
+			compressedCacheLock.Lock()
+			compressedCacheSize -= len(c)
+			compressedCacheLock.Unlock()
+		}
+	})
+}
+
+type siteFile struct {
+	io.ReadCloser
+	share string
+	path  string
+	info  os.FileInfo
+}
+
+// openSiteFile opens a file from the site. A folder is returned without any content so the
+// caller can decide what to do with it
+func openSiteFile(app *App, path string) (*siteFile, error) {
+	fullpath, err := ctrl.PathBuilder(app, path)
+	if err != nil {
+		return nil, err
+	}
+	for _, auth := range Hooks.Get.AuthorisationMiddleware() {
+		if err = auth.Cat(app, fullpath); err != nil {
+			return nil, ErrNotAuthorized
+		}
+	}
+	info, err := app.Backend.Stat(fullpath)
+	if isNotFound(err) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		info = nil // not every backend can stat, the cat will tell us
+	} else if info.IsDir() {
+		return &siteFile{share: app.Share.Id, path: fullpath, info: info}, nil
+	}
+	f, err := app.Backend.Cat(fullpath)
+	if err != nil {
+		return nil, err
+	}
+	return &siteFile{ReadCloser: f, share: app.Share.Id, path: fullpath, info: info}, nil
+}
+
+// serveFile sends a file taking care of conditional and range requests. Text content is
+// compressed when the client supports it
+func serveFile(w http.ResponseWriter, r *http.Request, f *siteFile, status int) {
+	defer f.Close()
+	header := w.Header()
+	mType := GetMimeType(f.path)
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", mType)
+	}
+	if isCompressible(mType) {
+		header.Add("Vary", "Accept-Encoding")
+	}
+	if f.info == nil || status != http.StatusOK {
+		// error pages aren't meant to be cached nor resumed
+		w.WriteHeader(status)
+		if r.Method != http.MethodHead {
+			io.Copy(w, f)
+		}
+		return
+	}
+
+	var (
+		modTime time.Time
+		content []byte
+		err     error
+		etag    = QuickHash(fmt.Sprintf("%s %d %d", f.path, f.info.Size(), f.info.ModTime().UnixNano()), 20)
+	)
+	if f.info.ModTime().Unix() > 0 {
+		modTime = f.info.ModTime()
+	}
+	if encodings := acceptedEncodings(r); len(encodings) > 0 && isCompressible(mType) && f.info.Size() <= SITE_COMPRESS_MAX_SIZE {
+		if content, err = io.ReadAll(f); err != nil {
+			SendErrorResult(w, err)
+			return
+		}
+		for _, encoding := range encodings {
+			if c := compressContent(f.share, etag, encoding, content); len(c) > 0 {
+				header.Set("Content-Encoding", encoding)
+				header.Set("Etag", `"`+etag+"-"+encoding+`"`)
+				http.ServeContent(w, r, "", modTime, bytes.NewReader(c))
+				return
+			}
+		}
+	}
+	header.Set("Etag", `"`+etag+`"`)
+	if content != nil {
+		http.ServeContent(w, r, "", modTime, bytes.NewReader(content))
+		return
+	}
+	rs, ok := f.ReadCloser.(io.ReadSeeker)
+	if !ok {
+		if strings.Contains(r.Header.Get("Range"), ",") {
+			r.Header.Del("Range") // a stream can't go back to serve several ranges
+		}
+		rs = &forwardSeeker{r: f, size: f.info.Size()}
+	}
+	http.ServeContent(w, r, "", modTime, rs)
+}
+
+func compressContent(share string, etag string, encoding string, content []byte) []byte {
+	key := map[string]string{"share": share, "etag": etag, "encoding": encoding}
+	if c, ok := compressedCache.Get(key).([]byte); ok {
+		return c
+	}
+	var c []byte
+	switch encoding {
+	case "br":
+		c = compress.Br(content, 5)
+	case "gzip":
+		c = compress.Gzip(content, 6)
+	}
+	compressedCacheLock.Lock()
+	defer compressedCacheLock.Unlock()
+	if _, ok := compressedCache.Get(key).([]byte); ok {
+		return c
+	} else if compressedCacheSize+len(c) > SITE_COMPRESS_CACHE_MAX_SIZE {
+		return c
+	}
+	compressedCacheSize += len(c)
+	compressedCache.Set(key, c)
+	return c
+}
+
+// acceptedEncodings gives the encodings we know about that the client is happy to receive, our
+// favorite first
+func acceptedEncodings(r *http.Request) []string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(part, ";")
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				continue
+			}
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = true
+	}
+	out := []string{}
+	for _, encoding := range []string{"br", "gzip"} {
+		if accepted[encoding] {
+			out = append(out, encoding)
+		}
+	}
+	return out
+}
+
+func isCompressible(mType string) bool {
+	if strings.HasPrefix(mType, "text/") {
+		return true
+	}
+	for _, t := range []string{"javascript", "json", "xml", "svg", "wasm"} {
+		if strings.Contains(mType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+func isNotFound(err error) bool {
+	if err == nil {
+		return false
+	} else if err == ErrNotFound {
+		return true
+	}
+	obj, ok := err.(interface{ Status() int })
+	return ok && obj.Status() == http.StatusNotFound
+}
+
+// forwardSeeker lets http.ServeContent serve a range out of a backend that can't seek. It only
+// ever moves forward, skipping what comes before the requested range
+type forwardSeeker struct {
+	r    io.Reader
+	size int64
+	pos  int64 // where the next read should start
+	read int64 // how much was consumed from the underlying reader
+}
+
+func (this *forwardSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += this.pos
+	case io.SeekEnd:
+		offset += this.size
+	}
+	if offset < 0 {
+		return 0, ErrNotValid
+	}
+	this.pos = offset
+	return offset, nil
+}
+
+func (this *forwardSeeker) Read(p []byte) (int, error) {
+	if this.pos < this.read {
+		return 0, NewError("Can't seek backward", 500)
+	} else if this.pos > this.read {
+		n, err := io.CopyN(io.Discard, this.r, this.pos-this.read)
+		this.read += n
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := this.r.Read(p)
+	this.read += int64(n)
+	this.pos += int64(n)
+	return n, err
+}
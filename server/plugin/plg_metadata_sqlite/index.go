@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"unicode/utf8"
 
 	. "github.com/mickael-kerjean/filestash/server/common"
 )
@@ -23,7 +24,21 @@ func init() {
         value    TEXT NOT NULL,
         PRIMARY KEY (tenantID, path)
     )`)
-	Hooks.Register.Metadata(MetaImpl{db: db})
+	// every field of the metadata forms gets flattened in there so we can search through
+	// an index instead of going through the json blobs
+	db.Exec(`CREATE TABLE IF NOT EXISTS metadata_field (
+        tenantID TEXT NOT NULL,
+        path     TEXT NOT NULL,
+        field    TEXT NOT NULL,
+        value    TEXT NOT NULL COLLATE NOCASE
+    )`)
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_metadata_field_value ON metadata_field(tenantID, field, value)`)
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_metadata_field_path ON metadata_field(tenantID, path)`)
+	impl := MetaImpl{db: db}
+	if err := impl.reindex(); err != nil {
+		Log.Warning("plg_metadata_sqlite - cannot index metadata: %s", err.Error())
+	}
+	Hooks.Register.Metadata(impl)
 }
 
 type MetaImpl struct {
@@ -46,32 +61,67 @@ func (this MetaImpl) Get(ctx *App, path string) ([]FormElement, error) {
 
 func (this MetaImpl) Set(ctx *App, path string, value []FormElement) error {
 	tenantID := GenerateID(ctx.Session)
-	if len(value) == 0 {
-		_, err := this.db.Exec("DELETE FROM metadata WHERE tenantID=? AND path=?", tenantID, path)
+	tx, err := this.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err = tx.Exec("DELETE FROM metadata_field WHERE tenantID=? AND path=?", tenantID, path); err != nil {
 		return err
 	}
+	if len(value) == 0 {
+		if _, err = tx.Exec("DELETE FROM metadata WHERE tenantID=? AND path=?", tenantID, path); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
 	blob, err := json.Marshal(value)
 	if err != nil {
 		return err
 	}
-	_, err = this.db.Exec(`
+	if _, err = tx.Exec(`
         INSERT INTO metadata (tenantID, path, value) VALUES (?, ?, ?)
         ON CONFLICT(tenantID, path) DO UPDATE SET value=excluded.value
-    `, tenantID, path, string(blob))
-	return err
+    `, tenantID, path, string(blob)); err != nil {
+		return err
+	}
+	if err = indexFields(tx, tenantID, path, value); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
+// Search gives the metadata under a path matching all the facets. A facet is a list of
+// values: tags have to match exactly, other fields have to contain the value. An empty list
+// matches everything where the field is set
 func (this MetaImpl) Search(ctx *App, path string, facets map[string]any) (map[string][]FormElement, error) {
 	tenantID := GenerateID(ctx.Session)
-	rows, err := this.db.QueryContext(ctx.Context, `
-	    SELECT path, value FROM metadata WHERE
-	        tenantID=? AND
-	        path LIKE ?
-            ORDER BY path
-	`, tenantID, path+"%")
+	query := `SELECT path, value FROM metadata WHERE tenantID = ? AND SUBSTR(path, 1, ?) = ?`
+	args := []any{tenantID, utf8.RuneCountInString(path), path}
+	for id, facet := range facets {
+		facetValues, ok := facet.([]any)
+		if !ok {
+			continue
+		} else if len(facetValues) == 0 {
+			query += ` AND path IN (SELECT path FROM metadata_field WHERE tenantID = ? AND field = ?)`
+			args = append(args, tenantID, id)
+			continue
+		}
+		for _, facetValue := range facetValues {
+			if id == "tags" {
+				query += ` AND path IN (SELECT path FROM metadata_field WHERE tenantID = ? AND field = ? AND value = ?)`
+				args = append(args, tenantID, id, strings.TrimSpace(fmt.Sprintf("%s", facetValue)))
+				continue
+			}
+			query += ` AND path IN (SELECT path FROM metadata_field WHERE tenantID = ? AND field = ? AND value LIKE ? ESCAPE '\')`
+			args = append(args, tenantID, id, "%"+likeEscape(fmt.Sprintf("%s", facetValue))+"%")
+		}
+	}
+	rows, err := this.db.QueryContext(ctx.Context, query+" ORDER BY path", args...)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 	out := make(map[string][]FormElement)
 	for rows.Next() {
 		var (
@@ -85,37 +135,87 @@ func (this MetaImpl) Search(ctx *App, path string, facets map[string]any) (map[s
 		if err = json.Unmarshal(metavalue, &metaforms); err != nil {
 			break
 		}
-		isResult := false
-		for _, form := range metaforms {
-			if form.Id == "" || facets[form.Id] == "" {
-				continue
+		chroot := ctx.Session["path"]
+		if key := strings.TrimPrefix(metapath, chroot); key != "" {
+			if !strings.HasPrefix(key, "/") {
+				key = "/" + key
 			}
-			facetValues, ok := facets[form.Id].([]any)
-			if !ok {
+			out[key] = metaforms
+		}
+	}
+	return out, nil
+}
+
+// reindex populates the metadata_field table from the metadata created before it existed
+func (this MetaImpl) reindex() error {
+	var count int
+	if err := this.db.QueryRow("SELECT COUNT(*) FROM metadata_field").Scan(&count); err != nil {
+		return err
+	} else if count > 0 {
+		return nil
+	}
+	rows, err := this.db.Query("SELECT tenantID, path, value FROM metadata")
+	if err != nil {
+		return err
+	}
+	type entry struct {
+		tenantID string
+		path     string
+		forms    []FormElement
+	}
+	entries := []entry{}
+	for rows.Next() {
+		var e entry
+		var blob []byte
+		if err = rows.Scan(&e.tenantID, &e.path, &blob); err != nil {
+			rows.Close()
+			return err
+		} else if err = json.Unmarshal(blob, &e.forms); err != nil {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	rows.Close()
+	if len(entries) == 0 {
+		return nil
+	}
+	tx, err := this.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, e := range entries {
+		if err = indexFields(tx, e.tenantID, e.path, e.forms); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func indexFields(tx *sql.Tx, tenantID string, path string, forms []FormElement) error {
+	for _, form := range forms {
+		if form.Id == "" || form.Value == nil {
+			continue
+		}
+		values := []string{fmt.Sprintf("%v", form.Value)}
+		if form.Id == "tags" {
+			values = strings.Split(values[0], ",")
+		}
+		for _, value := range values {
+			if value = strings.TrimSpace(value); value == "" {
 				continue
 			}
-			formValue := fmt.Sprintf("%s", form.Value)
-			isOK := true
-			for _, facetValue := range facetValues {
-				if strings.Contains(formValue, fmt.Sprintf("%s", facetValue)) == false {
-					isOK = false
-					break
-				}
-			}
-			if isOK {
-				isResult = true
-			}
-		}
-		if isResult {
-			chroot := ctx.Session["path"]
-			if key := strings.TrimPrefix(metapath, chroot); key != "" {
-				if !strings.HasPrefix(key, "/") {
-					key = "/" + key
-				}
-				out[key] = metaforms
+			if _, err := tx.Exec(
+				"INSERT INTO metadata_field (tenantID, path, field, value) VALUES (?, ?, ?, ?)",
+				tenantID, path, form.Id, value,
+			); err != nil {
+				return err
 			}
 		}
 	}
-	rows.Close()
-	return out, nil
+	return nil
+}
+
+func likeEscape(s string) string {
+	return strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_").Replace(s)
 }
@@ -10,7 +10,8 @@ func init() {
 
 type ExampleSearch struct{}
 
-func (this ExampleSearch) Query(app App, path string, keyword string) ([]IFile, error) {
+func (this ExampleSearch) Query(app App, path string, query SearchQuery) ([]IFile, error) {
+	keyword := query.Raw
 	files := []IFile{}
 	files = append(files, File{
 		FName: "keyword-" + keyword + ".txt",
@@ -64,6 +64,16 @@ func (this FileHook) Mv(ctx *App, from string, to string) error {
 	return nil
 }
 
+func (this FileHook) Cp(ctx *App, from string, to string) error {
+	if this.record(ctx) {
+		go func() {
+			DaemonState.HintLs(ctx, filepath.Dir(to)+"/")
+			DaemonState.HintLs(ctx, to+"/")
+		}()
+	}
+	return nil
+}
+
 func (this FileHook) Save(ctx *App, path string) error {
 	if this.record(ctx) {
 		go func() {
@@ -13,7 +13,7 @@ import (
 
 type Index interface {
 	Init() error
-	Search(path string, q string) ([]IFile, error)
+	Search(path string, query SearchQuery) ([]IFile, error)
 	Change() (Manager, error)
 	Close() error
 }
@@ -206,7 +206,7 @@ func (this sqliteQueries) FindParent(path string) (RowMapper, error) {
 func (this sqliteQueries) FileMetaUpdate(path string, f fs.FileInfo) error {
 	_, err := this.tx.Exec(
 		"UPDATE file SET size = ?, modTime = ? indexTime = NULL WHERE path = ?",
-		f.Size(), f.ModTime(), path,
+		f.Size(), f.ModTime().UTC(), path,
 	)
 	return toErr(err)
 }
@@ -234,7 +234,7 @@ func (this sqliteQueries) FileCreate(f fs.FileInfo, parentPath string) (err erro
 			name,
 			"directory",
 			f.Size(),
-			f.ModTime(),
+			f.ModTime().UTC(),
 			time.Now(),
 		)
 	} else {
@@ -246,7 +246,7 @@ func (this sqliteQueries) FileCreate(f fs.FileInfo, parentPath string) (err erro
 			name,
 			"file",
 			f.Size(),
-			f.ModTime(),
+			f.ModTime().UTC(),
 			nil,
 			strings.TrimPrefix(filepath.Ext(name), "."),
 		)
@@ -1,40 +1,166 @@
 package indexer
 
 import (
+	"encoding/json"
 	"path/filepath"
-	"regexp"
+	"strings"
 	"time"
 
 	. "github.com/mickael-kerjean/filestash/server/common"
 )
 
-func (this sqliteIndex) Search(path string, q string) ([]IFile, error) {
+func (this sqliteIndex) Search(path string, query SearchQuery) ([]IFile, error) {
 	files := []IFile{}
-	rows, err := this.db.Query(
-		"SELECT type, path, size, modTime FROM file WHERE path IN ("+
-			"   SELECT path FROM file_index WHERE file_index MATCH ? AND path > ? AND path < ?"+
-			"   ORDER BY rank LIMIT 50000"+
-			")",
-		regexp.MustCompile(`(\.|\-)`).ReplaceAllString(q, "\"$1\""),
-		path, path+"~",
-	)
+	where, args := compileQuery(query.Root)
+	sqlQuery := "SELECT f.type, f.path, f.size, f.modTime, 0, '' FROM file f " +
+		"WHERE f.path > ? AND f.path < ? AND " + where + " " +
+		"ORDER BY f.modTime DESC LIMIT 50000"
+	args = append([]any{path, path + "~"}, args...)
+	if rank := ftsRanking(query); rank != "" {
+		sqlQuery = "SELECT f.type, f.path, f.size, f.modTime, IFNULL(m.rank, 0), IFNULL(m.snippet, '') FROM file f " +
+			"LEFT JOIN (" +
+			"   SELECT path, rank, snippet(file_index, -1, '<mark>', '</mark>', '…', 16) AS snippet" +
+			"   FROM file_index WHERE file_index MATCH ?" +
+			") m ON m.path = f.path " +
+			"WHERE f.path > ? AND f.path < ? AND " + where + " " +
+			"ORDER BY m.rank IS NULL, m.rank LIMIT 50000"
+		args = append([]any{rank}, args...)
+	}
+	rows, err := this.db.Query(sqlQuery, args...)
 	if err != nil {
 		Log.Warning("search::query DBQuery (%s)", err.Error())
 		return files, ErrNotReachable
 	}
 	defer rows.Close()
 	for rows.Next() {
-		f := File{}
+		f := SearchResult{}
 		var t string
-		if err = rows.Scan(&f.FType, &f.FPath, &f.FSize, &t); err != nil {
+		if err = rows.Scan(&f.FType, &f.FPath, &f.FSize, &t, &f.Score, &f.Snippet); err != nil {
 			Log.Warning("search::query scan (%s)", err.Error())
 			return files, ErrNotReachable
 		}
 		if tm, err := time.Parse(time.RFC3339, t); err == nil {
 			f.FTime = tm.Unix() * 1000
 		}
+		f.Score = -f.Score // bm25 gives negative numbers, the lower the better
 		f.FName = filepath.Base(f.FPath)
 		files = append(files, f)
 	}
 	return files, nil
 }
+
+// compileQuery turns a query onto the where clause of a SQL statement. Free text is matched
+// through the full text index while filters are applied on the file table
+func compileQuery(node *SearchNode) (string, []any) {
+	if node == nil {
+		return "1", []any{}
+	}
+	switch node.Kind {
+	case SEARCH_AND, SEARCH_OR:
+		clauses := []string{}
+		args := []any{}
+		for _, child := range node.Children {
+			clause, a := compileQuery(child)
+			clauses = append(clauses, clause)
+			args = append(args, a...)
+		}
+		return "(" + strings.Join(clauses, " "+strings.ToUpper(node.Kind)+" ") + ")", args
+	case SEARCH_NOT:
+		clause, args := compileQuery(node.Children[0])
+		return "NOT " + clause, args
+	case SEARCH_TERM:
+		if strings.Trim(node.Value, "*") == "" {
+			return "1", []any{}
+		}
+		return "f.path IN (SELECT path FROM file_index WHERE file_index MATCH ?)", []any{ftsTerm(node)}
+	}
+
+	switch node.Field {
+	case "type":
+		switch node.Value {
+		case "directory", "folder", "dir":
+			return "f.type = 'directory'", []any{}
+		case "file":
+			return "f.type = 'file'", []any{}
+		}
+		exts := SearchTypeExtensions(node.Value)
+		args := make([]any, len(exts))
+		for i := range exts {
+			args[i] = exts[i]
+		}
+		return "LOWER(f.filetype) IN (?" + strings.Repeat(", ?", len(exts)-1) + ")", args
+	case "ext":
+		return "LOWER(f.filetype) = ?", []any{node.Value}
+	case "size":
+		return "(f.type = 'file' AND f.size " + sqlOperator(node.Operator) + " ?)", []any{node.Size}
+	case "modified":
+		// times are stored in UTC as text starting with the date: "2006-01-02 15:04:05..."
+		const layout = "2006-01-02 15:04:05"
+		modTime := "SUBSTR(f.modTime, 1, 19)"
+		from, to := node.From.UTC().Format(layout), node.To.UTC().Format(layout)
+		switch node.Operator {
+		case "<":
+			return modTime + " < ?", []any{from}
+		case "<=":
+			return modTime + " < ?", []any{to}
+		case ">":
+			return modTime + " >= ?", []any{to}
+		case ">=":
+			return modTime + " >= ?", []any{from}
+		}
+		return "(" + modTime + " >= ? AND " + modTime + " < ?)", []any{from, to}
+	case "path":
+		return "(f.path >= ? AND f.path < ?)", []any{node.Value, node.Value + "~"}
+	case "name":
+		return "f.filename LIKE ? ESCAPE '\\'", []any{globToLike(node.Value)}
+	}
+	if node.IsMetadata() {
+		paths := make([]string, 0, len(node.Paths))
+		for path := range node.Paths {
+			paths = append(paths, path)
+		}
+		j, _ := json.Marshal(paths)
+		return "f.path IN (SELECT value FROM json_each(?))", []any{string(j)}
+	}
+	return "0", []any{}
+}
+
+// ftsTerm escapes free text for the fts5 query syntax. A trailing star does a prefix search
+func ftsTerm(node *SearchNode) string {
+	value := node.Value
+	prefix := false
+	if node.Phrase == false && strings.HasSuffix(value, "*") {
+		value = strings.TrimRight(value, "*")
+		prefix = true
+	}
+	term := "\"" + strings.ReplaceAll(value, "\"", "\"\"") + "\""
+	if prefix {
+		term += "*"
+	}
+	return term
+}
+
+// ftsRanking is the query used to rank results and generate their snippets
+func ftsRanking(query SearchQuery) string {
+	terms := []string{}
+	for _, term := range query.Terms() {
+		if strings.Trim(term.Value, "*") == "" {
+			continue
+		}
+		terms = append(terms, ftsTerm(term))
+	}
+	return strings.Join(terms, " OR ")
+}
+
+func sqlOperator(op string) string {
+	switch op {
+	case ">", ">=", "<", "<=":
+		return op
+	}
+	return "="
+}
+
+func globToLike(pattern string) string {
+	r := strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_", "*", "%", "?", "_")
+	return r.Replace(pattern)
+}
@@ -7,7 +7,7 @@ import (
 
 type SearchEngine struct{}
 
-func (this SearchEngine) Query(app App, path string, keyword string) ([]IFile, error) {
+func (this SearchEngine) Query(app App, path string, query SearchQuery) ([]IFile, error) {
 	DaemonState.HintLs(&app, path)
 	s := GetCrawler(&app)
 	if s == nil {
@@ -16,5 +16,5 @@ func (this SearchEngine) Query(app App, path string, keyword string) ([]IFile, e
 	if path == "" {
 		path = "/"
 	}
-	return s.State.Search(path, keyword)
+	return s.State.Search(path, query)
 }
@@ -1,6 +1,7 @@
 package plg_search_stateless
 
 import (
+	"sort"
 	"strings"
 	"time"
 
@@ -18,14 +19,14 @@ type PathQuandidate struct {
 
 type StatelessSearch struct{}
 
-func (this StatelessSearch) Query(app App, path string, keyword string) ([]IFile, error) {
+func (this StatelessSearch) Query(app App, path string, query SearchQuery) ([]IFile, error) {
 	files := make([]IFile, 0)
 	toVisit := []PathQuandidate{PathQuandidate{path, 0}}
 	MAX_SEARCH_TIME := SEARCH_TIMEOUT()
 
 	for start := time.Now(); time.Since(start) < MAX_SEARCH_TIME; {
 		if len(toVisit) == 0 {
-			return files, nil
+			return rank(files), nil
 		}
 		currentPath := toVisit[0]
 		if len(toVisit) == 0 {
@@ -43,32 +44,31 @@ func (this StatelessSearch) Query(app App, path string, keyword string) ([]IFile
 		score1 := scoreBoostForFilesInDirectory(f)
 		for i := 0; i < len(f); i++ {
 			name := f[i].Name()
-			if isAMatch := IsSearchQueryMatchingFilename(
-				[]rune(strings.ToLower(name)),
-				[]rune(strings.ToLower(keyword)),
-			); isAMatch {
-				files = append(files, File{
-					FName: name,
-					FType: func() string {
-						if f[i].IsDir() {
-							return "directory"
-						}
-						return "file"
-					}(),
-					FSize: f[i].Size(),
-					FTime: f[i].ModTime().Unix() * 1000,
-					FPath: func() string {
-						p := JoinPath(currentPath.Path, name)
-						if f[i].IsDir() {
-							p = p + "/"
-						}
-						return p
-					}(),
+			fullpath := JoinPath(currentPath.Path, name)
+			if f[i].IsDir() {
+				fullpath += "/"
+			}
+			if isAMatch := query.Match(fullpath, f[i], func(term *SearchNode) bool {
+				return matchFilename(name, term)
+			}); isAMatch {
+				files = append(files, SearchResult{
+					File: File{
+						FName: name,
+						FType: func() string {
+							if f[i].IsDir() {
+								return "directory"
+							}
+							return "file"
+						}(),
+						FSize: f[i].Size(),
+						FTime: f[i].ModTime().Unix() * 1000,
+						FPath: fullpath,
+					},
+					Score: scoreMatch(name, query),
 				})
 			}
 
 			// follow directories
-			fullpath := currentPath.Path + name + "/"
 			relativePath := strings.ToLower(strings.TrimSuffix(strings.TrimPrefix(fullpath, path), "/"))
 			score2 := scoreBoostOnDepth(relativePath) * 2
 			if f[i].IsDir() {
@@ -95,5 +95,22 @@ func (this StatelessSearch) Query(app App, path string, keyword string) ([]IFile
 			}
 		}
 	}
-	return files, nil
+	return rank(files), nil
+}
+
+func matchFilename(name string, term *SearchNode) bool {
+	if term.Phrase {
+		return strings.Contains(strings.ToLower(name), strings.ToLower(term.Value))
+	}
+	return IsSearchQueryMatchingFilename(
+		[]rune(strings.ToLower(name)),
+		[]rune(strings.ToLower(term.Value)),
+	)
+}
+
+func rank(files []IFile) []IFile {
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].(SearchResult).Score > files[j].(SearchResult).Score
+	})
+	return files
 }
@@ -4,6 +4,8 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+
+	. "github.com/mickael-kerjean/filestash/server/common"
 )
 
 func scoreBoostForPath(p string) int {
@@ -104,3 +106,23 @@ func IsSearchQueryMatchingFilename(strRune []rune, patternRune []rune) bool {
 	}
 	return false
 }
+
+// scoreMatch ranks a result on how close its name is from what was searched for
+func scoreMatch(name string, query SearchQuery) float64 {
+	name = strings.ToLower(name)
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	score := 0.0
+	for _, term := range query.Terms() {
+		value := strings.ToLower(strings.Trim(term.Value, "*"))
+		if value == "" {
+			continue
+		} else if base == value || name == value {
+			score += 3
+		} else if strings.HasPrefix(name, value) {
+			score += 2
+		} else if strings.Contains(name, value) {
+			score += 1
+		}
+	}
+	return score
+}
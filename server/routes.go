@@ -13,6 +13,7 @@ import (
 	. "github.com/mickael-kerjean/filestash/server/common"
 	. "github.com/mickael-kerjean/filestash/server/ctrl"
 	. "github.com/mickael-kerjean/filestash/server/middleware"
+	"github.com/mickael-kerjean/filestash/server/pkg/transfer"
 	. "github.com/mickael-kerjean/filestash/server/pkg/workflow"
 )
 
@@ -46,6 +47,11 @@ func Build(r *mux.Router) {
 	admin.HandleFunc("/workflow", NewMiddlewareChain(WorkflowDelete, middlewares)).Methods("DELETE")
 	admin.HandleFunc("/middlewares/authentication", NewMiddlewareChain(AdminAuthenticationMiddleware, middlewares)).Methods("GET")
 	admin.HandleFunc("/audit", NewMiddlewareChain(FetchAuditHandler, middlewares)).Methods("GET")
+	admin.HandleFunc("/sessions", NewMiddlewareChain(AdminSessionList, middlewares)).Methods("GET")
+	admin.HandleFunc("/sessions/{sessionID}", NewMiddlewareChain(AdminSessionRevoke, middlewares)).Methods("DELETE")
+	admin.HandleFunc("/locks", NewMiddlewareChain(AdminLockList, middlewares)).Methods("GET")
+	admin.HandleFunc("/locks/{lockID}", NewMiddlewareChain(AdminLockRelease, middlewares)).Methods("DELETE")
+	admin.HandleFunc("/thumbnails", NewMiddlewareChain(AdminThumbnailPurge, middlewares)).Methods("DELETE")
 	middlewares = []Middleware{IndexHeaders, AdminOnly, PluginInjector}
 	admin.HandleFunc("/logs", NewMiddlewareChain(FetchLogHandler, middlewares)).Methods("GET")
 
@@ -62,12 +68,22 @@ func Build(r *mux.Router) {
 	files.HandleFunc("/save", NewMiddlewareChain(FileSave, middlewares)).Methods("POST", "PATCH", "HEAD", "OPTIONS")
 	files.HandleFunc("/ls", NewMiddlewareChain(FileLs, middlewares)).Methods("GET")
 	files.HandleFunc("/mv", NewMiddlewareChain(FileMv, middlewares)).Methods("POST")
+	files.HandleFunc("/cp", NewMiddlewareChain(FileCp, middlewares)).Methods("POST")
 	files.HandleFunc("/rm", NewMiddlewareChain(FileRm, middlewares)).Methods("POST")
 	files.HandleFunc("/mkdir", NewMiddlewareChain(FileMkdir, middlewares)).Methods("POST")
 	files.HandleFunc("/touch", NewMiddlewareChain(FileTouch, middlewares)).Methods("POST")
 	middlewares = []Middleware{ApiHeaders, SecureHeaders, SecureOrigin, SessionStart, LoggedInOnly, PluginInjector}
 	files.HandleFunc("/search", NewMiddlewareChain(FileSearch, middlewares)).Methods("GET")
 
+	// API for background transfers between storages
+	transfers := r.PathPrefix(WithBase("/api/transfer")).Subrouter()
+	middlewares = []Middleware{ApiHeaders, SecureHeaders, SecureOrigin, SessionStart, LoggedInOnly, PluginInjector}
+	transfers.HandleFunc("", NewMiddlewareChain(transfer.List, middlewares)).Methods("GET")
+	transfers.HandleFunc("", NewMiddlewareChain(transfer.Create, middlewares)).Methods("POST")
+	transfers.HandleFunc("/{transferID}", NewMiddlewareChain(transfer.Status, middlewares)).Methods("GET")
+	transfers.HandleFunc("/{transferID}/cancel", NewMiddlewareChain(transfer.Cancel, middlewares)).Methods("POST")
+	transfers.HandleFunc("/{transferID}/resume", NewMiddlewareChain(transfer.Resume, middlewares)).Methods("POST")
+
 	// API for Shared link
 	share := r.PathPrefix(WithBase("/api/share")).Subrouter()
 	middlewares = []Middleware{ApiHeaders, SecureHeaders, SecureOrigin, SessionStart, LoggedInOnly, PluginInjector}
@@ -79,6 +95,14 @@ func Build(r *mux.Router) {
 	middlewares = []Middleware{ApiHeaders, SecureHeaders, SecureOrigin, BodyParser, CanManageShare, PluginInjector}
 	share.HandleFunc("/{share}", NewMiddlewareChain(ShareUpsert, middlewares)).Methods("POST")
 
+	// API for Personal access tokens
+	tokens := r.PathPrefix(WithBase("/api/tokens")).Subrouter()
+	middlewares = []Middleware{ApiHeaders, SecureHeaders, SecureOrigin, SessionStart, LoggedInOnly, PluginInjector}
+	tokens.HandleFunc("", NewMiddlewareChain(TokenList, middlewares)).Methods("GET")
+	tokens.HandleFunc("/{tokenID}", NewMiddlewareChain(TokenDelete, middlewares)).Methods("DELETE")
+	middlewares = []Middleware{ApiHeaders, SecureHeaders, SecureOrigin, SessionStart, LoggedInOnly, BodyParser, PluginInjector}
+	tokens.HandleFunc("", NewMiddlewareChain(TokenCreate, middlewares)).Methods("POST")
+
 	meta := r.PathPrefix(WithBase("/api/metadata")).Subrouter()
 	middlewares = []Middleware{ApiHeaders, SecureHeaders, SecureOrigin, SessionStart, LoggedInOnly, PluginInjector}
 	meta.HandleFunc("", NewMiddlewareChain(MetaGet, middlewares)).Methods("GET")
@@ -91,6 +115,10 @@ func Build(r *mux.Router) {
 	middlewares = []Middleware{WebdavBlacklist, SessionStart, PluginInjector}
 	r.PathPrefix(WithBase("/s/{share}")).Handler(NewMiddlewareChain(WebdavHandler, middlewares))
 
+	// Webdav server / Logged in user
+	middlewares = []Middleware{WebdavBlacklist, WebdavAuthenticate, PluginInjector}
+	r.PathPrefix(WithBase("/dav/")).Handler(NewMiddlewareChain(WebdavUserHandler, middlewares))
+
 	// Application Resources
 	middlewares = []Middleware{ApiHeaders, SecureHeaders, PluginInjector}
 	r.HandleFunc(WithBase("/api/backend"), NewMiddlewareChain(AdminBackend, middlewares)).Methods("GET")